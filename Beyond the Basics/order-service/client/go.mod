@@ -3,8 +3,22 @@ module interceptors/cliente
 go 1.15
 
 require (
+	comun/cache v0.0.0
+	comun/orca v0.0.0
+	comun/tracing v0.0.0
 	github.com/golang/protobuf v1.4.3
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a
 	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.33.1
 	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.3.0
+	registry/servidor v0.0.0
 )
+
+replace comun/tracing => ../../../comun/tracing
+
+replace registry/servidor => ../registry
+
+replace comun/cache => ../../../comun/cache
+
+replace comun/orca => ../../../comun/orca
@@ -0,0 +1,110 @@
+package interceptors
+
+import (
+	"context"
+
+	"comun/cache"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//CachedReadMethods lecturas que se pueden cachear
+var CachedReadMethods = []string{
+	"/ecommerce.OrderManagement/getOrder",
+	"/ecommerce.ProductInfo/getProduct",
+}
+
+//CacheInvalidatingMethods escrituras que invalidan la caché
+var CacheInvalidatingMethods = []string{
+	"/ecommerce.OrderManagement/addOrder",
+	"/ecommerce.OrderManagement/updateOrders",
+	"/ecommerce.ProductInfo/addProduct",
+}
+
+//ResponseCache caché de respuestas en el cliente. Solo la invalidan las escrituras que pasan por sus interceptores, es decir,
+//las de las conexiones que la usan. Las escrituras de otros clientes, o de este por otra conexión, no se ven hasta que caduca
+//la entrada, así que el TTL es el tiempo máximo durante el que se pueden leer datos antiguos y conviene que sea corto
+type ResponseCache struct {
+	cache  *cache.Cache
+	reads  map[string]bool
+	writes map[string]bool
+}
+
+//NewResponseCache crea la caché. reads son los métodos que se cachean y writes los que la invalidan, con el nombre completo
+func NewResponseCache(c *cache.Cache, reads, writes []string) *ResponseCache {
+	rc := &ResponseCache{cache: c, reads: map[string]bool{}, writes: map[string]bool{}}
+	for _, m := range reads {
+		rc.reads[m] = true
+	}
+	for _, m := range writes {
+		rc.writes[m] = true
+	}
+	return rc
+}
+
+//UnaryClientInterceptor sirve las lecturas desde la caché e invalida la caché con las escrituras
+func (rc *ResponseCache) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if rc.writes[method] {
+			//Antes y después, como en el servidor. Las lecturas en curso no guardan su respuesta, ver SetIfGeneration
+			rc.cache.Purge()
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			rc.cache.Purge()
+			return err
+		}
+		msg, ok := reply.(proto.Message)
+		if !rc.reads[method] || !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		//Cada target tiene sus propias respuestas
+		key, ok := cache.CacheKey(cc.Target(), method, req)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		//El metadato cache-control, cache.CacheControlKey, viaja también al servidor, que aplica lo mismo a su caché
+		md, _ := metadata.FromOutgoingContext(ctx)
+		noCache, noStore := cache.CacheDirectives(md)
+		if !noCache {
+			if v, ok := rc.cache.Get(key); ok {
+				msg.Reset()
+				proto.Merge(msg, v.(proto.Message))
+				return nil
+			}
+		}
+		gen := rc.cache.Generation()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil && !noStore {
+			rc.cache.SetIfGeneration(key, proto.Clone(msg), gen)
+		}
+		return err
+	}
+}
+
+//StreamClientInterceptor invalida la caché con las escrituras que van por un stream, como updateOrders
+func (rc *ResponseCache) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !rc.writes[method] {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		rc.cache.Purge()
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &invalidatingStream{ClientStream: s, cache: rc.cache}, nil
+	}
+}
+
+//invalidatingStream vuelve a invalidar la caché cuando termina el stream
+type invalidatingStream struct {
+	grpc.ClientStream
+	cache *cache.Cache
+}
+
+func (s *invalidatingStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	s.cache.Purge()
+	return err
+}
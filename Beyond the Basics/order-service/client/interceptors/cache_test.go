@@ -0,0 +1,155 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"comun/cache"
+	pb "interceptors/cliente/ecommerce"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	cachedMethod = "/ecommerce.OrderManagement/getOrder"
+	writeMethod  = "/ecommerce.OrderManagement/addOrder"
+)
+
+//cacheInvoker invoker de la prueba que cuenta las llamadas y responde una orden con el número de llamada como destino
+type cacheInvoker struct {
+	calls int
+}
+
+func (i *cacheInvoker) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+	i.calls++
+	if o, ok := reply.(*pb.Order); ok {
+		o.Id = req.(*wrappers.StringValue).Value
+		o.Destination = string(rune('0' + i.calls))
+	}
+	return nil
+}
+
+//cachedCall hace una llamada unitaria a method por la caché y devuelve la respuesta
+func cachedCall(t *testing.T, rc *ResponseCache, i *cacheInvoker, ctx context.Context, method, id string) *pb.Order {
+	t.Helper()
+	cc, err := grpc.Dial("passthrough:///orders", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	reply := &pb.Order{}
+	if err := rc.UnaryClientInterceptor()(ctx, method, &wrappers.StringValue{Value: id}, reply, cc, i.invoke); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func testResponseCache(name string, ttl time.Duration) *ResponseCache {
+	return NewResponseCache(cache.New(name, 10, ttl), []string{cachedMethod}, []string{writeMethod})
+}
+
+func TestResponseCacheHit(t *testing.T) {
+	rc, i := testResponseCache("test_hit", time.Minute), &cacheInvoker{}
+	ctx := context.Background()
+
+	first := cachedCall(t, rc, i, ctx, cachedMethod, "106")
+	second := cachedCall(t, rc, i, ctx, cachedMethod, "106")
+	if i.calls != 1 || second.Destination != first.Destination {
+		t.Fatalf("%d llamadas, respuesta %v: la segunda lectura sale de la caché", i.calls, second)
+	}
+	//La respuesta es una copia: cambiarla no cambia la caché
+	second.Destination = "cambiada"
+	if third := cachedCall(t, rc, i, ctx, cachedMethod, "106"); third.Destination != first.Destination {
+		t.Errorf("la caché devuelve %q después de cambiar una respuesta", third.Destination)
+	}
+
+	//Otra petición es otra entrada
+	cachedCall(t, rc, i, ctx, cachedMethod, "107")
+	if i.calls != 2 {
+		t.Errorf("%d llamadas, quería 2: cada petición tiene su entrada", i.calls)
+	}
+
+	//Las escrituras invalidan la caché
+	cachedCall(t, rc, i, ctx, writeMethod, "108")
+	cachedCall(t, rc, i, ctx, cachedMethod, "106")
+	if i.calls != 4 {
+		t.Errorf("%d llamadas, quería 4: la escritura invalida la caché", i.calls)
+	}
+}
+
+func TestResponseCacheDirectives(t *testing.T) {
+	rc, i := testResponseCache("test_directives", time.Minute), &cacheInvoker{}
+	ctx := context.Background()
+	cachedCall(t, rc, i, ctx, cachedMethod, "106")
+
+	//no-cache no lee de la caché pero guarda la respuesta nueva
+	noCache := metadata.AppendToOutgoingContext(ctx, cache.CacheControlKey, "no-cache")
+	fresh := cachedCall(t, rc, i, noCache, cachedMethod, "106")
+	if i.calls != 2 {
+		t.Fatalf("%d llamadas, quería 2: no-cache no lee de la caché", i.calls)
+	}
+	if o := cachedCall(t, rc, i, ctx, cachedMethod, "106"); i.calls != 2 || o.Destination != fresh.Destination {
+		t.Errorf("%d llamadas, respuesta %v: no-cache guarda la respuesta", i.calls, o)
+	}
+
+	//no-store ni lee ni guarda
+	noStore := metadata.AppendToOutgoingContext(ctx, cache.CacheControlKey, "no-store")
+	cachedCall(t, rc, i, noStore, cachedMethod, "107")
+	cachedCall(t, rc, i, noStore, cachedMethod, "107")
+	if i.calls != 4 {
+		t.Fatalf("%d llamadas, quería 4: no-store no lee de la caché", i.calls)
+	}
+	cachedCall(t, rc, i, ctx, cachedMethod, "107")
+	if i.calls != 5 {
+		t.Errorf("%d llamadas, quería 5: no-store no guarda la respuesta", i.calls)
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	rc, i := testResponseCache("test_ttl", ttl), &cacheInvoker{}
+	ctx := context.Background()
+
+	cachedCall(t, rc, i, ctx, cachedMethod, "106")
+	cachedCall(t, rc, i, ctx, cachedMethod, "106")
+	if i.calls != 1 {
+		t.Fatalf("%d llamadas antes del TTL, quería 1", i.calls)
+	}
+	time.Sleep(2 * ttl)
+	cachedCall(t, rc, i, ctx, cachedMethod, "106")
+	if i.calls != 2 {
+		t.Errorf("%d llamadas después del TTL, quería 2: la entrada caduca", i.calls)
+	}
+}
+
+func TestResponseCacheNonCacheable(t *testing.T) {
+	rc, i := testResponseCache("test_noncacheable", time.Minute), &cacheInvoker{}
+	ctx := context.Background()
+
+	//Los métodos que no están en reads siempre llaman
+	for n := 0; n < 3; n++ {
+		cachedCall(t, rc, i, ctx, "/ecommerce.OrderManagement/otherRead", "106")
+	}
+	if i.calls != 3 {
+		t.Errorf("%d llamadas a un método que no se cachea, quería 3", i.calls)
+	}
+
+	//Ni las respuestas que no son protobuf
+	cc, err := grpc.Dial("passthrough:///orders", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	for n := 0; n < 2; n++ {
+		var reply string
+		if err := rc.UnaryClientInterceptor()(ctx, cachedMethod, &wrappers.StringValue{Value: "106"}, &reply, cc, i.invoke); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if i.calls != 5 {
+		t.Errorf("%d llamadas, quería 5: las respuestas que no son protobuf no se cachean", i.calls)
+	}
+}
@@ -0,0 +1,387 @@
+package interceptors
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//CircuitState estado de un circuito
+type CircuitState int
+
+const (
+	//CircuitClosed las llamadas pasan con normalidad
+	CircuitClosed CircuitState = iota
+	//CircuitOpen las llamadas se rechazan sin llegar al backend
+	CircuitOpen
+	//CircuitHalfOpen se permiten unas pocas llamadas de prueba
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+//Métricas del circuit breaker, publicadas en /debug/vars
+var circuitBreakerMetrics = expvar.NewMap("circuit_breaker")
+
+//CircuitBreakerConfig configuración del circuit breaker
+type CircuitBreakerConfig struct {
+	//Número de fallos consecutivos que abren el circuito
+	ConsecutiveFailures int
+	//Ratio de fallos (0-1) dentro de la ventana que abre el circuito. 0 lo desactiva
+	FailureRatio float64
+	//Mínimo de llamadas en la ventana antes de evaluar FailureRatio
+	MinRequests int
+	//Duración de la ventana en la que se cuentan llamadas y fallos
+	Interval time.Duration
+	//Tiempo que el circuito permanece abierto antes de pasar a semi-abierto
+	OpenTimeout time.Duration
+	//Llamadas de prueba concurrentes permitidas en semi-abierto
+	HalfOpenMaxCalls int
+	//Éxitos en semi-abierto necesarios para volver a cerrar el circuito
+	SuccessThreshold int
+	//IsFailure decide si un error cuenta como fallo del backend. Por defecto Unavailable, DeadlineExceeded, ResourceExhausted e Internal
+	IsFailure func(error) bool
+	//OnStateChange se invoca en cada cambio de estado. Se llama con el circuit breaker bloqueado, así que no debe invocarlo
+	OnStateChange func(name, addr string, from, to CircuitState)
+}
+
+//DefaultCircuitBreakerConfig configuración por defecto
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		ConsecutiveFailures: 5,
+		FailureRatio:        0.5,
+		MinRequests:         10,
+		Interval:            30 * time.Second,
+		OpenTimeout:         10 * time.Second,
+		HalfOpenMaxCalls:    1,
+		SuccessThreshold:    1,
+		IsFailure:           isBackendFailure,
+	}
+}
+
+func isBackendFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+		return true
+	}
+	return false
+}
+
+//unknownAddr dirección de las llamadas que fallan sin llegar a conectar con ningún backend
+const unknownAddr = "*"
+
+//breaker estado del circuito de un backend
+type breaker struct {
+	state               CircuitState
+	consecutiveFailures int
+	requests            int
+	failures            int
+	windowStart         time.Time
+	openedAt            time.Time
+	halfOpenAt          time.Time
+	halfOpenSuccesses   int
+}
+
+//circuitGroup circuitos de un target y un método, uno por cada dirección resuelta que ha recibido llamadas
+type circuitGroup struct {
+	name     string
+	breakers map[string]*breaker
+	//Llamadas de prueba en curso, cuando ningún circuito del grupo está cerrado
+	probes int
+}
+
+//admitted llamada que ha dejado salir el circuit breaker
+type admitted struct {
+	name  string
+	probe bool
+	start time.Time
+}
+
+//CircuitBreaker interceptor de cliente que implementa un circuit breaker por target, método y dirección resuelta. La
+//dirección es la del backend que atendió la llamada, según grpc.Peer. El interceptor no elige el backend, eso lo hace el
+//balanceador después, así que una llamada solo se rechaza cuando están abiertos los circuitos de todas las direcciones
+//conocidas. Para que el balanceador deje de enviar llamadas a un backend concreto está la detección de outliers del paquete lb
+type CircuitBreaker struct {
+	cfg    CircuitBreakerConfig
+	mu     sync.Mutex
+	groups map[string]*circuitGroup
+	now    func() time.Time
+}
+
+//NewCircuitBreaker crea un circuit breaker. Los valores no informados toman los de DefaultCircuitBreakerConfig
+func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	def := DefaultCircuitBreakerConfig()
+	if cfg.ConsecutiveFailures <= 0 {
+		cfg.ConsecutiveFailures = def.ConsecutiveFailures
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = def.MinRequests
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = def.OpenTimeout
+	}
+	if cfg.HalfOpenMaxCalls <= 0 {
+		cfg.HalfOpenMaxCalls = def.HalfOpenMaxCalls
+	}
+	if cfg.SuccessThreshold <= 0 {
+		cfg.SuccessThreshold = def.SuccessThreshold
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = def.IsFailure
+	}
+	return &CircuitBreaker{cfg: cfg, groups: make(map[string]*circuitGroup), now: time.Now}
+}
+
+//State devuelve el estado del circuito de un target, método y dirección
+func (cb *CircuitBreaker) State(target, method, addr string) CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	g, ok := cb.groups[target+method]
+	if !ok {
+		return CircuitClosed
+	}
+	b, ok := g.breakers[addr]
+	if !ok {
+		return CircuitClosed
+	}
+	cb.refresh(g, addr, b, cb.now())
+	return b.state
+}
+
+//UnaryClientInterceptor interceptor unitario del circuit breaker
+func (cb *CircuitBreaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		a, err := cb.allow(cc.Target() + method)
+		if err != nil {
+			return err
+		}
+		var p peer.Peer
+		err = invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+		cb.done(a, peerAddr(&p), err)
+		return err
+	}
+}
+
+//StreamClientInterceptor interceptor de streams del circuit breaker. El resultado se registra al terminar el stream, o al
+//cancelarse su contexto si el cliente lo abandona sin leerlo hasta el final; si no, una llamada de prueba abandonada
+//ocuparía para siempre su hueco en semi-abierto
+func (cb *CircuitBreaker) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		a, err := cb.allow(cc.Target() + method)
+		if err != nil {
+			return nil, err
+		}
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cb.done(a, unknownAddr, err)
+			return nil, err
+		}
+		//El transporte del stream ya está elegido al abrirlo: su contexto tiene el peer
+		addr := unknownAddr
+		if s != nil {
+			if p, ok := peer.FromContext(s.Context()); ok {
+				addr = peerAddr(p)
+			}
+		}
+		cs := &circuitStream{ClientStream: s, serverStreams: desc.ServerStreams, finished: make(chan struct{}), finish: func(err error) { cb.done(a, addr, err) }}
+		go func() {
+			select {
+			case <-ctx.Done():
+				cs.end(status.FromContextError(ctx.Err()).Err())
+			case <-cs.finished:
+			}
+		}()
+		return cs, nil
+	}
+}
+
+//circuitStream registra el resultado del stream cuando termina
+type circuitStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	once          sync.Once
+	finished      chan struct{}
+	finish        func(error)
+}
+
+//end registra el resultado una sola vez
+func (s *circuitStream) end(err error) {
+	s.once.Do(func() {
+		close(s.finished)
+		s.finish(err)
+	})
+}
+
+func (s *circuitStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.end(nil)
+	case err != nil:
+		s.end(err)
+	case !s.serverStreams:
+		//Sin stream del servidor la primera respuesta es también la última
+		s.end(nil)
+	}
+	return err
+}
+
+func peerAddr(p *peer.Peer) string {
+	if p == nil || p.Addr == nil {
+		return unknownAddr
+	}
+	return p.Addr.String()
+}
+
+//group devuelve los circuitos de name, creándolos si no existen
+func (cb *CircuitBreaker) group(name string) *circuitGroup {
+	g, ok := cb.groups[name]
+	if !ok {
+		g = &circuitGroup{name: name, breakers: make(map[string]*breaker)}
+		cb.groups[name] = g
+	}
+	return g
+}
+
+//allow decide si la llamada puede salir. Sale si el circuito de alguna dirección está cerrado, o como llamada de prueba si
+//alguno está semi-abierto y quedan huecos de prueba
+func (cb *CircuitBreaker) allow(name string) (*admitted, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := cb.now()
+	g := cb.group(name)
+	a := &admitted{name: name, start: now}
+	if len(g.breakers) == 0 {
+		return a, nil
+	}
+	halfOpen := false
+	for addr, b := range g.breakers {
+		cb.refresh(g, addr, b, now)
+		switch b.state {
+		case CircuitClosed:
+			return a, nil
+		case CircuitHalfOpen:
+			halfOpen = true
+		}
+	}
+	if halfOpen && g.probes < cb.cfg.HalfOpenMaxCalls {
+		g.probes++
+		a.probe = true
+		return a, nil
+	}
+	circuitBreakerMetrics.Add("rejected", 1)
+	return nil, status.Errorf(codes.Unavailable, "circuit breaker abierto para %s", name)
+}
+
+//refresh pasa el circuito de abierto a semi-abierto cuando ha vencido OpenTimeout, y renueva la ventana de conteo
+func (cb *CircuitBreaker) refresh(g *circuitGroup, addr string, b *breaker, now time.Time) {
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.openedAt) >= cb.cfg.OpenTimeout {
+			cb.setState(g, addr, b, CircuitHalfOpen, now)
+		}
+	case CircuitClosed:
+		if now.Sub(b.windowStart) >= cb.cfg.Interval {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+	}
+}
+
+//done registra el resultado de una llamada en el circuito de la dirección que la atendió
+func (cb *CircuitBreaker) done(a *admitted, addr string, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := cb.now()
+	g := cb.group(a.name)
+	if a.probe && g.probes > 0 {
+		g.probes--
+	}
+	//Una llamada que cancela el cliente no dice nada del backend: libera su hueco de prueba sin contar como éxito ni como fallo
+	if status.Code(err) == codes.Canceled {
+		return
+	}
+	failure := err != nil && cb.cfg.IsFailure(err)
+
+	b, ok := g.breakers[addr]
+	if !ok {
+		b = &breaker{state: CircuitClosed, windowStart: now}
+		g.breakers[addr] = b
+	}
+	cb.refresh(g, addr, b, now)
+	switch b.state {
+	case CircuitClosed:
+		b.requests++
+		if !failure {
+			b.consecutiveFailures = 0
+			return
+		}
+		b.failures++
+		b.consecutiveFailures++
+		if b.consecutiveFailures >= cb.cfg.ConsecutiveFailures ||
+			(cb.cfg.FailureRatio > 0 && b.requests >= cb.cfg.MinRequests && float64(b.failures)/float64(b.requests) >= cb.cfg.FailureRatio) {
+			cb.setState(g, addr, b, CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		//Cuentan las llamadas que salieron con el circuito ya semi-abierto: las de prueba y las que el balanceador envió a
+		//este backend mientras otro estaba cerrado. Las que salieron antes de abrirse no dicen nada del backend ahora
+		if a.start.Before(b.halfOpenAt) {
+			return
+		}
+		if failure {
+			cb.setState(g, addr, b, CircuitOpen, now)
+			return
+		}
+		b.halfOpenSuccesses++
+		if b.halfOpenSuccesses >= cb.cfg.SuccessThreshold {
+			cb.setState(g, addr, b, CircuitClosed, now)
+		}
+	}
+}
+
+func (cb *CircuitBreaker) setState(g *circuitGroup, addr string, b *breaker, to CircuitState, now time.Time) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	switch to {
+	case CircuitOpen:
+		b.openedAt = now
+	case CircuitHalfOpen:
+		b.halfOpenAt = now
+		b.halfOpenSuccesses = 0
+	case CircuitClosed:
+		b.consecutiveFailures = 0
+		b.requests = 0
+		b.failures = 0
+		b.windowStart = now
+	}
+	circuitBreakerMetrics.Add(to.String(), 1)
+	log.Printf("====== [Circuit Breaker] %s (%s): %s -> %s", g.name, addr, from, to)
+	if cb.cfg.OnStateChange != nil {
+		cb.cfg.OnStateChange(g.name, addr, from, to)
+	}
+}
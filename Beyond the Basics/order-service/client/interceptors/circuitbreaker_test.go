@@ -0,0 +1,344 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "interceptors/cliente/ecommerce"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+const cbMethod = "/ecommerce.OrderManagement/getOrder"
+
+//testCircuitBreaker circuit breaker con un reloj que controla la prueba
+func testCircuitBreaker(t *testing.T, cfg CircuitBreakerConfig) (*CircuitBreaker, *time.Time, *grpc.ClientConn) {
+	t.Helper()
+	cb := NewCircuitBreaker(cfg)
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+	cc, err := grpc.Dial("passthrough:///orders", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cb, &now, cc
+}
+
+//cbAddr dirección del backend que atiende las llamadas de la prueba
+const cbAddr = "10.0.0.1:50051"
+
+//invoke hace una llamada unitaria que atiende el backend addr y termina con err. Devuelve si ha llegado al backend y el
+//error de la llamada
+func invoke(cb *CircuitBreaker, cc *grpc.ClientConn, addr string, err error) (bool, error) {
+	called := false
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		called = true
+		for _, o := range opts {
+			if p, ok := o.(grpc.PeerCallOption); ok {
+				tcp, _ := net.ResolveTCPAddr("tcp", addr)
+				p.PeerAddr.Addr = tcp
+			}
+		}
+		return err
+	}
+	res := cb.UnaryClientInterceptor()(context.Background(), cbMethod, nil, nil, cc, invoker)
+	return called, res
+}
+
+var errUnavailable = status.Error(codes.Unavailable, "backend caído")
+
+func TestCircuitBreakerStateMachine(t *testing.T) {
+	var changes []CircuitState
+	cb, now, cc := testCircuitBreaker(t, CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         10 * time.Second,
+		HalfOpenMaxCalls:    1,
+		SuccessThreshold:    2,
+		OnStateChange:       func(name, addr string, from, to CircuitState) { changes = append(changes, to) },
+	})
+	state := func() CircuitState { return cb.State(cc.Target(), cbMethod, cbAddr) }
+
+	//Los errores que no son del backend no cuentan
+	invoke(cb, cc, cbAddr, status.Error(codes.NotFound, "no existe"))
+	for i := 0; i < 3; i++ {
+		if state() != CircuitClosed {
+			t.Fatalf("abierto tras %d fallos, quería 3", i)
+		}
+		invoke(cb, cc, cbAddr, errUnavailable)
+	}
+	if state() != CircuitOpen {
+		t.Fatalf("estado %v tras 3 fallos seguidos, quería open", state())
+	}
+
+	//Abierto: la llamada no sale
+	if called, err := invoke(cb, cc, cbAddr, nil); called || status.Code(err) != codes.Unavailable {
+		t.Fatalf("abierto: llamada enviada=%v, error %v", called, err)
+	}
+
+	//Pasado OpenTimeout, semi-abierto con una sola llamada de prueba a la vez
+	*now = now.Add(10 * time.Second)
+	if state() != CircuitHalfOpen {
+		t.Fatalf("estado %v pasado OpenTimeout, quería half-open", state())
+	}
+	probe, err := cb.allow(cc.Target() + cbMethod)
+	if err != nil || !probe.probe {
+		t.Fatalf("semi-abierto: la primera llamada tiene que ser de prueba: %v", err)
+	}
+	if _, err := cb.allow(cc.Target() + cbMethod); status.Code(err) != codes.Unavailable {
+		t.Fatalf("semi-abierto: segunda llamada de prueba concurrente admitida: %v", err)
+	}
+	//La prueba falla y el circuito se vuelve a abrir
+	cb.done(probe, cbAddr, errUnavailable)
+	if state() != CircuitOpen {
+		t.Fatalf("estado %v tras fallar la prueba, quería open", state())
+	}
+
+	//Con SuccessThreshold éxitos de prueba se cierra
+	*now = now.Add(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		if called, err := invoke(cb, cc, cbAddr, nil); !called || err != nil {
+			t.Fatalf("prueba %d: llamada enviada=%v, error %v", i, called, err)
+		}
+	}
+	if state() != CircuitClosed {
+		t.Fatalf("estado %v tras dos pruebas correctas, quería closed", state())
+	}
+
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("cambios %v, quería %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("cambios %v, quería %v", changes, want)
+		}
+	}
+}
+
+func TestCircuitBreakerFailureRatio(t *testing.T) {
+	cb, now, cc := testCircuitBreaker(t, CircuitBreakerConfig{
+		ConsecutiveFailures: 100,
+		FailureRatio:        0.5,
+		MinRequests:         4,
+		Interval:            time.Minute,
+	})
+	state := func() CircuitState { return cb.State(cc.Target(), cbMethod, cbAddr) }
+
+	//Fallos alternos: nunca hay dos seguidos, pero la mitad fallan
+	invoke(cb, cc, cbAddr, errUnavailable)
+	invoke(cb, cc, cbAddr, nil)
+	invoke(cb, cc, cbAddr, errUnavailable)
+	if state() != CircuitClosed {
+		t.Fatal("abierto antes de MinRequests")
+	}
+
+	//La ventana se renueva pasado Interval, y las llamadas anteriores dejan de contar
+	*now = now.Add(time.Minute)
+	invoke(cb, cc, cbAddr, nil)
+	invoke(cb, cc, cbAddr, nil)
+	invoke(cb, cc, cbAddr, errUnavailable)
+	if state() != CircuitClosed {
+		t.Fatal("abierto con fallos de la ventana anterior")
+	}
+	invoke(cb, cc, cbAddr, errUnavailable)
+	if state() != CircuitOpen {
+		t.Fatalf("estado %v con 2 fallos de 4, quería open", state())
+	}
+}
+
+func TestCircuitBreakerLateResultsInHalfOpen(t *testing.T) {
+	cb, now, cc := testCircuitBreaker(t, CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+	name := cc.Target() + cbMethod
+
+	//Una llamada sale con el circuito cerrado y tarda en terminar
+	late, err := cb.allow(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoke(cb, cc, cbAddr, errUnavailable)
+	*now = now.Add(time.Second)
+	if cb.State(cc.Target(), cbMethod, cbAddr) != CircuitHalfOpen {
+		t.Fatal("no pasa a semi-abierto")
+	}
+	//Una llamada que salió antes de abrirse el circuito termina ahora: no es una prueba y no cierra el circuito
+	cb.done(late, cbAddr, nil)
+	if cb.State(cc.Target(), cbMethod, cbAddr) != CircuitHalfOpen {
+		t.Fatal("una llamada que no es de prueba cierra el circuito")
+	}
+}
+
+func TestCircuitBreakerAbandonedStreamProbe(t *testing.T) {
+	cb, now, cc := testCircuitBreaker(t, CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Second, HalfOpenMaxCalls: 1})
+	name := cc.Target() + "/ecommerce.OrderManagement/searchOrders"
+
+	cb.done(&admitted{name: name, start: *now}, unknownAddr, errUnavailable)
+	*now = now.Add(time.Second)
+
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	if _, err := cb.StreamClientInterceptor()(ctx, desc, cc, "/ecommerce.OrderManagement/searchOrders", streamer); err != nil {
+		t.Fatal(err)
+	}
+	//El stream de prueba ocupa el único hueco
+	if _, err := cb.allow(name); status.Code(err) != codes.Unavailable {
+		t.Fatalf("segunda prueba admitida con el stream de prueba abierto: %v", err)
+	}
+
+	//El cliente abandona el stream sin leerlo: al cancelar el contexto se libera el hueco
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		cb.mu.Lock()
+		probes := cb.groups[name].probes
+		cb.mu.Unlock()
+		if probes == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("el stream de prueba cancelado no libera su hueco")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	//La cancelación del cliente no es un fallo del backend
+	if s := cb.State(cc.Target(), "/ecommerce.OrderManagement/searchOrders", unknownAddr); s != CircuitHalfOpen {
+		t.Fatalf("estado %v tras cancelar la prueba, quería half-open", s)
+	}
+	if a, err := cb.allow(name); err != nil || !a.probe {
+		t.Fatalf("nueva prueba rechazada: %v", err)
+	}
+}
+
+func TestCircuitBreakerPerAddress(t *testing.T) {
+	type change struct {
+		addr string
+		to   CircuitState
+	}
+	var changes []change
+	cb, now, cc := testCircuitBreaker(t, CircuitBreakerConfig{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         time.Second,
+		HalfOpenMaxCalls:    1,
+		OnStateChange:       func(name, addr string, from, to CircuitState) { changes = append(changes, change{addr, to}) },
+	})
+	const a, b = "10.0.0.1:50051", "10.0.0.2:50051"
+	state := func(addr string) CircuitState { return cb.State(cc.Target(), cbMethod, addr) }
+
+	//Los fallos de un backend solo abren su circuito. Las llamadas siguen saliendo, porque el otro está cerrado
+	invoke(cb, cc, b, nil)
+	invoke(cb, cc, a, errUnavailable)
+	invoke(cb, cc, a, errUnavailable)
+	if state(a) != CircuitOpen || state(b) != CircuitClosed {
+		t.Fatalf("estados a=%v b=%v, quería a abierto y b cerrado", state(a), state(b))
+	}
+	if called, err := invoke(cb, cc, b, nil); !called || err != nil {
+		t.Fatalf("con b cerrado: llamada enviada=%v, error %v", called, err)
+	}
+
+	//Con todos los circuitos abiertos las llamadas no salen
+	invoke(cb, cc, b, errUnavailable)
+	invoke(cb, cc, b, errUnavailable)
+	if called, err := invoke(cb, cc, b, nil); called || status.Code(err) != codes.Unavailable {
+		t.Fatalf("todos abiertos: llamada enviada=%v, error %v", called, err)
+	}
+
+	//Pasado OpenTimeout sale una llamada de prueba. El balanceador la envía a b, que se cierra; a sigue semi-abierto
+	*now = now.Add(time.Second)
+	if called, err := invoke(cb, cc, b, nil); !called || err != nil {
+		t.Fatalf("prueba: llamada enviada=%v, error %v", called, err)
+	}
+	if state(a) != CircuitHalfOpen || state(b) != CircuitClosed {
+		t.Fatalf("estados a=%v b=%v, quería a semi-abierto y b cerrado", state(a), state(b))
+	}
+	//Las llamadas que salen con a semi-abierto y llegan a a también lo prueban
+	invoke(cb, cc, a, nil)
+	if state(a) != CircuitClosed {
+		t.Fatalf("estado de a %v tras una llamada correcta, quería closed", state(a))
+	}
+
+	want := []change{{a, CircuitOpen}, {b, CircuitOpen}, {a, CircuitHalfOpen}, {b, CircuitHalfOpen}, {b, CircuitClosed}, {a, CircuitClosed}}
+	if len(changes) != len(want) {
+		t.Fatalf("cambios %v, quería %v", changes, want)
+	}
+	//a y b pasan a semi-abierto en la misma llamada, en el orden del mapa
+	if changes[2].addr == b {
+		changes[2], changes[3] = changes[3], changes[2]
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("cambios %v, quería %v", changes, want)
+		}
+	}
+}
+
+//failingOrders backend que responde getOrder con Unavailable si fails es true
+type failingOrders struct {
+	pb.UnimplementedOrderManagementServer
+	fails bool
+}
+
+func (s *failingOrders) GetOrder(ctx context.Context, id *wrappers.StringValue) (*pb.Order, error) {
+	if s.fails {
+		return nil, status.Error(codes.Unavailable, "backend caído")
+	}
+	return &pb.Order{Id: id.Value}, nil
+}
+
+func TestCircuitBreakerKeysOnPeerAddress(t *testing.T) {
+	var addrs []resolver.Address
+	for _, fails := range []bool{true, false} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := grpc.NewServer()
+		pb.RegisterOrderManagementServer(s, &failingOrders{fails: fails})
+		go s.Serve(lis)
+		t.Cleanup(s.Stop)
+		addrs = append(addrs, resolver.Address{Addr: lis.Addr().String()})
+	}
+	failing, healthy := addrs[0].Addr, addrs[1].Addr
+
+	r := manual.NewBuilderWithScheme("circuitbreaker")
+	r.InitialState(resolver.State{Addresses: addrs})
+	cb := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 2, OpenTimeout: time.Minute})
+	cc, err := grpc.Dial(r.Scheme()+":///orders", grpc.WithInsecure(), grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`),
+		grpc.WithChainUnaryInterceptor(cb.UnaryClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	client := pb.NewOrderManagementClient(cc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ok := 0
+	for i := 0; i < 20; i++ {
+		if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "106"}, grpc.WaitForReady(true)); err == nil {
+			ok++
+		} else if status.Code(err) != codes.Unavailable {
+			t.Fatal(err)
+		}
+	}
+	if s := cb.State(cc.Target(), cbMethod, failing); s != CircuitOpen {
+		t.Errorf("circuito del backend que falla %v, quería open", s)
+	}
+	if s := cb.State(cc.Target(), cbMethod, healthy); s != CircuitClosed {
+		t.Errorf("circuito del backend sano %v, quería closed", s)
+	}
+	//El circuito abierto de un backend no rechaza las llamadas: el balanceador sigue enviando la mitad al sano
+	if ok == 0 {
+		t.Error("ninguna llamada correcta con un backend sano")
+	}
+}
@@ -0,0 +1,74 @@
+package interceptors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+//DefaultTimeouts timeouts por defecto de la conexión. Solo se aplican cuando la llamada no trae ya un deadline
+type DefaultTimeouts struct {
+	//Timeout por método, con el nombre completo. Por ejemplo /ecommerce.OrderManagement/getOrder
+	PerMethod map[string]time.Duration
+	//Timeout de los métodos que no están en PerMethod. 0 no aplica ninguno
+	Default time.Duration
+}
+
+func (d DefaultTimeouts) timeout(method string) time.Duration {
+	if t, ok := d.PerMethod[method]; ok {
+		return t
+	}
+	return d.Default
+}
+
+//UnaryClientInterceptor aplica el timeout por defecto del método a las llamadas unitarias
+func (d DefaultTimeouts) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			if t := d.timeout(method); t > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, t)
+				defer cancel()
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+//StreamClientInterceptor aplica el timeout por defecto del método a los streams. El timeout cubre todo el stream
+func (d DefaultTimeouts) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		t := d.timeout(method)
+		if t <= 0 {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, cancel := context.WithTimeout(ctx, t)
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &timeoutStream{ClientStream: s, cancel: cancel, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+//timeoutStream libera el timer del timeout cuando termina el stream
+type timeoutStream struct {
+	grpc.ClientStream
+	cancel        context.CancelFunc
+	serverStreams bool
+	once          sync.Once
+}
+
+func (s *timeoutStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.once.Do(s.cancel)
+	}
+	return err
+}
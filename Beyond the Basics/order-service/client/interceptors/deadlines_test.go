@@ -0,0 +1,110 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+//remaining devuelve el tiempo que le queda al contexto, o 0 si no tiene deadline
+func remaining(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	return time.Until(deadline)
+}
+
+var testTimeouts = DefaultTimeouts{
+	PerMethod: map[string]time.Duration{
+		"/ecommerce.OrderManagement/getOrder":      500 * time.Millisecond,
+		"/ecommerce.OrderManagement/processOrders": 10 * time.Second,
+	},
+	Default: 2 * time.Second,
+}
+
+func TestDefaultTimeoutsUnary(t *testing.T) {
+	cases := []struct {
+		name     string
+		method   string
+		deadline time.Duration
+		min, max time.Duration
+	}{
+		{"timeout del método", "/ecommerce.OrderManagement/getOrder", 0, 400 * time.Millisecond, 500 * time.Millisecond},
+		{"timeout por defecto", "/ecommerce.OrderManagement/addOrder", 0, 1900 * time.Millisecond, 2 * time.Second},
+		//El deadline del llamante manda, sea menor o mayor que el timeout del método
+		{"deadline menor del llamante", "/ecommerce.OrderManagement/getOrder", 100 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond},
+		{"deadline mayor del llamante", "/ecommerce.OrderManagement/getOrder", time.Minute, 59 * time.Second, time.Minute},
+	}
+	for _, c := range cases {
+		ctx := context.Background()
+		if c.deadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.deadline)
+			defer cancel()
+		}
+		var seen time.Duration
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			seen = remaining(ctx)
+			return nil
+		}
+		if err := testTimeouts.UnaryClientInterceptor()(ctx, c.method, nil, nil, nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+		if seen < c.min || seen > c.max {
+			t.Errorf("%s: la llamada tiene %v, esperado entre %v y %v", c.name, seen, c.min, c.max)
+		}
+	}
+
+	//Sin Default los métodos que no están en PerMethod no tienen timeout
+	d := DefaultTimeouts{PerMethod: testTimeouts.PerMethod}
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("timeout sin Default ni timeout del método")
+		}
+		return nil
+	}
+	d.UnaryClientInterceptor()(context.Background(), "/ecommerce.OrderManagement/addOrder", nil, nil, nil, invoker)
+}
+
+//recvStream stream de cliente que devuelve err en RecvMsg
+type recvStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s *recvStream) RecvMsg(m interface{}) error { return s.err }
+
+func TestDefaultTimeoutsStream(t *testing.T) {
+	var streamCtx context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamCtx = ctx
+		return &recvStream{err: context.Canceled}, nil
+	}
+	desc := &grpc.StreamDesc{ServerStreams: true}
+
+	//El timeout del método cubre todo el stream y se libera cuando termina
+	s, err := testTimeouts.StreamClientInterceptor()(context.Background(), desc, nil, "/ecommerce.OrderManagement/processOrders", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := remaining(streamCtx); r < 9*time.Second || r > 10*time.Second {
+		t.Errorf("el stream tiene %v, esperado el timeout del método", r)
+	}
+	s.RecvMsg(nil)
+	if streamCtx.Err() != context.Canceled {
+		t.Error("el timeout del stream no se libera al terminar")
+	}
+
+	//Con deadline del llamante el stream lo conserva
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := testTimeouts.StreamClientInterceptor()(ctx, desc, nil, "/ecommerce.OrderManagement/processOrders", streamer); err != nil {
+		t.Fatal(err)
+	}
+	if r := remaining(streamCtx); r < 59*time.Second {
+		t.Errorf("el stream tiene %v, esperado el deadline del llamante", r)
+	}
+}
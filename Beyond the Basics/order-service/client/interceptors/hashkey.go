@@ -0,0 +1,158 @@
+package interceptors
+
+import (
+	"context"
+	"sync"
+
+	pb "interceptors/cliente/ecommerce"
+	"interceptors/cliente/lb"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//OrderHashKeyUnaryClientInterceptor añade el ID de la orden como clave del balanceo ring_hash, para que todas las llamadas sobre la misma orden vayan al mismo backend.
+//Solo pone la clave en las llamadas unitarias addOrder y getOrder. Los streams tienen su propio interceptor, OrderHashKeyStreamClientInterceptor
+func OrderHashKeyUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var id string
+	switch method {
+	case "/ecommerce.OrderManagement/addOrder":
+		if o, ok := req.(*pb.Order); ok {
+			id = o.Id
+		}
+	case "/ecommerce.OrderManagement/getOrder":
+		if v, ok := req.(*wrappers.StringValue); ok {
+			id = v.Value
+		}
+	}
+	if id != "" {
+		ctx = lb.WithHashKey(ctx, id)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+//OrderHashKeyStreamClientInterceptor hace lo mismo con los streams. Al abrir un stream todavía no se conoce ninguna orden, así que el stream
+//no se abre hasta el primer mensaje: la clave es el ID de la orden en updateOrders y processOrders, y el texto buscado en searchOrders.
+//Todos los mensajes del stream van al backend que elige el primero, así que en updateOrders conviene agrupar las órdenes por clave.
+//Los streams que ya tienen clave en el contexto, por lb.WithHashKey, se abren en el momento con esa clave.
+//Como el stream se abre con el primer mensaje, los errores al abrirlo los devuelve ese SendMsg y no la llamada que abre el stream.
+//Un RecvMsg o un Header antes del primer mensaje esperan a que se abra; un CloseSend sin mensajes abre el stream sin clave
+func OrderHashKeyStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if _, ok := lb.HashKeyFromContext(ctx); ok {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	switch method {
+	case "/ecommerce.OrderManagement/searchOrders", "/ecommerce.OrderManagement/updateOrders", "/ecommerce.OrderManagement/processOrders":
+	default:
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	return &hashKeyStream{
+		ctx: ctx,
+		open: func(ctx context.Context) (grpc.ClientStream, error) {
+			return streamer(ctx, desc, cc, method, opts...)
+		},
+		opened: make(chan struct{}),
+	}, nil
+}
+
+//hashKeyStream stream que se abre con la clave del primer mensaje
+type hashKeyStream struct {
+	ctx  context.Context
+	open func(ctx context.Context) (grpc.ClientStream, error)
+
+	once   sync.Once
+	opened chan struct{}
+	stream grpc.ClientStream
+	err    error
+}
+
+//orderKey clave del mensaje: el ID de la orden o el texto buscado
+func orderKey(m interface{}) string {
+	switch v := m.(type) {
+	case *pb.Order:
+		return v.Id
+	case *wrappers.StringValue:
+		return v.Value
+	}
+	return ""
+}
+
+//start abre el stream con la clave indicada, si no estaba ya abierto, y devuelve el stream abierto
+func (s *hashKeyStream) start(key string) (grpc.ClientStream, error) {
+	s.once.Do(func() {
+		ctx := s.ctx
+		if key != "" {
+			ctx = lb.WithHashKey(ctx, key)
+		}
+		s.stream, s.err = s.open(ctx)
+		close(s.opened)
+	})
+	return s.stream, s.err
+}
+
+//wait espera a que el primer mensaje abra el stream
+func (s *hashKeyStream) wait() (grpc.ClientStream, error) {
+	select {
+	case <-s.opened:
+		return s.stream, s.err
+	case <-s.ctx.Done():
+		return nil, status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+func (s *hashKeyStream) SendMsg(m interface{}) error {
+	cs, err := s.start(orderKey(m))
+	if err != nil {
+		return err
+	}
+	return cs.SendMsg(m)
+}
+
+func (s *hashKeyStream) RecvMsg(m interface{}) error {
+	cs, err := s.wait()
+	if err != nil {
+		return err
+	}
+	return cs.RecvMsg(m)
+}
+
+func (s *hashKeyStream) Header() (metadata.MD, error) {
+	cs, err := s.wait()
+	if err != nil {
+		return nil, err
+	}
+	return cs.Header()
+}
+
+//Trailer solo tiene valor después de que RecvMsg termine, así que el stream ya está abierto
+func (s *hashKeyStream) Trailer() metadata.MD {
+	select {
+	case <-s.opened:
+		if s.stream != nil {
+			return s.stream.Trailer()
+		}
+	default:
+	}
+	return nil
+}
+
+func (s *hashKeyStream) CloseSend() error {
+	cs, err := s.start("")
+	if err != nil {
+		return err
+	}
+	return cs.CloseSend()
+}
+
+func (s *hashKeyStream) Context() context.Context {
+	select {
+	case <-s.opened:
+		if s.stream != nil {
+			return s.stream.Context()
+		}
+	default:
+	}
+	return s.ctx
+}
@@ -0,0 +1,126 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "interceptors/cliente/ecommerce"
+	"interceptors/cliente/lb"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//keyStreamer streamer de la prueba que guarda la clave con la que se abre cada stream
+type keyStreamer struct {
+	keys []string
+}
+
+func (s *keyStreamer) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	key, _ := lb.HashKeyFromContext(ctx)
+	s.keys = append(s.keys, key)
+	return &sendStream{}, nil
+}
+
+//sendStream stream de cliente que acepta todos los mensajes
+type sendStream struct {
+	grpc.ClientStream
+}
+
+func (*sendStream) SendMsg(m interface{}) error { return nil }
+func (*sendStream) RecvMsg(m interface{}) error { return nil }
+func (*sendStream) CloseSend() error            { return nil }
+
+func openKeyStream(t *testing.T, s *keyStreamer, ctx context.Context, method string) grpc.ClientStream {
+	t.Helper()
+	cs, err := OrderHashKeyStreamClientInterceptor(ctx, &grpc.StreamDesc{ClientStreams: true}, nil, method, s.stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs
+}
+
+func TestOrderHashKeyStream(t *testing.T) {
+	cases := []struct {
+		method string
+		first  interface{}
+		key    string
+	}{
+		{"/ecommerce.OrderManagement/updateOrders", &pb.Order{Id: "106"}, "106"},
+		{"/ecommerce.OrderManagement/processOrders", &wrappers.StringValue{Value: "102"}, "102"},
+		{"/ecommerce.OrderManagement/searchOrders", &wrappers.StringValue{Value: "Google"}, "Google"},
+	}
+	for _, c := range cases {
+		s := &keyStreamer{}
+		cs := openKeyStream(t, s, context.Background(), c.method)
+		//El stream no se abre hasta el primer mensaje, y se abre una sola vez con su clave
+		if len(s.keys) != 0 {
+			t.Fatalf("%s: abierto antes del primer mensaje", c.method)
+		}
+		cs.SendMsg(c.first)
+		cs.SendMsg(&pb.Order{Id: "107"})
+		if len(s.keys) != 1 || s.keys[0] != c.key {
+			t.Errorf("%s: abierto con las claves %q, esperada %q", c.method, s.keys, c.key)
+		}
+	}
+}
+
+func TestOrderHashKeyStreamContextKey(t *testing.T) {
+	//Con clave en el contexto el stream se abre en el momento con esa clave
+	s := &keyStreamer{}
+	cs := openKeyStream(t, s, lb.WithHashKey(context.Background(), "grupo-1"), "/ecommerce.OrderManagement/updateOrders")
+	if len(s.keys) != 1 || s.keys[0] != "grupo-1" {
+		t.Fatalf("abierto con las claves %q", s.keys)
+	}
+	cs.SendMsg(&pb.Order{Id: "106"})
+	if len(s.keys) != 1 {
+		t.Errorf("%d aperturas, esperada 1", len(s.keys))
+	}
+
+	//Los demás streams no esperan al primer mensaje
+	s = &keyStreamer{}
+	openKeyStream(t, s, context.Background(), "/ecommerce.OrderManagement/otherStream")
+	if len(s.keys) != 1 || s.keys[0] != "" {
+		t.Errorf("otro stream abierto con las claves %q", s.keys)
+	}
+}
+
+func TestOrderHashKeyStreamRecvBeforeSend(t *testing.T) {
+	s := &keyStreamer{}
+	cs := openKeyStream(t, s, context.Background(), "/ecommerce.OrderManagement/processOrders")
+
+	//RecvMsg espera a que el primer mensaje abra el stream
+	done := make(chan error, 1)
+	go func() { done <- cs.RecvMsg(&pb.CombinedShipment{}) }()
+	select {
+	case err := <-done:
+		t.Fatalf("RecvMsg termina antes de abrir el stream: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	cs.SendMsg(&wrappers.StringValue{Value: "102"})
+	if err := <-done; err != nil {
+		t.Errorf("RecvMsg: %v", err)
+	}
+	if len(s.keys) != 1 || s.keys[0] != "102" {
+		t.Errorf("abierto con las claves %q", s.keys)
+	}
+
+	//Sin mensajes, RecvMsg termina con el contexto
+	ctx, cancel := context.WithCancel(context.Background())
+	cs = openKeyStream(t, &keyStreamer{}, ctx, "/ecommerce.OrderManagement/processOrders")
+	cancel()
+	if err := cs.RecvMsg(&pb.CombinedShipment{}); status.Code(err) != codes.Canceled {
+		t.Errorf("RecvMsg con el contexto cancelado: %v", err)
+	}
+
+	//CloseSend sin mensajes abre el stream sin clave
+	s = &keyStreamer{}
+	cs = openKeyStream(t, s, context.Background(), "/ecommerce.OrderManagement/updateOrders")
+	cs.CloseSend()
+	if len(s.keys) != 1 || s.keys[0] != "" {
+		t.Errorf("CloseSend abre con las claves %q", s.keys)
+	}
+}
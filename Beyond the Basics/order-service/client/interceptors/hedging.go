@@ -0,0 +1,229 @@
+package interceptors
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//HedgedReadMethods lecturas idempotentes que se pueden enviar más de una vez
+var HedgedReadMethods = []string{
+	"/ecommerce.OrderManagement/getOrder",
+	"/ecommerce.ProductInfo/getProduct",
+}
+
+//Métricas del hedging, publicadas en /debug/vars
+var hedgingMetrics = expvar.NewMap("hedging")
+
+//HedgingConfig configuración del hedging
+type HedgingConfig struct {
+	//Métodos, con el nombre completo, para los que se hace hedging
+	Methods []string
+	//Tiempo que se espera antes de enviar el siguiente intento
+	Delay time.Duration
+	//Número máximo de intentos, incluido el original
+	MaxAttempts int
+	//Códigos que no son definitivos. Si un intento falla con uno de ellos se envía el siguiente sin esperar a Delay
+	NonFatalCodes []codes.Code
+	//Tokens que aporta cada llamada. Cada intento adicional consume un token, así que 0.1 limita el hedging a un 10% de las llamadas
+	TokenRatio float64
+	//Máximo de tokens acumulados
+	MaxTokens float64
+}
+
+//Hedging interceptor de cliente que envía intentos adicionales de las lecturas lentas y se queda con la primera respuesta correcta.
+//Cada intento pasa por el balanceador de la conexión, así que solo llega a otro backend si la política reparte las llamadas, como
+//round_robin. Con pick_first, la de por defecto, todos los intentos van al mismo backend y el hedging no evita uno lento
+type Hedging struct {
+	cfg      HedgingConfig
+	methods  map[string]bool
+	nonFatal map[codes.Code]bool
+	mu       sync.Mutex
+	tokens   float64
+}
+
+//NewHedging crea el interceptor de hedging
+func NewHedging(cfg HedgingConfig) *Hedging {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 2
+	}
+	if cfg.Delay <= 0 {
+		cfg.Delay = 50 * time.Millisecond
+	}
+	if cfg.TokenRatio <= 0 {
+		cfg.TokenRatio = 0.1
+	}
+	if cfg.MaxTokens <= 0 {
+		cfg.MaxTokens = 10
+	}
+	h := &Hedging{
+		cfg:      cfg,
+		methods:  make(map[string]bool),
+		nonFatal: make(map[codes.Code]bool),
+		tokens:   cfg.MaxTokens,
+	}
+	for _, m := range cfg.Methods {
+		h.methods[m] = true
+	}
+	for _, c := range cfg.NonFatalCodes {
+		h.nonFatal[c] = true
+	}
+	return h
+}
+
+//UnaryClientInterceptor interceptor unitario de hedging. Hay que usarlo en conexiones con round_robin u otra política que reparta
+//las llamadas entre backends, para que cada intento salga hacia el siguiente
+func (h *Hedging) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !h.methods[method] {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		msg, ok := reply.(proto.Message)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		h.earn()
+		return h.invoke(ctx, method, req, msg, cc, invoker, opts)
+	}
+}
+
+//attempt resultado de un intento
+type attempt struct {
+	n       int
+	reply   proto.Message
+	header  metadata.MD
+	trailer metadata.MD
+	peer    peer.Peer
+	err     error
+}
+
+func (h *Hedging) invoke(ctx context.Context, method string, req interface{}, reply proto.Message, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	//Al terminar se cancelan los intentos que sigan en curso
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	//Las opciones que escriben en punteros del llamante se sustituyen por una copia por intento
+	var baseOpts []grpc.CallOption
+	var headerOpts, trailerOpts []*metadata.MD
+	var peerOpts []*peer.Peer
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			headerOpts = append(headerOpts, o.HeaderAddr)
+		case grpc.TrailerCallOption:
+			trailerOpts = append(trailerOpts, o.TrailerAddr)
+		case grpc.PeerCallOption:
+			peerOpts = append(peerOpts, o.PeerAddr)
+		default:
+			baseOpts = append(baseOpts, o)
+		}
+	}
+
+	results := make(chan *attempt, h.cfg.MaxAttempts)
+	launch := func(n int) {
+		a := &attempt{n: n, reply: proto.Clone(reply)}
+		a.reply.Reset()
+		attemptOpts := append(baseOpts[:len(baseOpts):len(baseOpts)], grpc.Header(&a.header), grpc.Trailer(&a.trailer), grpc.Peer(&a.peer))
+		go func() {
+			a.err = invoker(ctx, method, req, a.reply, cc, attemptOpts...)
+			results <- a
+		}()
+	}
+
+	launch(1)
+	sent, pending := 1, 1
+	timer := time.NewTimer(h.cfg.Delay)
+	defer timer.Stop()
+
+	var last *attempt
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if sent < h.cfg.MaxAttempts && h.spend() {
+				sent++
+				pending++
+				hedgingMetrics.Add("hedges", 1)
+				log.Printf("====== [Hedging] %s: intento %d tras %v", method, sent, h.cfg.Delay)
+				launch(sent)
+				timer.Reset(h.cfg.Delay)
+			}
+		case a := <-results:
+			pending--
+			last = a
+			if a.err == nil {
+				if a.n > 1 {
+					hedgingMetrics.Add("hedge_wins", 1)
+				}
+				h.copyResult(a, reply, headerOpts, trailerOpts, peerOpts)
+				return nil
+			}
+			if !h.nonFatal[status.Code(a.err)] {
+				h.copyResult(a, nil, headerOpts, trailerOpts, peerOpts)
+				return a.err
+			}
+			//Error no definitivo, se envía el siguiente intento sin esperar
+			if sent < h.cfg.MaxAttempts && h.spend() {
+				sent++
+				pending++
+				hedgingMetrics.Add("hedges", 1)
+				launch(sent)
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(h.cfg.Delay)
+			}
+		}
+	}
+	h.copyResult(last, nil, headerOpts, trailerOpts, peerOpts)
+	return last.err
+}
+
+func (h *Hedging) copyResult(a *attempt, reply proto.Message, headers, trailers []*metadata.MD, peers []*peer.Peer) {
+	if reply != nil {
+		reply.Reset()
+		proto.Merge(reply, a.reply)
+	}
+	for _, md := range headers {
+		*md = a.header
+	}
+	for _, md := range trailers {
+		*md = a.trailer
+	}
+	for _, p := range peers {
+		*p = a.peer
+	}
+}
+
+//earn suma los tokens de una llamada
+func (h *Hedging) earn() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens += h.cfg.TokenRatio
+	if h.tokens > h.cfg.MaxTokens {
+		h.tokens = h.cfg.MaxTokens
+	}
+}
+
+//spend consume un token para un intento adicional. Si no quedan tokens el intento no se envía
+func (h *Hedging) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		hedgingMetrics.Add("throttled", 1)
+		return false
+	}
+	h.tokens--
+	return true
+}
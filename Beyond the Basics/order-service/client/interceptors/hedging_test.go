@@ -0,0 +1,252 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "interceptors/cliente/ecommerce"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+const hedgedMethod = "/ecommerce.OrderManagement/getOrder"
+
+//hedgedCall resultado de un intento que ve el invoker de la prueba
+type hedgedCall struct {
+	start time.Time
+	err   error
+}
+
+//hedgingInvoker invoker de la prueba. El intento n termina con la respuesta de answer(n, ctx) y se anota en calls
+type hedgingInvoker struct {
+	answer func(n int, ctx context.Context) (string, error)
+
+	mu    sync.Mutex
+	calls []*hedgedCall
+	done  chan *hedgedCall
+}
+
+func newHedgingInvoker(answer func(n int, ctx context.Context) (string, error)) *hedgingInvoker {
+	return &hedgingInvoker{answer: answer, done: make(chan *hedgedCall, 10)}
+}
+
+func (i *hedgingInvoker) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+	c := &hedgedCall{start: time.Now()}
+	i.mu.Lock()
+	i.calls = append(i.calls, c)
+	n := len(i.calls)
+	i.mu.Unlock()
+
+	id, err := i.answer(n, ctx)
+	c.err = err
+	i.done <- c
+	if err != nil {
+		return err
+	}
+	reply.(*pb.Order).Id = id
+	return nil
+}
+
+func (i *hedgingInvoker) attempts() []*hedgedCall {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*hedgedCall(nil), i.calls...)
+}
+
+//slowAttempt no responde hasta que se cancela el intento
+func slowAttempt(ctx context.Context) (string, error) {
+	<-ctx.Done()
+	return "", status.FromContextError(ctx.Err()).Err()
+}
+
+func hedgedGetOrder(t *testing.T, h *Hedging, i *hedgingInvoker) (*pb.Order, error) {
+	t.Helper()
+	cc, err := grpc.Dial("passthrough:///orders", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reply := &pb.Order{}
+	err = h.UnaryClientInterceptor()(ctx, hedgedMethod, &wrappers.StringValue{Value: "106"}, reply, cc, i.invoke)
+	return reply, err
+}
+
+func TestHedgingWaitsForDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	h := NewHedging(HedgingConfig{Methods: []string{hedgedMethod}, Delay: delay, MaxAttempts: 3})
+
+	//El primer intento responde antes de Delay: no se envía ninguno más
+	fast := newHedgingInvoker(func(n int, ctx context.Context) (string, error) { return "106", nil })
+	if _, err := hedgedGetOrder(t, h, fast); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * delay)
+	if n := len(fast.attempts()); n != 1 {
+		t.Fatalf("%d intentos con una respuesta rápida, esperado 1", n)
+	}
+
+	//El primer intento no responde: el segundo sale pasado Delay y su respuesta es la de la llamada
+	slow := newHedgingInvoker(func(n int, ctx context.Context) (string, error) {
+		if n == 1 {
+			return slowAttempt(ctx)
+		}
+		return "hedged", nil
+	})
+	o, err := hedgedGetOrder(t, h, slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Id != "hedged" {
+		t.Errorf("respuesta %q, esperada la del segundo intento", o.Id)
+	}
+	attempts := slow.attempts()
+	if len(attempts) != 2 {
+		t.Fatalf("%d intentos, esperados 2: el segundo responde antes de que venza el siguiente Delay", len(attempts))
+	}
+	if d := attempts[1].start.Sub(attempts[0].start); d < delay {
+		t.Errorf("segundo intento %v después del primero, antes de Delay (%v)", d, delay)
+	}
+}
+
+func TestHedgingNonFatalErrorSkipsDelay(t *testing.T) {
+	h := NewHedging(HedgingConfig{Methods: []string{hedgedMethod}, Delay: time.Second, MaxAttempts: 2, NonFatalCodes: []codes.Code{codes.Unavailable}})
+	i := newHedgingInvoker(func(n int, ctx context.Context) (string, error) {
+		if n == 1 {
+			return "", status.Error(codes.Unavailable, "backend caído")
+		}
+		return "106", nil
+	})
+	start := time.Now()
+	if _, err := hedgedGetOrder(t, h, i); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("la llamada tarda %v: el segundo intento esperó a Delay tras un error no definitivo", d)
+	}
+}
+
+func TestHedgingCancelsLosingAttempt(t *testing.T) {
+	h := NewHedging(HedgingConfig{Methods: []string{hedgedMethod}, Delay: 10 * time.Millisecond, MaxAttempts: 2})
+	i := newHedgingInvoker(func(n int, ctx context.Context) (string, error) {
+		if n == 1 {
+			return slowAttempt(ctx)
+		}
+		return "106", nil
+	})
+	if _, err := hedgedGetOrder(t, h, i); err != nil {
+		t.Fatal(err)
+	}
+
+	//El intento que pierde termina en cuanto la llamada tiene respuesta, cancelado y no por el deadline de la llamada
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case c := <-i.done:
+			if c.err == nil {
+				continue
+			}
+			if status.Code(c.err) != codes.Canceled {
+				t.Fatalf("el intento perdedor termina con %v, esperado Canceled", c.err)
+			}
+			return
+		case <-timeout:
+			t.Fatal("el intento perdedor sigue en curso después de la respuesta")
+		}
+	}
+}
+
+//hedgingServer backend que responde getOrder con su dirección tras esperar delay
+type hedgingServer struct {
+	pb.UnimplementedOrderManagementServer
+	addr  string
+	delay time.Duration
+}
+
+func (s *hedgingServer) GetOrder(ctx context.Context, id *wrappers.StringValue) (*pb.Order, error) {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &pb.Order{Id: s.addr}, nil
+}
+
+//startHedgingServers arranca un backend por cada espera y devuelve sus direcciones
+func startHedgingServers(t *testing.T, delays ...time.Duration) []resolver.Address {
+	t.Helper()
+	var addrs []resolver.Address
+	for _, d := range delays {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := grpc.NewServer()
+		pb.RegisterOrderManagementServer(s, &hedgingServer{addr: lis.Addr().String(), delay: d})
+		go s.Serve(lis)
+		t.Cleanup(s.Stop)
+		addrs = append(addrs, resolver.Address{Addr: lis.Addr().String()})
+	}
+	return addrs
+}
+
+func TestHedgingNeedsRoundRobin(t *testing.T) {
+	//El primer backend es lento y el segundo rápido
+	addrs := startHedgingServers(t, 200*time.Millisecond, 0)
+	slow, fast := addrs[0].Addr, addrs[1].Addr
+
+	for _, c := range []struct {
+		policy string
+		used   int
+		want   string
+	}{
+		//pick_first envía todos los intentos al primer backend: el hedging no evita al lento
+		{"pick_first", 1, slow},
+		//round_robin envía cada intento al siguiente backend: responde el rápido
+		{"round_robin", 2, fast},
+	} {
+		r := manual.NewBuilderWithScheme("hedging")
+		r.InitialState(resolver.State{Addresses: addrs})
+		h := NewHedging(HedgingConfig{Methods: []string{hedgedMethod}, Delay: 20 * time.Millisecond, MaxAttempts: 2, MaxTokens: 100})
+		cc, err := grpc.Dial(r.Scheme()+":///orders", grpc.WithInsecure(), grpc.WithResolvers(r),
+			grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"`+c.policy+`": {}}]}`),
+			grpc.WithChainUnaryInterceptor(h.UnaryClientInterceptor()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := pb.NewOrderManagementClient(cc)
+
+		//Se espera a que la política tenga listos los backends que usa, para que round_robin tenga los dos en el picker
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ready := map[string]bool{}
+		for i := 0; i < 20 && len(ready) < c.used; i++ {
+			var p peer.Peer
+			if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "106"}, grpc.WaitForReady(true), grpc.Peer(&p)); err != nil {
+				t.Fatal(err)
+			}
+			ready[p.Addr.String()] = true
+		}
+
+		for i := 0; i < 4; i++ {
+			o, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "106"})
+			if err != nil {
+				t.Fatalf("%s: %v", c.policy, err)
+			}
+			if o.Id != c.want {
+				t.Errorf("%s: responde %s, esperado %s", c.policy, o.Id, c.want)
+			}
+		}
+		cancel()
+		cc.Close()
+	}
+}
@@ -0,0 +1,23 @@
+package lb
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//Duration time.Duration que en el service config se escribe como "10s"
+type Duration time.Duration
+
+//UnmarshalJSON lee la duración en el formato de time.ParseDuration
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,33 @@
+package lb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEjectionTimeIsExponential(t *testing.T) {
+	b := &outlierBalancer{cfg: &OutlierDetectionConfig{BaseEjectionTime: Duration(30 * time.Second), MaxEjectionTime: Duration(5 * time.Minute)}}
+	cases := []struct {
+		multiplier int
+		want       time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		//A partir de aquí manda el máximo
+		{5, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, c := range cases {
+		if got := b.ejectionTime(&addrOutlier{multiplier: c.multiplier}); got != c.want {
+			t.Errorf("expulsión %d: %v, esperado %v", c.multiplier, got, c.want)
+		}
+	}
+
+	//Un máximo menor que el base no acorta la expulsión
+	b.cfg.MaxEjectionTime = Duration(10 * time.Second)
+	if got := b.ejectionTime(&addrOutlier{multiplier: 3}); got != 30*time.Second {
+		t.Errorf("con el máximo menor que el base: %v, esperado el base", got)
+	}
+}
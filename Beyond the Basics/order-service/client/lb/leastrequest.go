@@ -0,0 +1,141 @@
+package lb
+
+import (
+	"encoding/json"
+	"expvar"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+//LeastRequestName nombre del balanceador en el service config
+const LeastRequestName = "least_request"
+
+//Llamadas y streams en curso, por dirección, publicados en /debug/vars
+var leastRequestMetrics = expvar.NewMap("lb_least_request")
+
+func init() {
+	balancer.Register(leastRequestBuilder{})
+}
+
+//LeastRequestConfig configuración del balanceador en el service config:
+//{"loadBalancingConfig": [{"least_request": {"choiceCount": 2}}]}
+type LeastRequestConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	//Número de backends, elegidos al azar, entre los que se escoge el que tiene menos llamadas en curso. Por defecto 2
+	ChoiceCount int `json:"choiceCount"`
+}
+
+type leastRequestBuilder struct{}
+
+func (leastRequestBuilder) Name() string { return LeastRequestName }
+
+//Build crea el balanceador. Es el de base, con un constructor de pickers propio de cada ClientConn que cuenta las llamadas en curso
+func (leastRequestBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &leastRequestPickerBuilder{choiceCount: 2, outstanding: make(map[balancer.SubConn]*int64)}
+	return &leastRequestBalancer{
+		Balancer: base.NewBalancerBuilder(LeastRequestName, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+//ParseConfig lee la configuración del service config
+func (leastRequestBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &LeastRequestConfig{ChoiceCount: 2}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.ChoiceCount < 2 {
+		cfg.ChoiceCount = 2
+	}
+	return cfg, nil
+}
+
+//leastRequestBalancer pasa la configuración al constructor de pickers
+type leastRequestBalancer struct {
+	balancer.Balancer
+	pb *leastRequestPickerBuilder
+}
+
+func (b *leastRequestBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*LeastRequestConfig); ok {
+		b.pb.mu.Lock()
+		b.pb.choiceCount = cfg.ChoiceCount
+		b.pb.mu.Unlock()
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type leastRequestPickerBuilder struct {
+	mu          sync.Mutex
+	choiceCount int
+	//Llamadas en curso de cada SubConn. Se mantienen entre pickers, porque las llamadas siguen en curso cuando cambia el picker
+	outstanding map[balancer.SubConn]*int64
+}
+
+func (pb *leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	p := &leastRequestPicker{choiceCount: pb.choiceCount, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for sc, sci := range info.ReadySCs {
+		n, ok := pb.outstanding[sc]
+		if !ok {
+			n = new(int64)
+			pb.outstanding[sc] = n
+		}
+		p.backends = append(p.backends, leastRequestBackend{subConn: sc, addr: sci.Address.Addr, outstanding: n})
+	}
+	//Los SubConn que ya no están listos no se usan, pero pueden tener llamadas en curso; solo se olvidan cuando no tienen ninguna
+	for sc, n := range pb.outstanding {
+		if _, ok := info.ReadySCs[sc]; !ok && atomic.LoadInt64(n) == 0 {
+			delete(pb.outstanding, sc)
+		}
+	}
+	return p
+}
+
+type leastRequestBackend struct {
+	subConn     balancer.SubConn
+	addr        string
+	outstanding *int64
+}
+
+//leastRequestPicker elige choiceCount backends al azar y se queda con el que tiene menos llamadas en curso.
+//Un stream cuenta como una llamada en curso hasta que termina, así que los streams largos de processOrders no desequilibran el reparto
+type leastRequestPicker struct {
+	backends    []leastRequestBackend
+	choiceCount int
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	best := p.backends[p.rnd.Intn(len(p.backends))]
+	for i := 1; i < p.choiceCount && i < len(p.backends); i++ {
+		b := p.backends[p.rnd.Intn(len(p.backends))]
+		if atomic.LoadInt64(b.outstanding) < atomic.LoadInt64(best.outstanding) {
+			best = b
+		}
+	}
+	p.mu.Unlock()
+
+	leastRequestMetrics.Add(best.addr, 1)
+	atomic.AddInt64(best.outstanding, 1)
+	return balancer.PickResult{
+		SubConn: best.subConn,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(best.outstanding, -1)
+			leastRequestMetrics.Add(best.addr, -1)
+		},
+	}, nil
+}
@@ -0,0 +1,104 @@
+package lb
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+//buildLeastRequest construye un picker de least_request con los SubConn indicados, todos listos
+func buildLeastRequest(pb *leastRequestPickerBuilder, subConns ...*fakeSubConn) balancer.Picker {
+	ready := map[balancer.SubConn]base.SubConnInfo{}
+	for _, sc := range subConns {
+		ready[sc] = base.SubConnInfo{Address: resolver.Address{Addr: sc.addr}}
+	}
+	return pb.Build(base.PickerBuildInfo{ReadySCs: ready})
+}
+
+//picks hace n llamadas que terminan en el momento y cuenta las que van a cada backend
+func picks(t *testing.T, p balancer.Picker, n int) map[string]int {
+	t.Helper()
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[res.SubConn.(*fakeSubConn).addr]++
+		res.Done(balancer.DoneInfo{})
+	}
+	return counts
+}
+
+func near(got, want, tolerance int) bool {
+	return got >= want-tolerance && got <= want+tolerance
+}
+
+func TestLeastRequestPrefersLessLoaded(t *testing.T) {
+	a, b := &fakeSubConn{addr: "a"}, &fakeSubConn{addr: "b"}
+	pb := &leastRequestPickerBuilder{choiceCount: 2, outstanding: make(map[balancer.SubConn]*int64)}
+	p := buildLeastRequest(pb, a, b)
+
+	//Sin carga el reparto es a partes iguales
+	got := picks(t, p, 1000)
+	if !near(got["a"], 500, 80) {
+		t.Fatalf("reparto %v sin llamadas en curso, esperado a=500 b=500", got)
+	}
+
+	//a tiene llamadas en curso: solo se elige si las dos elecciones al azar son a, una de cada cuatro veces
+	var held []func(balancer.DoneInfo)
+	for len(held) < 10 {
+		res, _ := p.Pick(balancer.PickInfo{})
+		if res.SubConn == a {
+			held = append(held, res.Done)
+		} else {
+			res.Done(balancer.DoneInfo{})
+		}
+	}
+	got = picks(t, p, 1000)
+	if !near(got["a"], 250, 70) {
+		t.Fatalf("reparto %v con a cargado, esperado a=250 b=750", got)
+	}
+
+	//Al terminar las llamadas de a se vuelve a repartir a partes iguales
+	for _, done := range held {
+		done(balancer.DoneInfo{})
+	}
+	got = picks(t, p, 1000)
+	if !near(got["a"], 500, 80) {
+		t.Fatalf("reparto %v al terminar las llamadas de a, esperado a=500 b=500", got)
+	}
+}
+
+func TestLeastRequestKeepsOutstandingAcrossPickers(t *testing.T) {
+	a, b := &fakeSubConn{addr: "a"}, &fakeSubConn{addr: "b"}
+	pb := &leastRequestPickerBuilder{choiceCount: 2, outstanding: make(map[balancer.SubConn]*int64)}
+	res, err := buildLeastRequest(pb, a, b).Pick(balancer.PickInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := res.SubConn.(*fakeSubConn)
+	other := a
+	if sc == a {
+		other = b
+	}
+
+	//El backend de la llamada en curso deja de estar listo: se mantiene su contador mientras tenga llamadas
+	buildLeastRequest(pb, other)
+	if _, ok := pb.outstanding[sc]; !ok {
+		t.Fatal("se olvida el contador de un SubConn con llamadas en curso")
+	}
+	//Vuelve a estar listo y la llamada sigue contando
+	buildLeastRequest(pb, a, b)
+	if n := *pb.outstanding[sc]; n != 1 {
+		t.Fatalf("%d llamadas en curso en %s, esperada 1", n, sc.addr)
+	}
+
+	res.Done(balancer.DoneInfo{})
+	buildLeastRequest(pb, other)
+	if _, ok := pb.outstanding[sc]; ok {
+		t.Fatal("se mantiene el contador de un SubConn que no está listo y no tiene llamadas")
+	}
+}
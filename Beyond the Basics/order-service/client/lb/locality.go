@@ -0,0 +1,221 @@
+package lb
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	ns "interceptors/cliente/nameservice"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+//LocalityName nombre del balanceador en el service config
+const LocalityName = "locality"
+
+//Llamadas por localidad y cambios de localidad, publicados en /debug/vars
+var localityMetrics = expvar.NewMap("lb_locality")
+
+func init() {
+	balancer.Register(localityBuilder{})
+}
+
+//Locality zona y prioridad de un backend, tal y como las indica el resolver
+type Locality struct {
+	Zone     string
+	Priority uint32
+}
+
+func (l Locality) String() string {
+	return fmt.Sprintf("%s/%d", l.Zone, l.Priority)
+}
+
+type localityKey struct{}
+
+//WithLocality pide que el balanceador guarde en l la localidad del backend al que se envía la llamada, igual que grpc.Peer con su dirección
+func WithLocality(ctx context.Context, l *Locality) context.Context {
+	return context.WithValue(ctx, localityKey{}, l)
+}
+
+//LocalityConfig configuración del balanceador en el service config:
+//{"loadBalancingConfig": [{"locality": {"localZone": "zona-a", "failoverThreshold": 0.7}}]}
+type LocalityConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	//Zona del cliente. Vacío reparte las llamadas entre todas las zonas de la prioridad
+	LocalZone string `json:"localZone"`
+	//Fracción del peso de una localidad que tiene que estar lista para usarla. Por debajo se pasa a la siguiente. Por defecto 0,7
+	FailoverThreshold float64 `json:"failoverThreshold"`
+}
+
+type localityBuilder struct{}
+
+func (localityBuilder) Name() string { return LocalityName }
+
+//Build crea el balanceador. Es el de base, con un constructor de pickers propio de cada ClientConn que conoce todas las direcciones,
+//listas o no, para calcular qué parte de cada localidad está disponible
+func (localityBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &localityPickerBuilder{cfg: &LocalityConfig{FailoverThreshold: 0.7}}
+	return &localityBalancer{
+		Balancer: base.NewBalancerBuilder(LocalityName, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+//ParseConfig lee la configuración del service config
+func (localityBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &LocalityConfig{FailoverThreshold: 0.7}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.FailoverThreshold < 0 || cfg.FailoverThreshold > 1 {
+		return nil, fmt.Errorf("%s: failoverThreshold tiene que estar entre 0 y 1: %v", LocalityName, cfg.FailoverThreshold)
+	}
+	return cfg, nil
+}
+
+//localityBalancer pasa la configuración y el peso total de cada localidad al constructor de pickers
+type localityBalancer struct {
+	balancer.Balancer
+	pb *localityPickerBuilder
+}
+
+func (b *localityBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	totals := make(map[Locality]uint32)
+	for _, a := range s.ResolverState.Addresses {
+		totals[Locality{Zone: ns.Zone(a), Priority: ns.Priority(a)}] += ns.Weight(a)
+	}
+	b.pb.mu.Lock()
+	if cfg, ok := s.BalancerConfig.(*LocalityConfig); ok {
+		b.pb.cfg = cfg
+	}
+	b.pb.totals = totals
+	b.pb.mu.Unlock()
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type localityPickerBuilder struct {
+	mu     sync.Mutex
+	cfg    *LocalityConfig
+	totals map[Locality]uint32
+	//Localidades en uso con el último picker, para registrar los cambios
+	current string
+}
+
+type localityBackend struct {
+	subConn  balancer.SubConn
+	locality Locality
+}
+
+//Build elige las localidades a las que se envían las llamadas. Se recorren las prioridades de menor a mayor, y en cada una:
+//   - si la zona local tiene listo al menos failoverThreshold de su peso, se usa solo la zona local
+//   - si no, si la prioridad entera tiene listo al menos failoverThreshold de su peso, se usan todas sus zonas
+//
+//Si ninguna prioridad llega al umbral, se reparten las llamadas entre todos los backends listos
+func (pb *localityPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	ready := make(map[Locality][]localityBackend)
+	readyWeight := make(map[Locality]uint32)
+	for sc, sci := range info.ReadySCs {
+		l := Locality{Zone: ns.Zone(sci.Address), Priority: ns.Priority(sci.Address)}
+		ready[l] = append(ready[l], localityBackend{subConn: sc, locality: l})
+		readyWeight[l] += ns.Weight(sci.Address)
+	}
+	//Las direcciones que ya no están en el resolver pueden seguir listas un momento
+	totals := make(map[Locality]uint32)
+	for l, w := range pb.totals {
+		totals[l] = w
+	}
+	for l, w := range readyWeight {
+		if totals[l] < w {
+			totals[l] = w
+		}
+	}
+
+	var priorities []uint32
+	seen := make(map[uint32]bool)
+	for l := range totals {
+		if !seen[l.Priority] {
+			seen[l.Priority] = true
+			priorities = append(priorities, l.Priority)
+		}
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] < priorities[j] })
+
+	healthy := func(r, t uint32) bool { return t > 0 && r > 0 && float64(r) >= pb.cfg.FailoverThreshold*float64(t) }
+	var chosen []Locality
+	for _, p := range priorities {
+		local := Locality{Zone: pb.cfg.LocalZone, Priority: p}
+		if pb.cfg.LocalZone != "" && healthy(readyWeight[local], totals[local]) {
+			chosen = []Locality{local}
+			break
+		}
+		var r, t uint32
+		var level []Locality
+		for l := range totals {
+			if l.Priority == p {
+				r += readyWeight[l]
+				t += totals[l]
+				level = append(level, l)
+			}
+		}
+		if healthy(r, t) {
+			chosen = level
+			break
+		}
+	}
+	if chosen == nil {
+		for l := range ready {
+			chosen = append(chosen, l)
+		}
+	}
+	sort.Slice(chosen, func(i, j int) bool { return chosen[i].String() < chosen[j].String() })
+
+	p := &localityPicker{}
+	for _, l := range chosen {
+		p.backends = append(p.backends, ready[l]...)
+	}
+	p.next = rand.New(rand.NewSource(time.Now().UnixNano())).Intn(len(p.backends))
+
+	if current := fmt.Sprint(chosen); current != pb.current {
+		if pb.current != "" {
+			localityMetrics.Add("changes", 1)
+		}
+		log.Printf("====== [Locality] zona local %q: se usan las localidades %v", pb.cfg.LocalZone, chosen)
+		pb.current = current
+	}
+	return p
+}
+
+//localityPicker reparte las llamadas en round robin entre los backends listos de las localidades elegidas
+type localityPicker struct {
+	backends []localityBackend
+
+	mu   sync.Mutex
+	next int
+}
+
+func (p *localityPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	b := p.backends[p.next]
+	p.next = (p.next + 1) % len(p.backends)
+	p.mu.Unlock()
+
+	localityMetrics.Add(b.locality.String(), 1)
+	if l, ok := info.Ctx.Value(localityKey{}).(*Locality); ok {
+		*l = b.locality
+	}
+	return balancer.PickResult{SubConn: b.subConn}, nil
+}
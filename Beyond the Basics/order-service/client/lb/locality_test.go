@@ -0,0 +1,119 @@
+package lb
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	ns "interceptors/cliente/nameservice"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+//localityEndpoint backend de la prueba. El nombre es la dirección
+type localityEndpoint struct {
+	name     string
+	zone     string
+	priority uint32
+}
+
+//localityBackends devuelve los backends a los que envía llamadas el picker de locality cuando el resolver tiene todos los
+//endpoints y solo están listos los de ready
+func localityBackends(t *testing.T, localZone string, endpoints []localityEndpoint, ready ...string) string {
+	t.Helper()
+	var addrs []resolver.Address
+	info := base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{}}
+	for _, e := range endpoints {
+		addr := ns.WithLocality(resolver.Address{Addr: e.name}, 1, e.zone, e.priority)
+		addrs = append(addrs, addr)
+		for _, r := range ready {
+			if r == e.name {
+				info.ReadySCs[&fakeSubConn{addr: e.name}] = base.SubConnInfo{Address: addr}
+			}
+		}
+	}
+	//El peso total de cada localidad, como lo calcula localityBalancer con las direcciones del resolver
+	totals := make(map[Locality]uint32)
+	for _, a := range addrs {
+		totals[Locality{Zone: ns.Zone(a), Priority: ns.Priority(a)}] += ns.Weight(a)
+	}
+	pb := &localityPickerBuilder{cfg: &LocalityConfig{LocalZone: localZone, FailoverThreshold: 0.7}, totals: totals}
+
+	p := pb.Build(info)
+	seen := map[string]bool{}
+	for i := 0; i < 2*len(endpoints); i++ {
+		res, err := p.Pick(balancer.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[res.SubConn.(*fakeSubConn).addr] = true
+	}
+	var names []string
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestLocalityPrefersLocalZone(t *testing.T) {
+	endpoints := []localityEndpoint{{"a1", "a", 0}, {"a2", "a", 0}, {"a3", "a", 0}, {"a4", "a", 0}, {"b1", "b", 0}, {"b2", "b", 0}}
+	cases := []struct {
+		name      string
+		localZone string
+		ready     []string
+		want      string
+	}{
+		{"zona local lista", "a", []string{"a1", "a2", "a3", "a4", "b1", "b2"}, "a1,a2,a3,a4"},
+		//3 de 4 es el 75% de la zona local, por encima del 70%
+		{"zona local en el umbral", "a", []string{"a1", "a2", "a3", "b1", "b2"}, "a1,a2,a3"},
+		//2 de 4 no llega, pero la prioridad entera tiene 4 de 6 y tampoco; se reparte entre todos los listos
+		{"zona local y prioridad por debajo", "a", []string{"a1", "a2", "b1", "b2"}, "a1,a2,b1,b2"},
+		//La zona local b tiene 1 de 2, pero la prioridad entera tiene 5 de 6: se usan todas sus zonas
+		{"otra zona local por debajo", "b", []string{"a1", "a2", "a3", "a4", "b1"}, "a1,a2,a3,a4,b1"},
+		{"sin zona local", "", []string{"a1", "a2", "a3", "a4", "b1", "b2"}, "a1,a2,a3,a4,b1,b2"},
+	}
+	for _, c := range cases {
+		if got := localityBackends(t, c.localZone, endpoints, c.ready...); got != c.want {
+			t.Errorf("%s: llamadas a %s, esperado %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLocalityPriorityFailover(t *testing.T) {
+	endpoints := []localityEndpoint{{"p0a", "a", 0}, {"p0b", "a", 0}, {"p0c", "a", 0}, {"p1a", "b", 1}, {"p1b", "b", 1}, {"p2a", "c", 2}}
+	cases := []struct {
+		name  string
+		ready []string
+		want  string
+	}{
+		{"prioridad 0 lista", []string{"p0a", "p0b", "p0c", "p1a", "p1b", "p2a"}, "p0a,p0b,p0c"},
+		//2 de 3 es el 66%, por debajo del 70%: se pasa a la prioridad 1 aunque la 0 tenga backends listos
+		{"prioridad 0 por debajo del umbral", []string{"p0a", "p0b", "p1a", "p1b", "p2a"}, "p1a,p1b"},
+		{"prioridades 0 y 1 por debajo del umbral", []string{"p0a", "p0b", "p1a", "p2a"}, "p2a"},
+		//Ninguna prioridad llega al umbral: se usan todos los backends listos antes que fallar
+		{"ninguna prioridad lista", []string{"p0a", "p1a"}, "p0a,p1a"},
+	}
+	for _, c := range cases {
+		if got := localityBackends(t, "", endpoints, c.ready...); got != c.want {
+			t.Errorf("%s: llamadas a %s, esperado %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLocalityReportsPickedLocality(t *testing.T) {
+	pb := &localityPickerBuilder{cfg: &LocalityConfig{FailoverThreshold: 0.7}}
+	addr := ns.WithLocality(resolver.Address{Addr: "a1"}, 1, "zona-a", 1)
+	p := pb.Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{&fakeSubConn{addr: "a1"}: {Address: addr}}})
+
+	var l Locality
+	if _, err := p.Pick(balancer.PickInfo{Ctx: WithLocality(context.Background(), &l)}); err != nil {
+		t.Fatal(err)
+	}
+	if l != (Locality{Zone: "zona-a", Priority: 1}) {
+		t.Errorf("localidad %v, esperada zona-a/1", l)
+	}
+}
@@ -0,0 +1,475 @@
+package lb
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+)
+
+//OutlierDetectionName nombre del balanceador en el service config
+const OutlierDetectionName = "outlier_detection"
+
+//Expulsiones, readmisiones y direcciones expulsadas, publicadas en /debug/vars
+var outlierMetrics = expvar.NewMap("lb_outlier_detection")
+
+//errEjected error de conexión de las direcciones expulsadas, tal y como lo ve el balanceador hijo
+var errEjected = errors.New("dirección expulsada por outlier detection")
+
+func init() {
+	balancer.Register(outlierBuilder{})
+}
+
+//OutlierDetectionConfig configuración del balanceador en el service config. Envuelve a cualquier otro balanceador, el hijo:
+//{"loadBalancingConfig": [{"outlier_detection": {"consecutiveFailures": 5, "childPolicy": [{"round_robin": {}}]}}]}
+type OutlierDetectionConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	//Cada cuánto se evalúan las tasas de éxito y se readmiten las direcciones expulsadas. Por defecto 10 segundos
+	Interval Duration `json:"interval"`
+	//Tiempo de la primera expulsión. Se duplica con cada nueva expulsión de la dirección, hasta MaxEjectionTime. Por defecto 30 segundos
+	BaseEjectionTime Duration `json:"baseEjectionTime"`
+	//Tiempo máximo de expulsión. Por defecto 5 minutos
+	MaxEjectionTime Duration `json:"maxEjectionTime"`
+	//Porcentaje máximo de direcciones expulsadas. Con cualquier valor mayor que 0 se puede expulsar al menos una,
+	//porque el límite se redondea hacia arriba; 0 desactiva las expulsiones. Por defecto 10
+	MaxEjectionPercent int `json:"maxEjectionPercent"`
+	//Fallos seguidos tras los que se expulsa una dirección, sin esperar a la siguiente evaluación. 0 desactiva este criterio
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	//Expulsión por tasa de éxito. Si no se indica no se usa este criterio
+	SuccessRateEjection *SuccessRateEjection `json:"successRateEjection"`
+	//Balanceador hijo. Se usa el primero que esté registrado
+	ChildPolicy []map[string]json.RawMessage `json:"childPolicy"`
+
+	childName   string
+	childConfig serviceconfig.LoadBalancingConfig
+}
+
+//SuccessRateEjection expulsa las direcciones cuya tasa de éxito queda por debajo de media - desviación * stdevFactor / 1000
+type SuccessRateEjection struct {
+	//Por defecto 1900, es decir 1,9 desviaciones típicas
+	StdevFactor int `json:"stdevFactor"`
+	//Probabilidad, de 0 a 100, de expulsar una dirección que cumple el criterio. Por defecto 100
+	EnforcementPercentage int `json:"enforcementPercentage"`
+	//Direcciones con suficientes llamadas necesarias para evaluar el criterio. Por defecto 5
+	MinimumHosts int `json:"minimumHosts"`
+	//Llamadas en el intervalo necesarias para evaluar una dirección. Por defecto 100
+	RequestVolume int `json:"requestVolume"`
+}
+
+type outlierBuilder struct{}
+
+func (outlierBuilder) Name() string { return OutlierDetectionName }
+
+//ParseConfig lee la configuración del service config, incluida la del balanceador hijo
+func (outlierBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &OutlierDetectionConfig{
+		Interval:           Duration(10 * time.Second),
+		BaseEjectionTime:   Duration(30 * time.Second),
+		MaxEjectionTime:    Duration(5 * time.Minute),
+		MaxEjectionPercent: 10,
+	}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Interval <= 0 || cfg.BaseEjectionTime <= 0 {
+		return nil, fmt.Errorf("%s: interval y baseEjectionTime tienen que ser positivos", OutlierDetectionName)
+	}
+	if sr := cfg.SuccessRateEjection; sr != nil {
+		if sr.StdevFactor == 0 {
+			sr.StdevFactor = 1900
+		}
+		if sr.EnforcementPercentage == 0 {
+			sr.EnforcementPercentage = 100
+		}
+		if sr.MinimumHosts == 0 {
+			sr.MinimumHosts = 5
+		}
+		if sr.RequestVolume == 0 {
+			sr.RequestVolume = 100
+		}
+	}
+	for _, policy := range cfg.ChildPolicy {
+		for name, childJS := range policy {
+			builder := balancer.Get(name)
+			if builder == nil {
+				continue
+			}
+			cfg.childName = name
+			if parser, ok := builder.(balancer.ConfigParser); ok {
+				childCfg, err := parser.ParseConfig(childJS)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s: %v", OutlierDetectionName, name, err)
+				}
+				cfg.childConfig = childCfg
+			}
+			return cfg, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no hay ningún childPolicy registrado", OutlierDetectionName)
+}
+
+//Build crea el balanceador. El hijo se crea con la primera configuración
+func (outlierBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	b := &outlierBalancer{
+		cc:        cc,
+		opts:      opts,
+		addrs:     make(map[string]*addrOutlier),
+		subConns:  make(map[balancer.SubConn]*outlierSubConn),
+		evaluate:  make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		evaluated: time.Now(),
+	}
+	go b.run()
+	return b
+}
+
+//addrOutlier estadísticas de una dirección
+type addrOutlier struct {
+	addr string
+	//Llamadas del intervalo actual
+	successes, failures int
+	consecutive         int
+	//Veces que se ha expulsado. Baja en uno por cada intervalo sin expulsiones
+	multiplier int
+	ejectedAt  time.Time
+	subConns   map[balancer.SubConn]bool
+}
+
+func (a *addrOutlier) ejected() bool { return !a.ejectedAt.IsZero() }
+
+//outlierSubConn último estado real de un SubConn, que se envía al hijo cuando se readmite la dirección
+type outlierSubConn struct {
+	addr  *addrOutlier
+	state balancer.SubConnState
+}
+
+//outlierBalancer se coloca entre gRPC y el balanceador hijo. Cuenta el resultado de las llamadas de cada dirección y, para expulsar
+//una dirección, le dice al hijo que sus SubConn están en TransientFailure; así el hijo deja de usarlos, sea cual sea su algoritmo
+type outlierBalancer struct {
+	cc   balancer.ClientConn
+	opts balancer.BuildOptions
+
+	//Protege todo lo que sigue. Las llamadas al hijo se hacen siempre con el mutex, porque llegan tanto de gRPC como de la evaluación periódica
+	mu        sync.Mutex
+	cfg       *OutlierDetectionConfig
+	child     balancer.Balancer
+	childName string
+	addrs     map[string]*addrOutlier
+	subConns  map[balancer.SubConn]*outlierSubConn
+	rnd       *rand.Rand
+	evaluated time.Time
+	closed    bool
+
+	evaluate chan struct{}
+	done     chan struct{}
+}
+
+func (b *outlierBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	cfg, ok := s.BalancerConfig.(*OutlierDetectionConfig)
+	if !ok {
+		return balancer.ErrBadResolverState
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg = cfg
+	if b.child == nil || b.childName != cfg.childName {
+		if b.child != nil {
+			b.child.Close()
+		}
+		b.child = balancer.Get(cfg.childName).Build(&outlierClientConn{ClientConn: b.cc, b: b}, b.opts)
+		b.childName = cfg.childName
+	}
+	s.BalancerConfig = cfg.childConfig
+	return b.child.UpdateClientConnState(s)
+}
+
+func (b *outlierBalancer) ResolverError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.child != nil {
+		b.child.ResolverError(err)
+	}
+}
+
+//UpdateSubConnState guarda el estado real del SubConn. Si la dirección está expulsada el hijo no se entera del cambio
+func (b *outlierBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	osc, ok := b.subConns[sc]
+	if !ok || b.child == nil {
+		return
+	}
+	osc.state = state
+	if state.ConnectivityState == connectivity.Shutdown {
+		delete(b.subConns, sc)
+		delete(osc.addr.subConns, sc)
+		if len(osc.addr.subConns) == 0 {
+			if osc.addr.ejected() {
+				outlierMetrics.Add("ejected", -1)
+			}
+			delete(b.addrs, osc.addr.addr)
+		}
+	} else if osc.addr.ejected() {
+		//El hijo cree que está en TransientFailure y no va a reconectar, así que se reconecta aquí para que esté listo al readmitirla
+		if state.ConnectivityState == connectivity.Idle {
+			sc.Connect()
+		}
+		return
+	}
+	b.child.UpdateSubConnState(sc, state)
+}
+
+func (b *outlierBalancer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.done)
+	if b.child != nil {
+		b.child.Close()
+	}
+}
+
+//run evalúa las direcciones cada intervalo, y cuando una dirección llega a los fallos seguidos configurados
+func (b *outlierBalancer) run() {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-b.evaluate:
+		case <-b.done:
+			return
+		}
+		b.mu.Lock()
+		interval := time.Second
+		if b.cfg != nil {
+			interval = time.Duration(b.cfg.Interval)
+			b.ejectConsecutiveFailures()
+			if time.Since(b.evaluated) >= interval {
+				b.evaluateInterval()
+				b.evaluated = time.Now()
+			}
+			interval -= time.Since(b.evaluated)
+		}
+		b.mu.Unlock()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+//record cuenta el resultado de una llamada. Se ejecuta en la go-rutina de la llamada
+func (b *outlierBalancer) record(a *addrOutlier, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isFailure(err) {
+		a.successes++
+		a.consecutive = 0
+		return
+	}
+	a.failures++
+	a.consecutive++
+	if b.cfg != nil && b.cfg.ConsecutiveFailures > 0 && a.consecutive >= b.cfg.ConsecutiveFailures && !a.ejected() {
+		//La expulsión la hace la go-rutina de evaluación, para no llamar al hijo desde la llamada
+		select {
+		case b.evaluate <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//isFailure indica si el error es culpa del backend. Los errores de la petición, como NotFound o InvalidArgument, no cuentan
+func isFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+func (b *outlierBalancer) ejectConsecutiveFailures() {
+	for _, a := range b.addrs {
+		if b.cfg.ConsecutiveFailures > 0 && a.consecutive >= b.cfg.ConsecutiveFailures && !a.ejected() {
+			b.eject(a, fmt.Sprintf("%d fallos seguidos", a.consecutive))
+		}
+	}
+}
+
+//evaluateInterval aplica el criterio de la tasa de éxito, readmite las direcciones cuyo tiempo de expulsión ha pasado y empieza un nuevo intervalo
+func (b *outlierBalancer) evaluateInterval() {
+	if sr := b.cfg.SuccessRateEjection; sr != nil {
+		var candidates []*addrOutlier
+		var rates []float64
+		for _, a := range b.addrs {
+			if a.successes+a.failures >= sr.RequestVolume {
+				candidates = append(candidates, a)
+				rates = append(rates, float64(a.successes)/float64(a.successes+a.failures))
+			}
+		}
+		if len(candidates) >= sr.MinimumHosts {
+			mean, stdev := meanStdev(rates)
+			threshold := mean - stdev*float64(sr.StdevFactor)/1000
+			for i, a := range candidates {
+				if rates[i] < threshold && !a.ejected() && b.rnd.Intn(100) < sr.EnforcementPercentage {
+					b.eject(a, fmt.Sprintf("tasa de éxito %.2f por debajo de %.2f", rates[i], threshold))
+				}
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, a := range b.addrs {
+		a.successes, a.failures = 0, 0
+		if !a.ejected() {
+			if a.multiplier > 0 {
+				a.multiplier--
+			}
+			continue
+		}
+		if now.Sub(a.ejectedAt) >= b.ejectionTime(a) {
+			b.uneject(a)
+		}
+	}
+}
+
+//ejectionTime tiempo de expulsión de la dirección. Crece exponencialmente con las veces que se ha expulsado, base << (multiplier-1):
+//el base la primera vez, el doble la segunda, el cuádruple la tercera... como mucho el máximo
+func (b *outlierBalancer) ejectionTime(a *addrOutlier) time.Duration {
+	base := time.Duration(b.cfg.BaseEjectionTime)
+	max := time.Duration(b.cfg.MaxEjectionTime)
+	if max < base {
+		max = base
+	}
+	ejection := base
+	//Se duplica paso a paso para no desbordar con multiplicadores grandes
+	for i := 1; i < a.multiplier && ejection < max; i++ {
+		ejection *= 2
+	}
+	if ejection > max {
+		return max
+	}
+	return ejection
+}
+
+func meanStdev(values []float64) (float64, float64) {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+//eject expulsa la dirección, salvo que ya se haya alcanzado el porcentaje máximo de expulsiones. El límite se redondea hacia
+//arriba: con tres direcciones y el 10% por defecto se puede expulsar una, porque 0 expulsadas está por debajo de 0,3
+func (b *outlierBalancer) eject(a *addrOutlier, reason string) {
+	ejected := 0
+	for _, o := range b.addrs {
+		if o.ejected() {
+			ejected++
+		}
+	}
+	if ejected*100 >= b.cfg.MaxEjectionPercent*len(b.addrs) {
+		log.Printf("====== [OutlierDetection] %s: no se expulsa (%s), ya hay %d de %d direcciones expulsadas", a.addr, reason, ejected, len(b.addrs))
+		return
+	}
+	a.ejectedAt = time.Now()
+	a.multiplier++
+	a.consecutive = 0
+	outlierMetrics.Add("ejections", 1)
+	outlierMetrics.Add("ejected", 1)
+	log.Printf("====== [OutlierDetection] %s: expulsada durante %v por %s", a.addr, b.ejectionTime(a), reason)
+	for sc := range a.subConns {
+		b.child.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.TransientFailure, ConnectionError: errEjected})
+	}
+}
+
+//uneject readmite la dirección y le pasa al hijo el estado real de sus SubConn
+func (b *outlierBalancer) uneject(a *addrOutlier) {
+	a.ejectedAt = time.Time{}
+	outlierMetrics.Add("unejections", 1)
+	outlierMetrics.Add("ejected", -1)
+	log.Printf("====== [OutlierDetection] %s: readmitida", a.addr)
+	for sc := range a.subConns {
+		b.child.UpdateSubConnState(sc, b.subConns[sc].state)
+	}
+}
+
+//outlierClientConn ClientConn que ve el hijo. Registra sus SubConn y envuelve sus pickers
+type outlierClientConn struct {
+	balancer.ClientConn
+	b *outlierBalancer
+}
+
+//NewSubConn se llama desde el hijo, con el mutex del balanceador ya cogido
+func (cc *outlierClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := cc.ClientConn.NewSubConn(addrs, opts)
+	if err != nil || len(addrs) == 0 {
+		return sc, err
+	}
+	b := cc.b
+	a, ok := b.addrs[addrs[0].Addr]
+	if !ok {
+		a = &addrOutlier{addr: addrs[0].Addr, subConns: make(map[balancer.SubConn]bool)}
+		b.addrs[a.addr] = a
+	}
+	a.subConns[sc] = true
+	b.subConns[sc] = &outlierSubConn{addr: a, state: balancer.SubConnState{ConnectivityState: connectivity.Idle}}
+	return sc, nil
+}
+
+func (cc *outlierClientConn) UpdateState(s balancer.State) {
+	s.Picker = &outlierPicker{child: s.Picker, b: cc.b}
+	cc.ClientConn.UpdateState(s)
+}
+
+//outlierPicker cuenta el resultado de las llamadas que reparte el picker del hijo
+type outlierPicker struct {
+	child balancer.Picker
+	b     *outlierBalancer
+}
+
+func (p *outlierPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	res, err := p.child.Pick(info)
+	if err != nil {
+		return res, err
+	}
+	p.b.mu.Lock()
+	osc, ok := p.b.subConns[res.SubConn]
+	p.b.mu.Unlock()
+	if !ok {
+		return res, nil
+	}
+	childDone := res.Done
+	res.Done = func(di balancer.DoneInfo) {
+		p.b.record(osc.addr, di.Err)
+		if childDone != nil {
+			childDone(di)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,132 @@
+package lb_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "interceptors/cliente/ecommerce"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+//failingServer servidor que, mientras failing vale 1, responde a getOrder con Unavailable y su nombre en el mensaje
+type failingServer struct {
+	*testServer
+	failing int32
+}
+
+func (s *failingServer) GetOrder(ctx context.Context, id *wrappers.StringValue) (*pb.Order, error) {
+	if atomic.LoadInt32(&s.failing) == 1 {
+		return nil, status.Error(codes.Unavailable, s.name)
+	}
+	return s.testServer.GetOrder(ctx, id)
+}
+
+//calls hace n llamadas y cuenta, por servidor, las que atiende y las que fallan
+func calls(t *testing.T, client pb.OrderManagementClient, n int) (ok, failed map[string]int) {
+	t.Helper()
+	ok, failed = map[string]int{}, map[string]int{}
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		o, err := client.GetOrder(ctx, &wrappers.StringValue{Value: fmt.Sprint(i)})
+		cancel()
+		switch {
+		case err == nil:
+			ok[o.Description]++
+		case status.Code(err) == codes.Unavailable:
+			failed[status.Convert(err).Message()]++
+		default:
+			t.Fatal(err)
+		}
+	}
+	return ok, failed
+}
+
+//settle llama hasta que las llamadas dejan de llegar a los servidores que fallan, o hasta que deja de cambiar el conjunto de
+//servidores que fallan si alguno no se puede expulsar, y devuelve el reparto de la última ronda
+func settle(t *testing.T, client pb.OrderManagementClient, done func(ok, failed map[string]int) bool) (ok, failed map[string]int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok, failed = calls(t, client, 30)
+		if done(ok, failed) {
+			return ok, failed
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("el reparto no se estabiliza: atendidas %v, fallidas %v", ok, failed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const outlierConfig = `{"loadBalancingConfig": [{"outlier_detection": {
+	"interval": "50ms", "baseEjectionTime": "%s", "maxEjectionPercent": %d, "consecutiveFailures": 3,
+	"childPolicy": [{"round_robin": {}}]}}]}`
+
+func TestOutlierDetectionEjectsAndReadmits(t *testing.T) {
+	c := &failingServer{testServer: &testServer{name: "c"}}
+	addrs := startServers(t, &testServer{name: "a"}, &testServer{name: "b"}, c)
+	client := dial(t, fmt.Sprintf(outlierConfig, "300ms", 50),
+		resolver.Address{Addr: addrs[0]}, resolver.Address{Addr: addrs[1]}, resolver.Address{Addr: addrs[2]})
+	warmUp(t, client, 3)
+
+	atomic.StoreInt32(&c.failing, 1)
+	ok, _ := settle(t, client, func(ok, failed map[string]int) bool { return len(failed) == 0 })
+	if ok["c"] != 0 || ok["a"] == 0 || ok["b"] == 0 {
+		t.Fatalf("reparto %v con c expulsada, esperado solo a y b", ok)
+	}
+
+	//c se recupera: pasado el tiempo de expulsión vuelve a recibir llamadas
+	atomic.StoreInt32(&c.failing, 0)
+	start := time.Now()
+	warmUp(t, client, 3)
+	if waited := time.Since(start); waited < 200*time.Millisecond {
+		t.Errorf("c readmitida a los %v, antes del tiempo de expulsión", waited)
+	}
+}
+
+func TestOutlierDetectionMaxEjectionPercent(t *testing.T) {
+	cases := []struct {
+		percent int
+		//Servidores que fallan a los que siguen llegando llamadas
+		remaining int
+	}{
+		//El límite se redondea hacia arriba: el 10% de 3 direcciones permite expulsar una
+		{10, 1},
+		{100, 0},
+		//0 desactiva las expulsiones
+		{0, 2},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprint(tc.percent), func(t *testing.T) {
+			b := &failingServer{testServer: &testServer{name: "b"}, failing: 1}
+			c := &failingServer{testServer: &testServer{name: "c"}, failing: 1}
+			addrs := startServers(t, &testServer{name: "a"}, b, c)
+			client := dial(t, fmt.Sprintf(outlierConfig, "1h", tc.percent),
+				resolver.Address{Addr: addrs[0]}, resolver.Address{Addr: addrs[1]}, resolver.Address{Addr: addrs[2]})
+
+			//Las expulsiones son asíncronas: se espera a que el reparto quede estable en dos rondas seguidas
+			var last map[string]int
+			ok, failed := settle(t, client, func(ok, failed map[string]int) bool {
+				stable := len(failed) == tc.remaining && len(last) == len(failed)
+				for name := range failed {
+					stable = stable && last[name] > 0
+				}
+				last = failed
+				return stable && ok["a"] > 0
+			})
+			if len(failed) != tc.remaining {
+				t.Fatalf("llamadas fallidas %v, esperados %d servidores sin expulsar", failed, tc.remaining)
+			}
+			if len(ok) != 1 {
+				t.Fatalf("atendidas %v, esperado solo a", ok)
+			}
+		})
+	}
+}
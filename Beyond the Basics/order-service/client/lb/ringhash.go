@@ -0,0 +1,195 @@
+package lb
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	ns "interceptors/cliente/nameservice"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+//RingHashName nombre del balanceador en el service config
+const RingHashName = "ring_hash"
+
+//HashKeyHeader metadato con la clave por la que se reparten las llamadas. Las llamadas con la misma clave van al mismo backend
+const HashKeyHeader = "x-hash-key"
+
+//Llamadas por dirección, publicadas en /debug/vars
+var ringHashMetrics = expvar.NewMap("lb_ring_hash")
+
+func init() {
+	balancer.Register(ringHashBuilder{})
+}
+
+//WithHashKey añade al contexto la clave de la llamada. Si ya tenía una se mantiene
+func WithHashKey(ctx context.Context, key string) context.Context {
+	if _, ok := HashKeyFromContext(ctx); ok {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, HashKeyHeader, key)
+}
+
+//HashKeyFromContext devuelve la clave de la llamada
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if v := md.Get(HashKeyHeader); len(v) > 0 {
+		return v[0], true
+	}
+	return "", false
+}
+
+//RingHashConfig configuración del balanceador en el service config:
+//{"loadBalancingConfig": [{"ring_hash": {"pointsPerWeight": 256, "maxRingSize": 262144}}]}
+type RingHashConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	//Puntos del anillo por cada unidad de peso de un backend. Cuantos más puntos, más se ajusta el reparto a los pesos. Por defecto 256
+	PointsPerWeight int `json:"pointsPerWeight"`
+	//Máximo de puntos del anillo. El peso lo pone el resolver y no tiene límite, así que si los pesos piden más puntos se reparten
+	//estos entre los backends en proporción a su peso. Por defecto 256K
+	MaxRingSize int `json:"maxRingSize"`
+}
+
+//defaultRingHashConfig configuración por defecto del balanceador
+func defaultRingHashConfig() *RingHashConfig {
+	return &RingHashConfig{PointsPerWeight: 256, MaxRingSize: 256 * 1024}
+}
+
+type ringHashBuilder struct{}
+
+func (ringHashBuilder) Name() string { return RingHashName }
+
+//Build crea el balanceador. Es el de base, con un constructor de pickers propio de cada ClientConn
+func (ringHashBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &ringHashPickerBuilder{cfg: defaultRingHashConfig()}
+	return &ringHashBalancer{
+		Balancer: base.NewBalancerBuilder(RingHashName, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+//ParseConfig lee la configuración del service config
+func (ringHashBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := defaultRingHashConfig()
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.PointsPerWeight <= 0 || cfg.PointsPerWeight > 64*1024 {
+		return nil, fmt.Errorf("%s: pointsPerWeight no válido: %d", RingHashName, cfg.PointsPerWeight)
+	}
+	if cfg.MaxRingSize <= 0 || cfg.MaxRingSize > 8*1024*1024 {
+		return nil, fmt.Errorf("%s: maxRingSize no válido: %d", RingHashName, cfg.MaxRingSize)
+	}
+	return cfg, nil
+}
+
+//ringHashBalancer pasa la configuración al constructor de pickers
+type ringHashBalancer struct {
+	balancer.Balancer
+	pb *ringHashPickerBuilder
+}
+
+func (b *ringHashBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*RingHashConfig); ok {
+		b.pb.mu.Lock()
+		b.pb.cfg = cfg
+		b.pb.mu.Unlock()
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type ringHashPickerBuilder struct {
+	mu  sync.Mutex
+	cfg *RingHashConfig
+}
+
+//ringEntry punto del anillo
+type ringEntry struct {
+	hash    uint64
+	subConn balancer.SubConn
+	addr    string
+}
+
+//Build construye el anillo con los backends listos. Cada backend ocupa un número fijo de puntos por unidad de peso, que no depende
+//del resto de backends, así que si un backend entra o sale solo cambian de backend las claves de sus puntos.
+//Si los pesos piden más de MaxRingSize puntos, cada backend ocupa la parte de MaxRingSize que le toca por su peso, y al menos un punto
+func (pb *ringHashPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	pb.mu.Lock()
+	cfg := pb.cfg
+	pb.mu.Unlock()
+
+	var total int64
+	for _, sci := range info.ReadySCs {
+		total += int64(ns.Weight(sci.Address))
+	}
+	points := func(weight int64) int64 { return weight * int64(cfg.PointsPerWeight) }
+	if points(total) > int64(cfg.MaxRingSize) {
+		points = func(weight int64) int64 {
+			if n := weight * int64(cfg.MaxRingSize) / total; n > 0 {
+				return n
+			}
+			return 1
+		}
+	}
+
+	var ring []ringEntry
+	for sc, sci := range info.ReadySCs {
+		n := points(int64(ns.Weight(sci.Address)))
+		for i := int64(0); i < n; i++ {
+			ring = append(ring, ringEntry{hash: hashKey(fmt.Sprintf("%s_%d", sci.Address.Addr, i)), subConn: sc, addr: sci.Address.Addr})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return &ringHashPicker{ring: ring, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+//hashKey hash FNV-1a de la clave. Se mezclan los bits al final, porque con claves parecidas, como las de los puntos de un backend, FNV deja los bits altos muy juntos
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+//ringHashPicker envía cada llamada al primer punto del anillo a partir del hash de su clave. Las llamadas sin clave van a un punto al azar
+type ringHashPicker struct {
+	ring []ringEntry
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (p *ringHashPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var h uint64
+	if key, ok := HashKeyFromContext(info.Ctx); ok {
+		h = hashKey(key)
+	} else {
+		p.mu.Lock()
+		h = p.rnd.Uint64()
+		p.mu.Unlock()
+	}
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	if i == len(p.ring) {
+		i = 0
+	}
+	ringHashMetrics.Add(p.ring[i].addr, 1)
+	return balancer.PickResult{SubConn: p.ring[i].subConn}, nil
+}
@@ -0,0 +1,151 @@
+package lb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	ns "interceptors/cliente/nameservice"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+//fakeSubConn SubConn que solo sirve para identificar el backend elegido
+type fakeSubConn struct{ addr string }
+
+func (*fakeSubConn) UpdateAddresses([]resolver.Address) {}
+func (*fakeSubConn) Connect()                           {}
+
+//ringPicker construye el picker de ring_hash con los backends indicados, todos listos. Los SubConn se reutilizan entre pickers,
+//como hace el balanceador de base
+func ringPicker(subConns map[string]*fakeSubConn, addrs ...string) balancer.Picker {
+	ready := map[balancer.SubConn]base.SubConnInfo{}
+	for _, addr := range addrs {
+		if subConns[addr] == nil {
+			subConns[addr] = &fakeSubConn{addr: addr}
+		}
+		ready[subConns[addr]] = base.SubConnInfo{Address: resolver.Address{Addr: addr}}
+	}
+	pb := &ringHashPickerBuilder{cfg: defaultRingHashConfig()}
+	return pb.Build(base.PickerBuildInfo{ReadySCs: ready})
+}
+
+//assign devuelve el backend de cada clave
+func assign(t *testing.T, p balancer.Picker, keys int) map[string]string {
+	t.Helper()
+	backends := map[string]string{}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprint("orden-", i)
+		res, err := p.Pick(balancer.PickInfo{Ctx: WithHashKey(context.Background(), key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends[key] = res.SubConn.(*fakeSubConn).addr
+	}
+	return backends
+}
+
+func TestRingHashSameKeySameBackend(t *testing.T) {
+	subConns := map[string]*fakeSubConn{}
+	p := ringPicker(subConns, "a", "b", "c")
+	first := assign(t, p, 1000)
+	//El anillo de un picker nuevo con los mismos backends es el mismo
+	again := assign(t, ringPicker(subConns, "c", "a", "b"), 1000)
+	for key, backend := range first {
+		if again[key] != backend {
+			t.Fatalf("%s: %s y luego %s", key, backend, again[key])
+		}
+	}
+}
+
+func TestRingHashMinimalRemapping(t *testing.T) {
+	const keys = 10000
+	subConns := map[string]*fakeSubConn{}
+	before := assign(t, ringPicker(subConns, "a", "b", "c", "d"), keys)
+
+	//Sale d: solo cambian de backend sus claves
+	after := assign(t, ringPicker(subConns, "a", "b", "c"), keys)
+	moved := 0
+	for key, backend := range before {
+		if backend != "d" && after[key] != backend {
+			t.Fatalf("%s pasa de %s a %s al salir d", key, backend, after[key])
+		}
+		if backend == "d" {
+			moved++
+		}
+	}
+	//Con 256 puntos por backend a cada uno le toca en torno a la cuarta parte de las claves
+	if moved < keys/4-keys/20 || moved > keys/4+keys/20 {
+		t.Errorf("d tenía %d claves de %d, esperada la cuarta parte", moved, keys)
+	}
+
+	//Entra e: las claves que cambian van todas a e, y son en torno a la quinta parte
+	added := assign(t, ringPicker(subConns, "a", "b", "c", "d", "e"), keys)
+	moved = 0
+	for key, backend := range before {
+		if added[key] == backend {
+			continue
+		}
+		if added[key] != "e" {
+			t.Fatalf("%s pasa de %s a %s al entrar e", key, backend, added[key])
+		}
+		moved++
+	}
+	if moved < keys/5-keys/20 || moved > keys/5+keys/20 {
+		t.Errorf("%d claves de %d cambian al entrar e, esperada la quinta parte", moved, keys)
+	}
+}
+
+func TestRingHashMaxRingSize(t *testing.T) {
+	weights := map[string]uint32{"a": 1 << 31, "b": 1 << 30, "c": 1}
+	pb := &ringHashPickerBuilder{cfg: &RingHashConfig{PointsPerWeight: 256, MaxRingSize: 3000}}
+	p := ringPickerWeights(pb, weights)
+
+	//Un peso enorme no hace crecer el anillo: los puntos se reparten por peso y todo backend tiene al menos uno
+	points := map[string]int{}
+	for _, e := range p.ring {
+		points[e.addr]++
+	}
+	if len(p.ring) > 3000+len(weights) {
+		t.Fatalf("%d puntos con maxRingSize 3000", len(p.ring))
+	}
+	if points["a"] < 1999 || points["a"] > 2000 || points["b"] < 999 || points["b"] > 1000 || points["c"] != 1 {
+		t.Errorf("puntos por backend %v, esperados a:2000 b:1000 c:1", points)
+	}
+
+	//Por debajo del máximo cada unidad de peso sigue valiendo pointsPerWeight puntos
+	pb.cfg = &RingHashConfig{PointsPerWeight: 256, MaxRingSize: 1024}
+	p = ringPickerWeights(pb, map[string]uint32{"a": 3, "b": 1})
+	if len(p.ring) != 1024 {
+		t.Errorf("%d puntos, esperados 1024", len(p.ring))
+	}
+}
+
+//ringPickerWeights construye el picker de ring_hash con pb y los backends con sus pesos
+func ringPickerWeights(pb *ringHashPickerBuilder, weights map[string]uint32) *ringHashPicker {
+	ready := map[balancer.SubConn]base.SubConnInfo{}
+	for addr, w := range weights {
+		ready[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: ns.WithEndpoint(resolver.Address{Addr: addr}, w, "")}
+	}
+	return pb.Build(base.PickerBuildInfo{ReadySCs: ready}).(*ringHashPicker)
+}
+
+func TestRingHashParseConfig(t *testing.T) {
+	for js, ok := range map[string]bool{
+		`{}`:                      true,
+		`{"maxRingSize": 1024}`:   true,
+		`{"maxRingSize": 0}`:      false,
+		`{"maxRingSize": 1e9}`:    false,
+		`{"pointsPerWeight": -1}`: false,
+	} {
+		cfg, err := ringHashBuilder{}.ParseConfig([]byte(js))
+		if (err == nil) != ok {
+			t.Errorf("%s: %v", js, err)
+		}
+		if js == `{}` && err == nil && cfg.(*RingHashConfig).MaxRingSize != 256*1024 {
+			t.Errorf("maxRingSize por defecto %d", cfg.(*RingHashConfig).MaxRingSize)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package lb
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"time"
+
+	"comun/orca"
+	ns "interceptors/cliente/nameservice"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+//WeightedRoundRobinName nombre del balanceador en el service config
+const WeightedRoundRobinName = "weighted_round_robin"
+
+//Pesos en uso, por dirección, publicados en /debug/vars
+var wrrMetrics = expvar.NewMap("lb_weighted_round_robin")
+
+func init() {
+	balancer.Register(wrrBuilder{})
+}
+
+//WeightedRoundRobinConfig configuración del balanceador en el service config:
+//{"loadBalancingConfig": [{"weighted_round_robin": {"enableLoadReport": true}}]}
+type WeightedRoundRobinConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	//Calcula los pesos con la carga que informan los servidores en el trailer. Si no, se usan los pesos de los atributos de cada dirección
+	EnableLoadReport bool `json:"enableLoadReport"`
+	//Tiempo desde el primer informe de carga de un servidor hasta que se usa su peso calculado. Por defecto 10 segundos
+	BlackoutPeriod Duration `json:"blackoutPeriod"`
+	//Si un servidor no informa de su carga durante este tiempo se deja de usar su peso calculado. Por defecto 3 minutos
+	WeightExpirationPeriod Duration `json:"weightExpirationPeriod"`
+	//Penalización de los errores: el peso es rps / (utilización + eps / rps * penalización)
+	ErrorUtilizationPenalty float64 `json:"errorUtilizationPenalty"`
+}
+
+func defaultWRRConfig() *WeightedRoundRobinConfig {
+	return &WeightedRoundRobinConfig{
+		BlackoutPeriod:         Duration(10 * time.Second),
+		WeightExpirationPeriod: Duration(3 * time.Minute),
+	}
+}
+
+type wrrBuilder struct{}
+
+func (wrrBuilder) Name() string { return WeightedRoundRobinName }
+
+//Build crea el balanceador. Es el de base, con un constructor de pickers propio de cada ClientConn que guarda la carga de los servidores
+func (wrrBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &wrrPickerBuilder{cfg: defaultWRRConfig(), loads: make(map[string]*addrLoad)}
+	return &wrrBalancer{
+		Balancer: base.NewBalancerBuilder(WeightedRoundRobinName, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+//ParseConfig lee la configuración del service config
+func (wrrBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := defaultWRRConfig()
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+//wrrBalancer pasa la configuración al constructor de pickers
+type wrrBalancer struct {
+	balancer.Balancer
+	pb *wrrPickerBuilder
+}
+
+func (b *wrrBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*WeightedRoundRobinConfig); ok {
+		b.pb.setConfig(cfg)
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+//addrLoad peso calculado a partir de la carga que informa un servidor
+type addrLoad struct {
+	weight float64
+	//Desde cuándo hay informes de carga sin interrupción
+	nonEmptySince time.Time
+	lastUpdated   time.Time
+}
+
+type wrrPickerBuilder struct {
+	mu    sync.Mutex
+	cfg   *WeightedRoundRobinConfig
+	loads map[string]*addrLoad
+}
+
+func (pb *wrrPickerBuilder) setConfig(cfg *WeightedRoundRobinConfig) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.cfg = cfg
+}
+
+//Build construye el picker con los backends listos. Olvida la carga de las direcciones que ya no están listas, porque se han ido del
+//resolver o han perdido la conexión: si vuelven, su peso calculado empieza otra vez con el periodo de espera
+func (pb *wrrPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	ready := make(map[string]bool, len(info.ReadySCs))
+	for _, sci := range info.ReadySCs {
+		ready[sci.Address.Addr] = true
+	}
+	pb.mu.Lock()
+	for addr := range pb.loads {
+		if !ready[addr] {
+			delete(pb.loads, addr)
+		}
+	}
+	pb.mu.Unlock()
+
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &wrrPicker{pb: pb}
+	for sc, sci := range info.ReadySCs {
+		p.subConns = append(p.subConns, sc)
+		p.addrs = append(p.addrs, sci.Address)
+	}
+	p.current = make([]float64, len(p.subConns))
+	return p
+}
+
+//report actualiza el peso calculado de la dirección con el informe de carga del trailer
+func (pb *wrrPickerBuilder) report(addr string, info balancer.DoneInfo) {
+	r, ok := orca.ParseLoadReport(info.Trailer)
+	if !ok {
+		return
+	}
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	weight := 0.0
+	if r.RPS > 0 && r.Utilization > 0 {
+		weight = r.RPS / (r.Utilization + r.EPS/r.RPS*pb.cfg.ErrorUtilizationPenalty)
+	}
+	l, ok := pb.loads[addr]
+	if !ok {
+		l = &addrLoad{}
+		pb.loads[addr] = l
+	}
+	now := time.Now()
+	if weight == 0 {
+		//Un informe vacío reinicia el periodo de espera
+		l.nonEmptySince = time.Time{}
+		return
+	}
+	if l.nonEmptySince.IsZero() || now.Sub(l.lastUpdated) > time.Duration(pb.cfg.WeightExpirationPeriod) {
+		l.nonEmptySince = now
+	}
+	l.weight = weight
+	l.lastUpdated = now
+}
+
+//weights pesos de las direcciones. Con informes de carga, las direcciones sin peso calculado reciben la media de las que lo tienen
+func (pb *wrrPickerBuilder) weights(addrs []resolver.Address, weights []float64) bool {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	if pb.cfg.EnableLoadReport {
+		now := time.Now()
+		sum, known := 0.0, 0
+		for i, a := range addrs {
+			weights[i] = 0
+			l, ok := pb.loads[a.Addr]
+			if !ok || l.nonEmptySince.IsZero() {
+				continue
+			}
+			if now.Sub(l.lastUpdated) > time.Duration(pb.cfg.WeightExpirationPeriod) || now.Sub(l.nonEmptySince) < time.Duration(pb.cfg.BlackoutPeriod) {
+				continue
+			}
+			weights[i] = l.weight
+			sum += l.weight
+			known++
+		}
+		if known > 0 {
+			for i := range weights {
+				if weights[i] == 0 {
+					weights[i] = sum / float64(known)
+				}
+			}
+			return true
+		}
+	}
+	for i, a := range addrs {
+		weights[i] = float64(ns.Weight(a))
+	}
+	return pb.cfg.EnableLoadReport
+}
+
+//publishWeight publica el peso en uso de la dirección. Reutiliza la variable de la dirección, para no crear una cada vez
+func publishWeight(addr string, weight float64) {
+	if f, ok := wrrMetrics.Get(addr).(*expvar.Float); ok {
+		f.Set(weight)
+		return
+	}
+	f := new(expvar.Float)
+	f.Set(weight)
+	wrrMetrics.Set(addr, f)
+}
+
+//wrrPicker reparte las llamadas con el algoritmo de round robin ponderado suave de nginx,
+//que intercala los backends en lugar de enviar seguidas todas las llamadas de uno
+type wrrPicker struct {
+	pb       *wrrPickerBuilder
+	subConns []balancer.SubConn
+	addrs    []resolver.Address
+
+	mu      sync.Mutex
+	current []float64
+	weights []float64
+	//Pesos publicados en wrrMetrics. Solo se vuelven a publicar los que cambian
+	published []float64
+}
+
+func (p *wrrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	if p.weights == nil {
+		p.weights = make([]float64, len(p.addrs))
+	}
+	loadReport := p.pb.weights(p.addrs, p.weights)
+	//Los pesos siempre son positivos, así que el primer Pick los publica todos
+	if p.published == nil {
+		p.published = make([]float64, len(p.addrs))
+	}
+	for i, w := range p.weights {
+		if w != p.published[i] {
+			p.published[i] = w
+			publishWeight(p.addrs[i].Addr, w)
+		}
+	}
+	best, total := 0, 0.0
+	for i, w := range p.weights {
+		p.current[i] += w
+		total += w
+		if p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	p.current[best] -= total
+	addr := p.addrs[best].Addr
+	p.mu.Unlock()
+
+	res := balancer.PickResult{SubConn: p.subConns[best]}
+	if loadReport {
+		res.Done = func(info balancer.DoneInfo) { p.pb.report(addr, info) }
+	}
+	return res, nil
+}
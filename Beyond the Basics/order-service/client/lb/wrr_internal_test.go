@@ -0,0 +1,42 @@
+package lb
+
+import (
+	"testing"
+
+	"comun/orca"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestWeightedRoundRobinPrunesLoads(t *testing.T) {
+	pb := &wrrPickerBuilder{cfg: defaultWRRConfig(), loads: make(map[string]*addrLoad)}
+	build := func(addrs ...string) {
+		ready := map[balancer.SubConn]base.SubConnInfo{}
+		for _, addr := range addrs {
+			ready[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{Addr: addr}}
+		}
+		pb.Build(base.PickerBuildInfo{ReadySCs: ready})
+	}
+	report := orca.LoadReportTrailer(orca.LoadReport{Utilization: 0.5, RPS: 10})
+
+	build("a", "b")
+	pb.report("a", balancer.DoneInfo{Trailer: report})
+	pb.report("b", balancer.DoneInfo{Trailer: report})
+	if len(pb.loads) != 2 {
+		t.Fatalf("%d cargas, esperadas 2", len(pb.loads))
+	}
+
+	//Al salir b del resolver se olvida su carga, y se mantiene la de a
+	build("a", "c")
+	if _, ok := pb.loads["b"]; ok || pb.loads["a"] == nil || len(pb.loads) != 1 {
+		t.Errorf("cargas tras salir b: %v", pb.loads)
+	}
+
+	//Sin backends listos no queda ninguna
+	build()
+	if len(pb.loads) != 0 {
+		t.Errorf("cargas sin backends: %v", pb.loads)
+	}
+}
@@ -0,0 +1,141 @@
+package lb_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"comun/orca"
+	pb "interceptors/cliente/ecommerce"
+	_ "interceptors/cliente/lb" // Registra los balanceadores
+	ns "interceptors/cliente/nameservice"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+//testServer servidor de órdenes que responde a getOrder con su nombre en la descripción
+type testServer struct {
+	pb.UnimplementedOrderManagementServer
+	name string
+	//Informe de carga que se devuelve en el trailer. Vacío no informa
+	load string
+}
+
+func (s *testServer) GetOrder(ctx context.Context, id *wrappers.StringValue) (*pb.Order, error) {
+	if s.load != "" {
+		grpc.SetTrailer(ctx, metadata.Pairs(orca.LoadReportKey, s.load))
+	}
+	return &pb.Order{Id: id.Value, Description: s.name}, nil
+}
+
+//startServers arranca los servidores y devuelve sus direcciones
+func startServers(t *testing.T, servers ...pb.OrderManagementServer) []string {
+	t.Helper()
+	var addrs []string
+	for _, srv := range servers {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := grpc.NewServer()
+		pb.RegisterOrderManagementServer(s, srv)
+		go s.Serve(lis)
+		t.Cleanup(s.Stop)
+		addrs = append(addrs, lis.Addr().String())
+	}
+	return addrs
+}
+
+//dial conecta con las direcciones usando el service config indicado
+func dial(t *testing.T, serviceConfig string, addrs ...resolver.Address) pb.OrderManagementClient {
+	t.Helper()
+	r := manual.NewBuilderWithScheme("test")
+	r.InitialState(resolver.State{Addresses: addrs})
+	conn, err := grpc.Dial(r.Scheme()+":///orders", grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithDefaultServiceConfig(serviceConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewOrderManagementClient(conn)
+}
+
+//count hace n llamadas y cuenta las que atiende cada servidor
+func count(t *testing.T, client pb.OrderManagementClient, n int) map[string]int {
+	t.Helper()
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		o, err := client.GetOrder(ctx, &wrappers.StringValue{Value: fmt.Sprint(i)})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[o.Description]++
+	}
+	return counts
+}
+
+//warmUp llama hasta que todos los servidores están listos, para que el picker no cambie durante la prueba
+func warmUp(t *testing.T, client pb.OrderManagementClient, servers int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(count(t, client, 20)) < servers {
+		if time.Now().After(deadline) {
+			t.Fatal("los servidores no están listos")
+		}
+	}
+}
+
+func within(got, want, tolerance int) bool {
+	return got >= want-tolerance && got <= want+tolerance
+}
+
+func TestWeightedRoundRobinStaticWeights(t *testing.T) {
+	addrs := startServers(t, &testServer{name: "a"}, &testServer{name: "b"}, &testServer{name: "c"})
+	client := dial(t, `{"loadBalancingConfig": [{"weighted_round_robin": {}}]}`,
+		ns.WithEndpoint(resolver.Address{Addr: addrs[0]}, 3, ""),
+		ns.WithEndpoint(resolver.Address{Addr: addrs[1]}, 2, ""),
+		ns.WithEndpoint(resolver.Address{Addr: addrs[2]}, 1, ""))
+	warmUp(t, client, 3)
+
+	got := count(t, client, 600)
+	if !within(got["a"], 300, 6) || !within(got["b"], 200, 6) || !within(got["c"], 100, 6) {
+		t.Fatalf("reparto %v, esperado a=300 b=200 c=100", got)
+	}
+}
+
+func TestWeightedRoundRobinLoadReport(t *testing.T) {
+	//a atiende las mismas peticiones que b con la cuarta parte de utilización, así que debe recibir cuatro veces más llamadas
+	addrs := startServers(t,
+		&testServer{name: "a", load: "TEXT application_utilization=0.2, rps_fractional=100, eps=0"},
+		&testServer{name: "b", load: "TEXT cpu_utilization=0.8, rps_fractional=100"})
+	client := dial(t, `{"loadBalancingConfig": [{"weighted_round_robin": {"enableLoadReport": true, "blackoutPeriod": "0s"}}]}`,
+		resolver.Address{Addr: addrs[0]}, resolver.Address{Addr: addrs[1]})
+	warmUp(t, client, 2)
+
+	got := count(t, client, 500)
+	if !within(got["a"], 400, 5) || !within(got["b"], 100, 5) {
+		t.Fatalf("reparto %v, esperado a=400 b=100", got)
+	}
+}
+
+func TestWeightedRoundRobinLoadReportBlackout(t *testing.T) {
+	//Durante el periodo de espera se ignoran los informes de carga y se usan los pesos estáticos
+	addrs := startServers(t,
+		&testServer{name: "a", load: "TEXT application_utilization=0.2, rps_fractional=100, eps=0"},
+		&testServer{name: "b", load: "TEXT application_utilization=0.8, rps_fractional=100, eps=0"})
+	client := dial(t, `{"loadBalancingConfig": [{"weighted_round_robin": {"enableLoadReport": true, "blackoutPeriod": "1h"}}]}`,
+		resolver.Address{Addr: addrs[0]}, resolver.Address{Addr: addrs[1]})
+	warmUp(t, client, 2)
+
+	got := count(t, client, 200)
+	if !within(got["a"], 100, 2) || !within(got["b"], 100, 2) {
+		t.Fatalf("reparto %v, esperado a=100 b=100", got)
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"comun/cache"
+	"comun/tracing"
 	"context"
+	"flag"
 	"fmt"
 	pb "interceptors/cliente/ecommerce"
 	interceptors "interceptors/cliente/interceptors"
+	"interceptors/cliente/lb"
 	ns "interceptors/cliente/nameservice"
 
 	"io"
 	"log"
+	"os"
 	"strconv"
 	"time"
 
@@ -21,6 +26,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -29,6 +35,16 @@ const (
 	usarDeadline = true
 )
 
+var (
+	traces    = flag.String("traces", "", "fichero en el que se exportan los spans")
+	endpoints = flag.String("endpoints", "endpoints.example.json", "fichero JSON o YAML con los endpoints del servidor")
+	dnsTarget = flag.String("dns", "", "servicio que se descubre con registros SRV y TXT, por ejemplo dnssrv://127.0.0.1:5353/orders.example.com")
+	zone      = flag.String("zone", "zona-a", "zona del cliente, para el balanceo por localidad")
+	registry  = flag.String("registry", "", "dirección del registro de servicios, por ejemplo localhost:50100")
+	scFile    = flag.String("service-config", "service_config.example.json", "fichero JSON o YAML con la configuración del servicio")
+	token     = flag.String("token", "", "token bearer para el servidor arrancado con -require-auth")
+)
+
 //******************************************
 //Demuestra el balanceo de carga de cliente
 //******************************************
@@ -40,6 +56,8 @@ func balanceoCargaPickFirst() {
 	pickfirstConn, errlb := grpc.Dial(
 		fmt.Sprintf("%s:///%s", ns.ExampleScheme, ns.ExampleServiceName), // "example:///lb.example.grpc.io"
 		// grpc.WithBalancerName("pick_first"), // "pick_first" is the default, so this DialOption is not necessary.
+		//Sin esta opción se aplicaría el round_robin de la configuración que entrega el resolver
+		grpc.WithDisableServiceConfig(),
 		grpc.WithInsecure(),
 	)
 
@@ -61,6 +79,8 @@ func balanceoCargaRoundrobin() {
 		fmt.Sprintf("%s:///%s", ns.ExampleScheme, ns.ExampleServiceName), // // "example:///lb.example.grpc.io"
 		grpc.WithBalancerName("round_robin"),                             // This sets the initial balancing policy.
 		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(tracing.StreamClientInterceptor),
 	)
 	if errlb != nil {
 		log.Fatalf("did not connect: %v", errlb)
@@ -93,6 +113,76 @@ func callUnaryOrder(c pb.OrderManagementClient, message pb.Order) {
 	}
 }
 
+//******************************************
+//Autenticación en llamadas unitarias y streams
+//******************************************
+
+//bearerToken envía el token en el metadato authorization de todas las llamadas
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+//RequireTransportSecurity es false solo porque el ejemplo no usa TLS. Un token no debería viajar nunca en claro
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
+
+func usaAutenticacion() {
+	conn, err := grpc.Dial(address, grpc.WithInsecure(), grpc.WithPerRPCCredentials(bearerToken(*token)))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewOrderManagementClient(conn)
+
+	log.Println("==== Calling with bearer token ====")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.GetOrder(ctx, &wrapper.StringValue{Value: "106"}); err != nil {
+		log.Printf("====== [Auth] getOrder: %v", err)
+	}
+	//El servidor autentica el stream al abrirlo; el error llega con el primer Recv
+	stream, err := client.SearchOrders(ctx, &wrapper.StringValue{Value: "Google"})
+	if err != nil {
+		log.Fatalf("searchOrders: %v", err)
+	}
+	for {
+		order, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("====== [Auth] searchOrders: %v", err)
+			break
+		}
+		log.Printf("====== [Auth] searchOrders: %s", order.Id)
+	}
+
+	//Con -rbac el servidor solo deja llamar a addOrder a los roles que lo permiten. El detalle ErrorInfo dice por qué se deniega
+	if _, err := client.AddOrder(ctx, &pb.Order{Id: "201", Items: []string{"Google Pixel 4"}, Destination: "San Jose, CA", Price: 700}); err != nil {
+		log.Printf("====== [Auth] addOrder: %v", err)
+		for _, d := range status.Convert(err).Details() {
+			if info, ok := d.(*epb.ErrorInfo); ok {
+				log.Printf("====== [Auth] addOrder denegado: %s %v", info.Reason, info.Metadata)
+			}
+		}
+	}
+
+	//Sin token el stream se rechaza con Unauthenticated si el servidor se arrancó con -require-auth
+	anon, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer anon.Close()
+	stream, err = pb.NewOrderManagementClient(anon).SearchOrders(ctx, &wrapper.StringValue{Value: "Google"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	log.Printf("====== [Auth] searchOrders sin token: %v", status.Code(err))
+}
+
 //******************************************
 //Llamadas con interceptor
 //******************************************
@@ -100,17 +190,19 @@ func callUnaryOrder(c pb.OrderManagementClient, message pb.Order) {
 func usaInterceptors() {
 	// Conexion con el servidor. Configura interceptors
 	conn, err := grpc.Dial(address, grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(interceptors.OrderUnaryClientInterceptor),
-		grpc.WithStreamInterceptor(interceptors.ClientStreamInterceptor))
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor, interceptors.OrderUnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor, interceptors.ClientStreamInterceptor))
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
 	defer conn.Close()
 	client := pb.NewOrderManagementClient(conn)
 
-	//Contexto que vamos a usar en las llamadas
+	//Contexto que vamos a usar en las llamadas. Todas las llamadas forman parte de la misma traza
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
+	ctx, span := tracing.Start(ctx, "usaInterceptors", tracing.KindInternal)
+	defer span.End()
 
 	unitarioRPC(ctx, client)
 
@@ -247,6 +339,341 @@ func streamBidireccionalRPC(ctx context.Context, client pb.OrderManagementClient
 	<-channel
 }
 
+//******************************************
+//Llamadas con circuit breaker
+//******************************************
+
+func usaCircuitBreaker() {
+	cb := interceptors.NewCircuitBreaker(interceptors.CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         5 * time.Second,
+	})
+
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.ExampleScheme, ns.ExampleServiceName),
+		grpc.WithBalancerName("round_robin"),
+		grpc.WithInsecure(),
+		grpc.WithChainUnaryInterceptor(cb.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(cb.StreamClientInterceptor()))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewOrderManagementClient(conn)
+
+	//Si el backend no responde, tras tres fallos las llamadas fallan inmediatamente con Unavailable
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		retrievedOrder, err := client.GetOrder(ctx, &wrapper.StringValue{Value: "106"})
+		cancel()
+		if err != nil {
+			log.Printf("Error Occured -> getOrder : , %v:", status.Convert(err).Message())
+		} else {
+			log.Print("GetOrder Response -> : ", retrievedOrder)
+		}
+	}
+}
+
+//******************************************
+//Llamadas con hedging
+//******************************************
+
+func usaHedging() {
+	hedging := interceptors.NewHedging(interceptors.HedgingConfig{
+		Methods:       interceptors.HedgedReadMethods,
+		Delay:         20 * time.Millisecond,
+		MaxAttempts:   2,
+		NonFatalCodes: []codes.Code{codes.Unavailable},
+	})
+
+	//Con round_robin el segundo intento va a la otra dirección de lb.example.grpc.io
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.ExampleScheme, ns.ExampleServiceName),
+		grpc.WithBalancerName("round_robin"),
+		grpc.WithInsecure(),
+		grpc.WithChainUnaryInterceptor(hedging.UnaryClientInterceptor()))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewOrderManagementClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	retrievedOrder, err := client.GetOrder(ctx, &wrapper.StringValue{Value: "106"})
+	if err != nil {
+		log.Printf("Error Occured -> getOrder : , %v:", status.Code(err))
+	} else {
+		log.Print("GetOrder Response -> : ", retrievedOrder)
+	}
+}
+
+//******************************************
+//Llamadas con caché
+//******************************************
+
+func usaCache() {
+	//Solo ve las escrituras que se hacen por esta conexión, así que el TTL es el tiempo máximo durante el que puede devolver datos antiguos
+	responseCache := interceptors.NewResponseCache(cache.New("cliente", 100, 10*time.Second),
+		interceptors.CachedReadMethods, interceptors.CacheInvalidatingMethods)
+
+	conn, err := grpc.Dial(address, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(responseCache.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(responseCache.StreamClientInterceptor()))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewOrderManagementClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	//La segunda llamada se sirve desde la caché
+	for i := 0; i < 2; i++ {
+		retrievedOrder, err := client.GetOrder(ctx, &wrapper.StringValue{Value: "106"})
+		if err != nil {
+			log.Printf("Error Occured -> getOrder : , %v:", status.Code(err))
+		} else {
+			log.Print("GetOrder Response -> : ", retrievedOrder)
+		}
+	}
+
+	//Con no-cache la llamada va siempre al servidor, y el servidor tampoco usa su caché
+	noCacheCtx := metadata.AppendToOutgoingContext(ctx, cache.CacheControlKey, "no-cache")
+	if _, err := client.GetOrder(noCacheCtx, &wrapper.StringValue{Value: "106"}); err != nil {
+		log.Printf("Error Occured -> getOrder : , %v:", status.Code(err))
+	}
+}
+
+//******************************************
+//Endpoints leídos de un fichero
+//******************************************
+
+func usaFileResolver() {
+	//Si se edita el fichero mientras el cliente está en marcha, las llamadas se reparten entre los nuevos endpoints
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.FileScheme, *endpoints), // "file:///endpoints.example.json"
+		grpc.WithBalancerName("round_robin"),
+		grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	log.Println("==== Calling with endpoints from " + *endpoints + " ====")
+	makeRPCs(conn, 4)
+}
+
+//******************************************
+//Balanceo round robin ponderado
+//******************************************
+
+func usaWeightedRoundRobin() {
+	//Los pesos estáticos salen del fichero de endpoints. Con enableLoadReport se ajustan con la carga que informa cada servidor
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.FileScheme, *endpoints),
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {"enableLoadReport": true}}]}`, lb.WeightedRoundRobinName)))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	log.Println("==== Calling with " + lb.WeightedRoundRobinName + " ====")
+	makeRPCs(conn, 8)
+}
+
+//******************************************
+//Balanceo al backend con menos llamadas en curso
+//******************************************
+
+func usaLeastRequest() {
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.FileScheme, *endpoints),
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {"choiceCount": 2}}]}`, lb.LeastRequestName)))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	//Mientras el stream sigue abierto cuenta como una llamada en curso, así que las llamadas unitarias van preferentemente al otro backend
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := pb.NewOrderManagementClient(conn).ProcessOrders(ctx); err != nil {
+		log.Printf("Error Occured -> processOrders : , %v:", status.Code(err))
+	}
+
+	log.Println("==== Calling with " + lb.LeastRequestName + " ====")
+	makeRPCs(conn, 4)
+}
+
+//******************************************
+//Balanceo por hash consistente del ID de la orden
+//******************************************
+
+func usaRingHash() {
+	//El interceptor añade el ID de la orden como clave, así que todas las llamadas sobre la misma orden van al mismo backend
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.FileScheme, *endpoints),
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(interceptors.OrderHashKeyUnaryClientInterceptor),
+		grpc.WithStreamInterceptor(interceptors.OrderHashKeyStreamClientInterceptor),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {}}]}`, lb.RingHashName)))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewOrderManagementClient(conn)
+
+	log.Println("==== Calling with " + lb.RingHashName + " ====")
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		var p peer.Peer
+		_, err := client.GetOrder(ctx, &wrapper.StringValue{Value: "106"}, grpc.Peer(&p))
+		cancel()
+		if err != nil {
+			log.Printf("Error Occured -> getOrder : , %v:", status.Code(err))
+		} else {
+			log.Printf("GetOrder 106 -> %v", p.Addr)
+		}
+	}
+
+	//En un stream la clave es la orden del primer mensaje: el stream no se abre hasta que se envía
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	updateStream, err := client.UpdateOrders(ctx)
+	if err != nil {
+		log.Printf("Error Occured -> updateOrders : , %v:", status.Code(err))
+		return
+	}
+	updateStream.Send(&pb.Order{Id: "106", Items: []string{"Amazon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 30.00})
+	if res, err := updateStream.CloseAndRecv(); err != nil {
+		log.Printf("Error Occured -> updateOrders : , %v:", status.Code(err))
+	} else {
+		log.Printf("Update Orders Res : %s", res)
+	}
+}
+
+//******************************************
+//Expulsión de los backends que fallan
+//******************************************
+
+func usaOutlierDetection() {
+	//Envuelve a round_robin. Un backend que falla tres veces seguidas deja de recibir llamadas durante 30 segundos
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.FileScheme, *endpoints),
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {
+			"interval": "1s",
+			"baseEjectionTime": "30s",
+			"maxEjectionPercent": 50,
+			"consecutiveFailures": 3,
+			"successRateEjection": {"minimumHosts": 2, "requestVolume": 10},
+			"childPolicy": [{"round_robin": {}}]}}]}`, lb.OutlierDetectionName)))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	log.Println("==== Calling with " + lb.OutlierDetectionName + " ====")
+	makeRPCs(conn, 4)
+}
+
+//******************************************
+//Balanceo por zona
+//******************************************
+
+func usaLocality() {
+	//Las llamadas van a los endpoints de la zona del cliente mientras tenga listo el 70% de su peso; si no, a las demás zonas
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.FileScheme, *endpoints),
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {"localZone": %q, "failoverThreshold": 0.7}}]}`, lb.LocalityName, *zone)))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewOrderManagementClient(conn)
+
+	log.Println("==== Calling with " + lb.LocalityName + " from " + *zone + " ====")
+	for i := 0; i < 4; i++ {
+		var l lb.Locality
+		ctx, cancel := context.WithTimeout(lb.WithLocality(context.Background(), &l), time.Second)
+		_, err := client.GetOrder(ctx, &wrapper.StringValue{Value: "106"})
+		cancel()
+		if err != nil {
+			log.Printf("Error Occured -> getOrder : , %v:", status.Code(err))
+		} else {
+			log.Printf("GetOrder 106 -> %v", l)
+		}
+	}
+}
+
+//******************************************
+//Configuración del servicio entregada por el resolver
+//******************************************
+
+func usaServiceConfig() {
+	sc, err := ns.LoadServiceConfig(*scFile)
+	if err != nil {
+		log.Fatalf("failed to load service config: %v", err)
+	}
+	//En esta versión de gRPC los reintentos solo funcionan si se arranca el cliente con GRPC_GO_RETRY=on
+	if os.Getenv("GRPC_GO_RETRY") != "on" {
+		log.Println("GRPC_GO_RETRY no es on: no se aplican las políticas de reintento")
+	}
+
+	//El resolver entrega la configuración junto con los endpoints. getOrder se reintenta si falla con UNAVAILABLE; addOrder no
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", ns.FileScheme, *endpoints),
+		grpc.WithInsecure(),
+		grpc.WithResolvers(&ns.FileResolverBuilder{ServiceConfig: sc}))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	log.Println("==== Calling with service config from " + *scFile + " ====")
+	makeRPCs(conn, 4)
+}
+
+//******************************************
+//Endpoints descubiertos con DNS
+//******************************************
+
+func usaDNSResolver() {
+	//Los backends salen de los registros SRV y la política de balanceo de los TXT
+	conn, err := grpc.Dial(*dnsTarget, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	log.Println("==== Calling with endpoints from " + *dnsTarget + " ====")
+	makeRPCs(conn, 4)
+}
+
+//******************************************
+//Endpoints del registro de servicios
+//******************************************
+
+func usaRegistry() {
+	//Los servidores se registran al arrancar y el resolver recibe las instancias vivas en cuanto cambian
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s://%s/orders", ns.RegistryScheme, *registry),
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	log.Println("==== Calling with endpoints from registry " + *registry + " ====")
+	makeRPCs(conn, 4)
+}
+
 //******************************************
 //Llamadas con deadline
 //Llamadas con compresion
@@ -337,6 +764,29 @@ func llamaConMetadatos(client pb.OrderManagementClient) {
 //******************************************
 
 func main() {
+	flag.Parse()
+
+	//El resolver de ejemplo entrega la configuración del servicio de -service-config junto con sus direcciones
+	sc, err := ns.LoadServiceConfig(*scFile)
+	if err != nil {
+		log.Fatalf("failed to load service config: %v", err)
+	}
+	resolver.Register(&ns.ExampleResolverBuilder{ServiceConfig: sc})
+
+	if *traces != "" {
+		exporter, err := tracing.NewFileExporter(*traces)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", *traces, err)
+		}
+		defer exporter.Close()
+		tracing.SetExporter(exporter)
+	}
+
+	//Con -token el servidor exige autenticación, y el resto de las demos no envían el token
+	if *token != "" {
+		usaAutenticacion()
+		return
+	}
 
 	balanceoCargaPickFirst()
 
@@ -344,8 +794,47 @@ func main() {
 
 	usaInterceptors()
 
+	usaCircuitBreaker()
+
+	usaHedging()
+
+	usaCache()
+
+	usaFileResolver()
+
+	usaWeightedRoundRobin()
+
+	usaLeastRequest()
+
+	usaRingHash()
+
+	usaOutlierDetection()
+
+	usaLocality()
+
+	usaServiceConfig()
+
+	if *dnsTarget != "" {
+		usaDNSResolver()
+	}
+
+	if *registry != "" {
+		usaRegistry()
+	}
+
+	//Timeouts por defecto para las llamadas que no indican deadline
+	timeouts := interceptors.DefaultTimeouts{
+		PerMethod: map[string]time.Duration{
+			"/ecommerce.OrderManagement/getOrder":      500 * time.Millisecond,
+			"/ecommerce.OrderManagement/processOrders": 10 * time.Second,
+		},
+		Default: 2 * time.Second,
+	}
+
 	// Setting up a connection to the server.
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	conn, err := grpc.Dial(address, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(timeouts.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(timeouts.StreamClientInterceptor()))
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
@@ -362,5 +851,7 @@ func main() {
 }
 
 func init() {
-	resolver.Register(&ns.ExampleResolverBuilder{})
+	resolver.Register(&ns.FileResolverBuilder{})
+	resolver.Register(&ns.DNSResolverBuilder{})
+	resolver.Register(&ns.RegistryResolverBuilder{Addr: "localhost:50100"})
 }
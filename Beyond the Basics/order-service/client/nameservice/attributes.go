@@ -0,0 +1,78 @@
+package nameservice
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+//Claves de los atributos que los resolvers añaden a cada dirección
+type weightKey struct{}
+type zoneKey struct{}
+type priorityKey struct{}
+
+//endpointAttributes atributos ya creados. Los balanceadores de base identifican cada SubConn por su resolver.Address, atributos incluidos,
+//así que dos direcciones iguales tienen que compartir los atributos para que un refresco del resolver no vuelva a crear las conexiones
+var endpointAttributes = struct {
+	sync.Mutex
+	m map[endpoint]*attributes.Attributes
+}{m: make(map[endpoint]*attributes.Attributes)}
+
+type endpoint struct {
+	weight   uint32
+	zone     string
+	priority uint32
+}
+
+//WithEndpoint añade a la dirección su peso y su zona
+func WithEndpoint(addr resolver.Address, weight uint32, zone string) resolver.Address {
+	return WithLocality(addr, weight, zone, 0)
+}
+
+//WithLocality añade a la dirección su peso, su zona y su prioridad. Las direcciones de prioridad 0 son las preferidas
+func WithLocality(addr resolver.Address, weight uint32, zone string, priority uint32) resolver.Address {
+	kvs := []interface{}{weightKey{}, weight, zoneKey{}, zone, priorityKey{}, priority}
+	if addr.Attributes == nil {
+		e := endpoint{weight: weight, zone: zone, priority: priority}
+		endpointAttributes.Lock()
+		defer endpointAttributes.Unlock()
+		if _, ok := endpointAttributes.m[e]; !ok {
+			endpointAttributes.m[e] = attributes.New(kvs...)
+		}
+		addr.Attributes = endpointAttributes.m[e]
+	} else {
+		addr.Attributes = addr.Attributes.WithValues(kvs...)
+	}
+	return addr
+}
+
+//Weight peso de la dirección. Las direcciones sin peso valen 1
+func Weight(addr resolver.Address) uint32 {
+	if addr.Attributes != nil {
+		if w, ok := addr.Attributes.Value(weightKey{}).(uint32); ok && w > 0 {
+			return w
+		}
+	}
+	return 1
+}
+
+//Zone zona de la dirección. Vacío si no tiene
+func Zone(addr resolver.Address) string {
+	if addr.Attributes != nil {
+		if z, ok := addr.Attributes.Value(zoneKey{}).(string); ok {
+			return z
+		}
+	}
+	return ""
+}
+
+//Priority prioridad de la dirección. Las direcciones sin prioridad son de prioridad 0, la preferida
+func Priority(addr resolver.Address) uint32 {
+	if addr.Attributes != nil {
+		if p, ok := addr.Attributes.Value(priorityKey{}).(uint32); ok {
+			return p
+		}
+	}
+	return 0
+}
@@ -0,0 +1,457 @@
+package nameservice
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+//DNSScheme esquema del resolver de DNS. dnssrv:///orders.example.com usa el servidor DNS del sistema y dnssrv://127.0.0.1:5353/orders.example.com el indicado
+const DNSScheme = "dnssrv"
+
+//Prefijos de los nombres que se consultan, los mismos que usa gRPC
+const (
+	//srvPrefix registros SRV con los backends
+	srvPrefix = "_grpc._tcp."
+	//txtPrefix registros TXT con la configuración del servicio
+	txtPrefix = "_grpc_config."
+	//txtAttribute atributo del registro TXT con la configuración
+	txtAttribute = "grpc_config="
+)
+
+var errNoRecords = errors.New("no hay registros")
+
+//DNSResolverBuilder constructor del resolver que obtiene los backends de los registros SRV y la configuración del servicio de los registros TXT
+type DNSResolverBuilder struct {
+	//Servidor DNS, host:puerto. Si está vacío se usa el del target o el primero de /etc/resolv.conf
+	Server string
+	//Tiempo mínimo entre dos consultas, aunque el TTL sea menor o se llame a ResolveNow. Por defecto 30 segundos
+	MinRefresh time.Duration
+	//Tiempo máximo entre dos consultas, aunque el TTL sea mayor. Por defecto 30 minutos
+	MaxRefresh time.Duration
+	//Tiempo máximo de cada consulta. Por defecto 5 segundos
+	Timeout time.Duration
+	//Configuración del servicio, en JSON, si no hay registro TXT con la suya
+	ServiceConfig string
+}
+
+//Build crea el resolver y hace la primera consulta en segundo plano
+func (b *DNSResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	if target.Endpoint == "" {
+		return nil, fmt.Errorf("falta el nombre del servicio: %s:///<servicio>", DNSScheme)
+	}
+	server := b.Server
+	if target.Authority != "" {
+		server = target.Authority
+	}
+	if server == "" {
+		var err error
+		if server, err = systemDNSServer(); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	r := &dnsResolver{
+		service:    strings.TrimSuffix(target.Endpoint, ".") + ".",
+		cc:         cc,
+		client:     &dnsClient{server: server, timeout: durationOr(b.Timeout, 5*time.Second)},
+		minRefresh: durationOr(b.MinRefresh, 30*time.Second),
+		maxRefresh: durationOr(b.MaxRefresh, 30*time.Minute),
+		sc:         b.ServiceConfig,
+		resolve:    make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.wg.Add(1)
+	go r.watch()
+	return r, nil
+}
+
+//Scheme esquema del resolver, dnssrv
+func (*DNSResolverBuilder) Scheme() string { return DNSScheme }
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+//systemDNSServer primer servidor de /etc/resolv.conf
+func systemDNSServer() (string, error) {
+	b, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 2 && f[0] == "nameserver" {
+			return net.JoinHostPort(f[1], "53"), nil
+		}
+	}
+	return "", errors.New("no hay ningún nameserver en /etc/resolv.conf")
+}
+
+type dnsResolver struct {
+	service    string
+	cc         resolver.ClientConn
+	client     *dnsClient
+	minRefresh time.Duration
+	maxRefresh time.Duration
+	sc         string
+	resolve    chan struct{}
+	done       chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+//watch consulta el DNS al arrancar, cuando caduca el TTL y cuando lo pide ResolveNow
+func (r *dnsResolver) watch() {
+	defer r.wg.Done()
+	for {
+		last := time.Now()
+		timer := time.NewTimer(r.lookup())
+		select {
+		case <-timer.C:
+		case <-r.resolve:
+			timer.Stop()
+			//Aunque se pida con ResolveNow no se consulta más de una vez cada minRefresh
+			select {
+			case <-time.After(time.Until(last.Add(r.minRefresh))):
+			case <-r.done:
+				return
+			}
+		case <-r.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+//lookup consulta los registros, actualiza el ClientConn y devuelve cuándo hay que volver a consultar
+func (r *dnsResolver) lookup() time.Duration {
+	state, ttl, err := r.query()
+	if err != nil {
+		if r.ctx.Err() == nil {
+			log.Printf("====== [Resolver] %s: %v", r.service, err)
+			r.cc.ReportError(err)
+		}
+		return r.minRefresh
+	}
+	log.Printf("====== [Resolver] %s: %d endpoints, TTL %v", r.service, len(state.Addresses), ttl)
+	r.cc.UpdateState(state)
+	if ttl < r.minRefresh {
+		return r.minRefresh
+	}
+	if ttl > r.maxRefresh {
+		return r.maxRefresh
+	}
+	return ttl
+}
+
+//query obtiene los backends de los registros SRV y la configuración de los TXT. El TTL es el menor de todos los registros
+func (r *dnsResolver) query() (resolver.State, time.Duration, error) {
+	srvs, err := r.client.query(r.ctx, srvPrefix+r.service, dnsmessage.TypeSRV)
+	if err != nil {
+		return resolver.State{}, 0, fmt.Errorf("SRV %s%s: %v", srvPrefix, r.service, err)
+	}
+	ttl := time.Duration(-1)
+	minTTL := func(t uint32) {
+		if d := time.Duration(t) * time.Second; ttl < 0 || d < ttl {
+			ttl = d
+		}
+	}
+
+	var state resolver.State
+	for _, rr := range srvs {
+		srv, ok := rr.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		minTTL(rr.Header.TTL)
+		ips, ipTTL, err := r.lookupHost(srv.Target.String())
+		if err != nil {
+			return resolver.State{}, 0, fmt.Errorf("%s: %v", srv.Target, err)
+		}
+		//Las IPs literales no tienen TTL
+		if ipTTL > 0 {
+			minTTL(ipTTL)
+		}
+		port := strconv.Itoa(int(srv.Port))
+		for _, ip := range ips {
+			addr := resolver.Address{Addr: net.JoinHostPort(ip, port), ServerName: strings.TrimSuffix(srv.Target.String(), ".")}
+			//Como en SRV, la prioridad menor es la preferida
+			state.Addresses = append(state.Addresses, WithLocality(addr, uint32(srv.Weight), "", uint32(srv.Priority)))
+		}
+	}
+	if len(state.Addresses) == 0 {
+		return resolver.State{}, 0, fmt.Errorf("SRV %s%s: %v", srvPrefix, r.service, errNoRecords)
+	}
+
+	//La configuración del servicio es opcional
+	txts, err := r.client.query(r.ctx, txtPrefix+r.service, dnsmessage.TypeTXT)
+	if err != nil && err != errNoRecords {
+		return resolver.State{}, 0, fmt.Errorf("TXT %s%s: %v", txtPrefix, r.service, err)
+	}
+	for _, rr := range txts {
+		txt, ok := rr.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		//Un registro TXT largo llega partido en varias cadenas
+		record := strings.Join(txt.TXT, "")
+		if !strings.HasPrefix(record, txtAttribute) {
+			continue
+		}
+		minTTL(rr.Header.TTL)
+		sc, err := chooseServiceConfig(strings.TrimPrefix(record, txtAttribute))
+		if err != nil {
+			state.ServiceConfig = &serviceconfig.ParseResult{Err: err}
+			log.Printf("====== [Resolver] %s: configuración no válida: %v", r.service, err)
+		} else if sc != "" {
+			state.ServiceConfig = r.cc.ParseServiceConfig(sc)
+		}
+		break
+	}
+	if state.ServiceConfig == nil {
+		state.ServiceConfig = parseServiceConfig(r.cc, r.sc)
+	}
+	return state, ttl, nil
+}
+
+//lookupHost obtiene las direcciones IPv4 e IPv6 de un nombre. Si el nombre ya es una IP se devuelve tal cual
+func (r *dnsResolver) lookupHost(host string) ([]string, uint32, error) {
+	if ip := net.ParseIP(strings.TrimSuffix(host, ".")); ip != nil {
+		return []string{ip.String()}, 0, nil
+	}
+	var ips []string
+	var ttl uint32
+	for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		rrs, err := r.client.query(r.ctx, host, t)
+		if err != nil && err != errNoRecords {
+			return nil, 0, err
+		}
+		for _, rr := range rrs {
+			var ip net.IP
+			switch b := rr.Body.(type) {
+			case *dnsmessage.AResource:
+				ip = net.IP(b.A[:])
+			case *dnsmessage.AAAAResource:
+				ip = net.IP(b.AAAA[:])
+			default:
+				continue
+			}
+			if len(ips) == 0 || rr.Header.TTL < ttl {
+				ttl = rr.Header.TTL
+			}
+			ips = append(ips, ip.String())
+		}
+	}
+	if len(ips) == 0 {
+		return nil, 0, errNoRecords
+	}
+	return ips, ttl, nil
+}
+
+//serviceConfigChoice una de las alternativas del registro TXT, con el formato que define gRPC
+type serviceConfigChoice struct {
+	ClientLanguage []string        `json:"clientLanguage"`
+	Percentage     *int            `json:"percentage"`
+	ClientHostname []string        `json:"clientHostname"`
+	ServiceConfig  json.RawMessage `json:"serviceConfig"`
+}
+
+//chooseServiceConfig elige la primera alternativa que se aplica a este cliente. Vacío si no se aplica ninguna
+func chooseServiceConfig(js string) (string, error) {
+	var choices []serviceConfigChoice
+	if err := json.Unmarshal([]byte(js), &choices); err != nil {
+		return "", err
+	}
+	hostname, _ := os.Hostname()
+	for _, c := range choices {
+		if len(c.ClientLanguage) > 0 && !containsFold(c.ClientLanguage, "go") {
+			continue
+		}
+		if c.Percentage != nil && rand.Intn(100) >= *c.Percentage {
+			continue
+		}
+		if len(c.ClientHostname) > 0 && !containsFold(c.ClientHostname, hostname) {
+			continue
+		}
+		if len(c.ServiceConfig) == 0 {
+			return "", errors.New("alternativa sin serviceConfig")
+		}
+		return string(c.ServiceConfig), nil
+	}
+	return "", nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//ResolveNow vuelve a consultar el DNS
+func (r *dnsResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	select {
+	case r.resolve <- struct{}{}:
+	default:
+	}
+}
+
+//Close cancela la consulta en curso y deja de refrescar
+func (r *dnsResolver) Close() {
+	r.cancel()
+	close(r.done)
+	r.wg.Wait()
+}
+
+//dnsClient cliente DNS mínimo. A diferencia de net.Resolver, devuelve el TTL de los registros
+type dnsClient struct {
+	server  string
+	timeout time.Duration
+}
+
+//query hace una consulta por UDP, y la repite por TCP si la respuesta llega truncada. Devuelve errNoRecords si el nombre no existe o no tiene registros del tipo pedido
+func (c *dnsClient) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Uint32())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	req, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := c.exchange(ctx, "udp", req)
+	if err != nil {
+		return nil, err
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, err
+	}
+	if h.Truncated {
+		if resp, err = c.exchange(ctx, "tcp", req); err != nil {
+			return nil, err
+		}
+		if h, err = p.Start(resp); err != nil {
+			return nil, err
+		}
+	}
+	if h.ID != id {
+		return nil, errors.New("la respuesta no corresponde con la consulta")
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, errNoRecords
+	default:
+		return nil, fmt.Errorf("el servidor respondió %v", h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return nil, err
+	}
+	var found []dnsmessage.Resource
+	for _, a := range answers {
+		if a.Header.Type == qtype {
+			found = append(found, a)
+		}
+	}
+	if len(found) == 0 {
+		return nil, errNoRecords
+	}
+	return found, nil
+}
+
+//exchange envía la consulta y espera la respuesta. Por TCP los mensajes van precedidos de su longitud
+func (c *dnsClient) exchange(ctx context.Context, network string, req []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, c.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	//La conexión se cierra si se cancela el contexto, por ejemplo al cerrar el resolver
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	if network == "udp" {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	msg := make([]byte, 2+len(req))
+	binary.BigEndian.PutUint16(msg, uint16(len(req)))
+	copy(msg[2:], req)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	rd := bufio.NewReader(conn)
+	var size [2]byte
+	if _, err := io.ReadFull(rd, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(rd, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,301 @@
+package nameservice_test
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ns "interceptors/cliente/nameservice"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+//dnsStub servidor DNS en memoria que responde por UDP con los registros configurados
+type dnsStub struct {
+	conn net.PacketConn
+	mu   sync.Mutex
+	srv  map[string][]dnsmessage.SRVResource
+	a    map[string][]dnsmessage.AResource
+	txt  map[string][]string
+	ttl  uint32
+}
+
+func newDNSStub(t *testing.T) *dnsStub {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dnsStub{conn: conn, srv: map[string][]dnsmessage.SRVResource{}, a: map[string][]dnsmessage.AResource{}, txt: map[string][]string{}, ttl: 300}
+	go s.serve()
+	return s
+}
+
+func (s *dnsStub) addr() string { return s.conn.LocalAddr().String() }
+
+func (s *dnsStub) close() { s.conn.Close() }
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp, err := s.answer(buf[:n]); err == nil {
+			s.conn.WriteTo(resp, from)
+		}
+	}
+}
+
+func (s *dnsStub) answer(req []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := q.Name.String()
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: s.ttl}
+	_, hasSRV := s.srv[name]
+	_, hasA := s.a[name]
+	_, hasTXT := s.txt[name]
+	rcode := dnsmessage.RCodeSuccess
+	if !hasSRV && !hasA && !hasTXT {
+		rcode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RCode: rcode})
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	switch q.Type {
+	case dnsmessage.TypeSRV:
+		for _, r := range s.srv[name] {
+			b.SRVResource(rh, r)
+		}
+	case dnsmessage.TypeA:
+		for _, r := range s.a[name] {
+			b.AResource(rh, r)
+		}
+	case dnsmessage.TypeTXT:
+		for _, r := range s.txt[name] {
+			//Los registros largos se parten en cadenas de como mucho 255 bytes
+			var parts []string
+			for len(r) > 255 {
+				parts, r = append(parts, r[:255]), r[255:]
+			}
+			b.TXTResource(rh, dnsmessage.TXTResource{TXT: append(parts, r)})
+		}
+	}
+	return b.Finish()
+}
+
+func (s *dnsStub) setSRV(service string, backends ...dnsmessage.SRVResource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.srv["_grpc._tcp."+service+"."] = backends
+}
+
+func (s *dnsStub) setA(host string, ip [4]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.a[host+"."] = []dnsmessage.AResource{{A: ip}}
+}
+
+func (s *dnsStub) setTXT(service, record string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txt["_grpc_config."+service+"."] = []string{record}
+}
+
+func (s *dnsStub) setTTL(ttl uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+}
+
+func srv(target string, port, weight uint16) dnsmessage.SRVResource {
+	return dnsmessage.SRVResource{Target: dnsmessage.MustNewName(target + "."), Port: port, Weight: weight}
+}
+
+//testClientConn ClientConn que guarda lo que le envía el resolver
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+	errors chan error
+}
+
+func newTestClientConn() *testClientConn {
+	return &testClientConn{states: make(chan resolver.State, 10), errors: make(chan error, 10)}
+}
+
+func (cc *testClientConn) UpdateState(s resolver.State) { cc.states <- s }
+
+func (cc *testClientConn) ReportError(err error) { cc.errors <- err }
+
+func (cc *testClientConn) ParseServiceConfig(js string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{Config: &testServiceConfig{js: js}}
+}
+
+//testServiceConfig guarda el JSON de la configuración tal y como lo envía el resolver
+type testServiceConfig struct {
+	serviceconfig.Config
+	js string
+}
+
+func serviceConfigJSON(s resolver.State) string {
+	if s.ServiceConfig == nil {
+		return ""
+	}
+	if c, ok := s.ServiceConfig.Config.(*testServiceConfig); ok {
+		return c.js
+	}
+	return ""
+}
+
+func (cc *testClientConn) waitState(t *testing.T) resolver.State {
+	t.Helper()
+	select {
+	case s := <-cc.states:
+		return s
+	case err := <-cc.errors:
+		t.Fatalf("error inesperado: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("el resolver no ha enviado ningún estado")
+	}
+	return resolver.State{}
+}
+
+func build(t *testing.T, stub *dnsStub, cc *testClientConn, minRefresh time.Duration) resolver.Resolver {
+	t.Helper()
+	b := &ns.DNSResolverBuilder{Server: stub.addr(), MinRefresh: minRefresh, Timeout: time.Second}
+	r, err := b.Build(resolver.Target{Scheme: ns.DNSScheme, Endpoint: "orders.example.com"}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func addrs(s resolver.State) map[string]uint32 {
+	m := map[string]uint32{}
+	for _, a := range s.Addresses {
+		m[a.Addr] = ns.Weight(a)
+	}
+	return m
+}
+
+func TestDNSResolverSRVAndServiceConfig(t *testing.T) {
+	stub := newDNSStub(t)
+	defer stub.close()
+	stub.setSRV("orders.example.com", srv("a.example.com", 50051, 3), srv("b.example.com", 50052, 1))
+	stub.setA("a.example.com", [4]byte{127, 0, 0, 1})
+	stub.setA("b.example.com", [4]byte{127, 0, 0, 2})
+	sc := `{"loadBalancingPolicy":"round_robin"}`
+	stub.setTXT("orders.example.com", `grpc_config=[{"clientLanguage":["java"],"serviceConfig":{}},{"clientLanguage":["go"],"serviceConfig":`+sc+`}]`)
+
+	cc := newTestClientConn()
+	r := build(t, stub, cc, time.Minute)
+	defer r.Close()
+
+	s := cc.waitState(t)
+	got := addrs(s)
+	if len(got) != 2 || got["127.0.0.1:50051"] != 3 || got["127.0.0.2:50052"] != 1 {
+		t.Fatalf("direcciones: %v", got)
+	}
+	if got := serviceConfigJSON(s); got != sc {
+		t.Fatalf("configuración del servicio: %q", got)
+	}
+}
+
+func TestDNSResolverLongTXT(t *testing.T) {
+	stub := newDNSStub(t)
+	defer stub.close()
+	stub.setSRV("orders.example.com", srv("127.0.0.1", 50051, 0))
+	sc := `{"methodConfig":[{"name":[{"service":"ecommerce.OrderManagement"}],"timeout":"` + strings.Repeat("1", 300) + `s"}]}`
+	stub.setTXT("orders.example.com", `grpc_config=[{"serviceConfig":`+sc+`}]`)
+
+	cc := newTestClientConn()
+	r := build(t, stub, cc, time.Minute)
+	defer r.Close()
+
+	s := cc.waitState(t)
+	if got := addrs(s); len(got) != 1 || got["127.0.0.1:50051"] != 1 {
+		t.Fatalf("direcciones: %v", got)
+	}
+	if got := serviceConfigJSON(s); got != sc {
+		t.Fatalf("configuración del servicio: %q", got)
+	}
+}
+
+func TestDNSResolverResolveNow(t *testing.T) {
+	stub := newDNSStub(t)
+	defer stub.close()
+	stub.setSRV("orders.example.com", srv("a.example.com", 50051, 1))
+	stub.setA("a.example.com", [4]byte{127, 0, 0, 1})
+
+	cc := newTestClientConn()
+	r := build(t, stub, cc, 10*time.Millisecond)
+	defer r.Close()
+	cc.waitState(t)
+
+	stub.setSRV("orders.example.com", srv("a.example.com", 50051, 1), srv("a.example.com", 50052, 5))
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	got := addrs(cc.waitState(t))
+	if len(got) != 2 || got["127.0.0.1:50052"] != 5 {
+		t.Fatalf("direcciones: %v", got)
+	}
+}
+
+func TestDNSResolverRefreshesOnTTL(t *testing.T) {
+	stub := newDNSStub(t)
+	defer stub.close()
+	stub.setTTL(1)
+	stub.setSRV("orders.example.com", srv("a.example.com", 50051, 1))
+	stub.setA("a.example.com", [4]byte{127, 0, 0, 1})
+
+	cc := newTestClientConn()
+	r := build(t, stub, cc, 10*time.Millisecond)
+	defer r.Close()
+	cc.waitState(t)
+
+	//Sin llamar a ResolveNow, el resolver vuelve a consultar cuando caduca el TTL de un segundo
+	start := time.Now()
+	stub.setA("a.example.com", [4]byte{127, 0, 0, 9})
+	got := addrs(cc.waitState(t))
+	if _, ok := got["127.0.0.9:50051"]; !ok {
+		t.Fatalf("direcciones: %v", got)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("el resolver ha tardado %v en refrescar", d)
+	}
+}
+
+func TestDNSResolverReportsErrors(t *testing.T) {
+	stub := newDNSStub(t)
+	defer stub.close()
+
+	cc := newTestClientConn()
+	r := build(t, stub, cc, time.Minute)
+	defer r.Close()
+
+	select {
+	case err := <-cc.errors:
+		if !strings.Contains(err.Error(), "_grpc._tcp.orders.example.com") {
+			t.Fatalf("error: %v", err)
+		}
+	case s := <-cc.states:
+		t.Fatalf("estado inesperado: %+v", s)
+	case <-time.After(5 * time.Second):
+		t.Fatal("el resolver no ha notificado el error")
+	}
+}
@@ -0,0 +1,185 @@
+package nameservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+	"gopkg.in/yaml.v2"
+)
+
+//FileScheme esquema del resolver de fichero. El endpoint es la ruta del fichero: file:///endpoints.json es relativo al directorio de trabajo y file:////etc/endpoints.json es absoluto
+const FileScheme = "file"
+
+//Endpoint una dirección del fichero de endpoints
+type Endpoint struct {
+	Addr   string `json:"addr" yaml:"addr"`
+	Weight uint32 `json:"weight" yaml:"weight"`
+	Zone   string `json:"zone" yaml:"zone"`
+	//Las direcciones de prioridad 0 son las preferidas; el resto solo se usan si fallan las de prioridad menor
+	Priority uint32 `json:"priority" yaml:"priority"`
+}
+
+//Endpoints contenido del fichero de endpoints
+type Endpoints struct {
+	Endpoints []Endpoint `json:"endpoints" yaml:"endpoints"`
+	//Configuración del servicio: política de balanceo, reintentos, timeouts... Tiene el mismo formato que en grpc.WithDefaultServiceConfig
+	ServiceConfig interface{} `json:"serviceConfig" yaml:"serviceConfig"`
+}
+
+//ParseEndpoints lee el fichero de endpoints. Si la extensión es .yaml o .yml se lee como YAML, y si no como JSON
+func ParseEndpoints(path string, b []byte) ([]resolver.Address, error) {
+	addrs, _, err := parseEndpoints(path, b)
+	return addrs, err
+}
+
+//parseEndpoints lee el fichero de endpoints y devuelve también su configuración del servicio, en JSON
+func parseEndpoints(path string, b []byte) ([]resolver.Address, string, error) {
+	var e Endpoints
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, &e)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&e)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", path, err)
+	}
+	sc, err := serviceConfigJSON(e.ServiceConfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", path, err)
+	}
+	addrs := make([]resolver.Address, 0, len(e.Endpoints))
+	for i, ep := range e.Endpoints {
+		if ep.Addr == "" {
+			return nil, "", fmt.Errorf("%s: el endpoint %d no tiene dirección", path, i)
+		}
+		addrs = append(addrs, WithLocality(resolver.Address{Addr: ep.Addr}, ep.Weight, ep.Zone, ep.Priority))
+	}
+	return addrs, sc, nil
+}
+
+//FileResolverBuilder constructor del resolver que lee los endpoints de un fichero y lo vigila. La vigilancia es por sondeo: el
+//fichero se vuelve a leer cada Interval y se compara con lo último que se envió, así que un cambio tarda hasta Interval en verse
+type FileResolverBuilder struct {
+	//Cada cuánto se lee el fichero para ver si ha cambiado. Por defecto un segundo
+	Interval time.Duration
+	//Configuración del servicio, en JSON, si el fichero de endpoints no tiene la suya
+	ServiceConfig string
+}
+
+//Build crea el resolver. Si el fichero no existe o no es válido se informa con ReportError y se sigue vigilando
+func (b *FileResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	if target.Endpoint == "" {
+		return nil, fmt.Errorf("falta la ruta del fichero de endpoints: %s:///<fichero>", FileScheme)
+	}
+	interval := b.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	r := &fileResolver{
+		path:     target.Endpoint,
+		cc:       cc,
+		interval: interval,
+		sc:       b.ServiceConfig,
+		resolve:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	r.load(true)
+	r.wg.Add(1)
+	go r.watch()
+	return r, nil
+}
+
+//Scheme esquema del resolver, file
+func (*FileResolverBuilder) Scheme() string { return FileScheme }
+
+type fileResolver struct {
+	path     string
+	cc       resolver.ClientConn
+	interval time.Duration
+	sc       string
+	//Contenido del fichero que se envió en el último UpdateState
+	last []byte
+	//Último error enviado con ReportError, vacío si el último estado enviado fue el de last
+	err     string
+	resolve chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+//load lee el fichero y, si ha cambiado o force es true, envía las direcciones al ClientConn. Si el fichero no se puede leer o
+//no es válido se informa con ReportError, pero solo cuando cambia el error o force es true, y no en cada lectura
+func (r *fileResolver) load(force bool) {
+	b, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		r.fail(err, force)
+		return
+	}
+	//Tras un error se envía aunque el contenido sea el de antes, porque el ClientConn se quedó con el error
+	if !force && r.err == "" && bytes.Equal(b, r.last) {
+		return
+	}
+	addrs, sc, err := parseEndpoints(r.path, b)
+	if err != nil {
+		r.fail(err, force)
+		return
+	}
+	if sc == "" {
+		sc = r.sc
+	}
+	log.Printf("====== [Resolver] %s: %d endpoints", r.path, len(addrs))
+	r.cc.UpdateState(resolver.State{Addresses: addrs, ServiceConfig: parseServiceConfig(r.cc, sc)})
+	r.last, r.err = b, ""
+}
+
+//fail informa del error si es distinto del último o force es true
+func (r *fileResolver) fail(err error, force bool) {
+	if !force && err.Error() == r.err {
+		return
+	}
+	r.err = err.Error()
+	log.Printf("====== [Resolver] %v", err)
+	r.cc.ReportError(err)
+}
+
+//watch lee el fichero cada interval, y también cuando lo pide ResolveNow
+func (r *fileResolver) watch() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.load(false)
+		case <-r.resolve:
+			r.load(true)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+//ResolveNow vuelve a leer el fichero
+func (r *fileResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	select {
+	case r.resolve <- struct{}{}:
+	default:
+	}
+}
+
+//Close deja de vigilar el fichero
+func (r *fileResolver) Close() {
+	close(r.done)
+	r.wg.Wait()
+}
@@ -0,0 +1,165 @@
+package nameservice_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ns "interceptors/cliente/nameservice"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const fileInterval = 20 * time.Millisecond
+
+//writeFile sustituye el fichero de una vez, para que el resolver nunca lea un fichero a medio escribir
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func buildFile(t *testing.T, path string, cc *testClientConn) resolver.Resolver {
+	t.Helper()
+	b := &ns.FileResolverBuilder{Interval: fileInterval}
+	r, err := b.Build(resolver.Target{Scheme: ns.FileScheme, Endpoint: path}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func (cc *testClientConn) waitError(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-cc.errors:
+		return err
+	case s := <-cc.states:
+		t.Fatalf("estado inesperado: %v", s.Addresses)
+	case <-time.After(5 * time.Second):
+		t.Fatal("el resolver no ha informado de ningún error")
+	}
+	return nil
+}
+
+//quiet comprueba que el resolver no envía nada durante varias lecturas del fichero
+func (cc *testClientConn) quiet(t *testing.T) {
+	t.Helper()
+	select {
+	case err := <-cc.errors:
+		t.Fatalf("error repetido: %v", err)
+	case s := <-cc.states:
+		t.Fatalf("estado repetido: %v", s.Addresses)
+	case <-time.After(5 * fileInterval):
+	}
+}
+
+func TestFileResolverUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	writeFile(t, path, `{"endpoints": [{"addr": "localhost:50051", "weight": 2}]}`)
+	cc := newTestClientConn()
+	r := buildFile(t, path, cc)
+	defer r.Close()
+
+	if got := addrs(cc.waitState(t)); len(got) != 1 || got["localhost:50051"] != 2 {
+		t.Fatalf("direcciones %v", got)
+	}
+	//Mientras el fichero no cambia no se envía nada
+	cc.quiet(t)
+
+	writeFile(t, path, `{"endpoints": [{"addr": "localhost:50051"}, {"addr": "localhost:50052"}]}`)
+	if got := addrs(cc.waitState(t)); len(got) != 2 {
+		t.Fatalf("direcciones tras editar el fichero %v", got)
+	}
+	cc.quiet(t)
+}
+
+func TestFileResolverInvalidContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	valid := `{"endpoints": [{"addr": "localhost:50051"}]}`
+	writeFile(t, path, valid)
+	cc := newTestClientConn()
+	r := buildFile(t, path, cc)
+	defer r.Close()
+	cc.waitState(t)
+
+	//Un fichero inválido se notifica una vez, no en cada lectura
+	writeFile(t, path, `{"endpoints": [{"weight": 1}]}`)
+	cc.waitError(t)
+	cc.quiet(t)
+
+	//Con el contenido de antes del error se vuelven a enviar las direcciones
+	writeFile(t, path, valid)
+	if got := addrs(cc.waitState(t)); len(got) != 1 {
+		t.Fatalf("direcciones tras arreglar el fichero %v", got)
+	}
+}
+
+func TestFileResolverMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	cc := newTestClientConn()
+	r := buildFile(t, path, cc)
+	defer r.Close()
+
+	//El fichero que no existe se notifica al crear el resolver, y no otra vez en cada lectura
+	cc.waitError(t)
+	cc.quiet(t)
+
+	writeFile(t, path, `{"endpoints": [{"addr": "localhost:50051"}]}`)
+	cc.waitState(t)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	cc.waitError(t)
+	cc.quiet(t)
+}
+
+func TestFileResolverResolveNow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	writeFile(t, path, "endpoints:\n  - addr: localhost:50051\n")
+	cc := newTestClientConn()
+	b := &ns.FileResolverBuilder{Interval: time.Hour}
+	r, err := b.Build(resolver.Target{Scheme: ns.FileScheme, Endpoint: path}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cc.waitState(t)
+
+	//Con un Interval de una hora el cambio solo se ve porque ResolveNow fuerza la lectura
+	writeFile(t, path, "endpoints:\n  - addr: localhost:50051\n  - addr: localhost:50052\n")
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	if got := addrs(cc.waitState(t)); len(got) != 2 {
+		t.Fatalf("direcciones tras ResolveNow %v", got)
+	}
+
+	//ResolveNow envía el estado aunque no haya cambiado, y también vuelve a notificar el error
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	cc.waitState(t)
+	os.Remove(path)
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	cc.waitError(t)
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	cc.waitError(t)
+}
+
+func TestFileResolverClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	writeFile(t, path, `{"endpoints": [{"addr": "localhost:50051"}]}`)
+	cc := newTestClientConn()
+	r := buildFile(t, path, cc)
+	cc.waitState(t)
+
+	//Close espera a que termine la go-rutina que vigila el fichero: después no se envía nada
+	r.Close()
+	writeFile(t, path, `{"endpoints": [{"addr": "localhost:50052"}]}`)
+	cc.quiet(t)
+}
@@ -0,0 +1,128 @@
+package nameservice
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"registry/servidor/registry"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+//RegistryScheme esquema del resolver del registro de servicios. El endpoint es el nombre del servicio: registry:///orders usa el registro
+//del constructor y registry://localhost:50100/orders el indicado
+const RegistryScheme = "registry"
+
+//RegistryResolverBuilder constructor del resolver que vigila las instancias de un servicio en el registro de servicios
+type RegistryResolverBuilder struct {
+	//Dirección del registro, si el target no la indica
+	Addr string
+	//Espera máxima entre reintentos cuando se pierde la conexión con el registro. Por defecto 30 segundos
+	MaxBackoff time.Duration
+	//Configuración del servicio, en JSON. Vacío usa la de por defecto del ClientConn
+	ServiceConfig string
+}
+
+//Build crea el resolver. Las instancias llegan por un stream Watch, que se vuelve a abrir si se corta
+func (b *RegistryResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	if target.Endpoint == "" {
+		return nil, fmt.Errorf("falta el servicio: %s:///<servicio>", RegistryScheme)
+	}
+	addr := target.Authority
+	if addr == "" {
+		addr = b.Addr
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("%s: falta la dirección del registro", RegistryScheme)
+	}
+	maxBackoff := b.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &registryResolver{
+		service:    target.Endpoint,
+		cc:         cc,
+		conn:       conn,
+		client:     registry.NewRegistryClient(conn),
+		maxBackoff: maxBackoff,
+		sc:         b.ServiceConfig,
+		cancel:     cancel,
+	}
+	r.wg.Add(1)
+	go r.watch(ctx)
+	return r, nil
+}
+
+//Scheme esquema del resolver, registry
+func (*RegistryResolverBuilder) Scheme() string { return RegistryScheme }
+
+type registryResolver struct {
+	service    string
+	cc         resolver.ClientConn
+	conn       *grpc.ClientConn
+	client     registry.RegistryClient
+	maxBackoff time.Duration
+	sc         string
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+//watch mantiene abierto el stream Watch y envía al ClientConn las instancias cada vez que cambian. Si se pierde la conexión
+//con el registro se siguen usando las últimas instancias conocidas, porque los servidores pueden seguir funcionando
+func (r *registryResolver) watch(ctx context.Context) {
+	defer r.wg.Done()
+	backoff := time.Second
+	for {
+		stream, err := r.client.Watch(ctx, &registry.WatchRequest{Service: r.service}, grpc.WaitForReady(true))
+		for err == nil {
+			var instances *registry.Instances
+			if instances, err = stream.Recv(); err == nil {
+				backoff = time.Second
+				r.update(instances)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("====== [Resolver] %s: se ha perdido el registro (%v), se reintenta en %v", r.service, status.Code(err), backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+//update envía las instancias al ClientConn. Si no queda ninguna también se envía la lista vacía, para que el balanceador
+//cierre las conexiones con las instancias que se han dado de baja
+func (r *registryResolver) update(instances *registry.Instances) {
+	addrs := make([]resolver.Address, 0, len(instances.Instances))
+	for _, in := range instances.Instances {
+		addrs = append(addrs, WithLocality(resolver.Address{Addr: in.Addr}, in.Weight, in.Zone, in.Priority))
+	}
+	log.Printf("====== [Resolver] %s: %d instancias", r.service, len(addrs))
+	r.cc.UpdateState(resolver.State{Addresses: addrs, ServiceConfig: parseServiceConfig(r.cc, r.sc)})
+}
+
+//ResolveNow no hace nada: el registro envía los cambios en cuanto se producen
+func (r *registryResolver) ResolveNow(o resolver.ResolveNowOptions) {}
+
+//Close cierra el stream y la conexión con el registro
+func (r *registryResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+	r.conn.Close()
+}
@@ -12,12 +12,16 @@ const (
 var addrs = []string{"localhost:50051", "127.0.0.1:50051"}
 
 // ExampleResolverBuilder Constructor de un servicio de resolución de nombres
-type ExampleResolverBuilder struct{}
+type ExampleResolverBuilder struct {
+	//Configuración del servicio, en JSON. Vacío usa la de por defecto del ClientConn
+	ServiceConfig string
+}
 
-func (*ExampleResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+func (b *ExampleResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
 	r := &exampleResolver{
 		target: target,
 		cc:     cc,
+		sc:     b.ServiceConfig,
 		addrsStore: map[string][]string{
 			ExampleServiceName: addrs, // "lb.example.grpc.io": "localhost:50051", "localhost:50052"
 		},
@@ -30,6 +34,7 @@ func (*ExampleResolverBuilder) Scheme() string { return ExampleScheme } // "exam
 type exampleResolver struct {
 	target     resolver.Target
 	cc         resolver.ClientConn
+	sc         string
 	addrsStore map[string][]string
 }
 
@@ -39,7 +44,7 @@ func (r *exampleResolver) start() {
 	for i, s := range addrStrs {
 		addrs[i] = resolver.Address{Addr: s}
 	}
-	r.cc.UpdateState(resolver.State{Addresses: addrs})
+	r.cc.UpdateState(resolver.State{Addresses: addrs, ServiceConfig: parseServiceConfig(r.cc, r.sc)})
 }
 func (*exampleResolver) ResolveNow(o resolver.ResolveNowOptions) {}
 func (*exampleResolver) Close()                                  {}
@@ -0,0 +1,3 @@
+//Package retrytest pruebas de la configuración del servicio que entregan los resolvers de nameservice. Necesitan
+//GRPC_GO_RETRY=on, que grpc lee al arrancar el proceso, así que van aparte para no relanzar el resto de pruebas
+package retrytest
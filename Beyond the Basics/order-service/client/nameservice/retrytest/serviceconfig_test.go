@@ -0,0 +1,220 @@
+package retrytest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pb "interceptors/cliente/ecommerce"
+	ns "interceptors/cliente/nameservice"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//TestMain vuelve a lanzar las pruebas con GRPC_GO_RETRY=on. En esta versión de gRPC los reintentos solo se activan con esa variable,
+//y grpc la lee al inicializar sus paquetes, antes que cualquier init de la prueba, así que os.Setenv llega tarde. Por eso estas
+//pruebas tienen su propio paquete: solo se vuelven a lanzar ellas
+func TestMain(m *testing.M) {
+	if os.Getenv("GRPC_GO_RETRY") != "on" {
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.Env = append(os.Environ(), "GRPC_GO_RETRY=on")
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			if exit, ok := err.(*exec.ExitError); ok {
+				os.Exit(exit.ExitCode())
+			}
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+//flakyServer servidor de órdenes que falla con Unavailable las primeras llamadas de cada método
+type flakyServer struct {
+	pb.UnimplementedOrderManagementServer
+	failures int
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (s *flakyServer) call(method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[method]++
+	if s.calls[method] <= s.failures {
+		return status.Error(codes.Unavailable, "no disponible")
+	}
+	return nil
+}
+
+func (s *flakyServer) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+func (s *flakyServer) GetOrder(ctx context.Context, id *wrappers.StringValue) (*pb.Order, error) {
+	if err := s.call("getOrder"); err != nil {
+		return nil, err
+	}
+	return &pb.Order{Id: id.Value}, nil
+}
+
+func (s *flakyServer) AddOrder(ctx context.Context, o *pb.Order) (*wrappers.StringValue, error) {
+	if err := s.call("addOrder"); err != nil {
+		return nil, err
+	}
+	return &wrappers.StringValue{Value: o.Id}, nil
+}
+
+//serviceConfig reintenta getOrder hasta 4 veces si falla con UNAVAILABLE. addOrder no es idempotente, así que no se reintenta
+const serviceConfig = `{
+	"loadBalancingConfig": [{"round_robin": {}}],
+	"methodConfig": [{
+		"name": [{"service": "ecommerce.OrderManagement", "method": "getOrder"}],
+		"waitForReady": true,
+		"timeout": "5s",
+		"retryPolicy": {
+			"maxAttempts": 4,
+			"initialBackoff": "0.01s",
+			"maxBackoff": "0.1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}, {
+		"name": [{"service": "ecommerce.OrderManagement", "method": "addOrder"}],
+		"timeout": "5s",
+		"maxRequestMessageBytes": 1024
+	}]
+}`
+
+//startFlakyServer arranca el servidor y escribe un fichero de endpoints con su dirección y la configuración del servicio
+func startFlakyServer(t *testing.T, failures int, sc string) (*flakyServer, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &flakyServer{failures: failures, calls: map[string]int{}}
+	s := grpc.NewServer()
+	pb.RegisterOrderManagementServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	endpoints := fmt.Sprintf(`{"endpoints": [{"addr": %q}]`, lis.Addr().String())
+	if sc != "" {
+		endpoints += `, "serviceConfig": ` + sc
+	}
+	if err := ioutil.WriteFile(path, []byte(endpoints+"}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return srv, path
+}
+
+//dialFile conecta con el resolver de fichero
+func dialFile(t *testing.T, b *ns.FileResolverBuilder, path string) pb.OrderManagementClient {
+	t.Helper()
+	conn, err := grpc.Dial(ns.FileScheme+":///"+path, grpc.WithInsecure(), grpc.WithResolvers(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewOrderManagementClient(conn)
+}
+
+func TestServiceConfigRetriesGetOrder(t *testing.T) {
+	srv, path := startFlakyServer(t, 2, serviceConfig)
+	client := dialFile(t, &ns.FileResolverBuilder{}, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "106"})
+	if err != nil {
+		t.Fatalf("getOrder: %v", err)
+	}
+	if o.Id != "106" {
+		t.Fatalf("getOrder devuelve %q", o.Id)
+	}
+	if n := srv.count("getOrder"); n != 3 {
+		t.Fatalf("getOrder llega %d veces al servidor, esperado 3", n)
+	}
+}
+
+func TestServiceConfigDoesNotRetryAddOrder(t *testing.T) {
+	srv, path := startFlakyServer(t, 2, serviceConfig)
+	client := dialFile(t, &ns.FileResolverBuilder{}, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.AddOrder(ctx, &pb.Order{Id: "107"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("addOrder: %v, esperado Unavailable", err)
+	}
+	if n := srv.count("addOrder"); n != 1 {
+		t.Fatalf("addOrder llega %d veces al servidor, esperado 1", n)
+	}
+}
+
+func TestServiceConfigMaxRequestMessageBytes(t *testing.T) {
+	srv, path := startFlakyServer(t, 0, serviceConfig)
+	client := dialFile(t, &ns.FileResolverBuilder{}, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.AddOrder(ctx, &pb.Order{Id: "107", Description: string(make([]byte, 2048))})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("addOrder: %v, esperado ResourceExhausted", err)
+	}
+	if n := srv.count("addOrder"); n != 0 {
+		t.Fatalf("addOrder llega %d veces al servidor, esperado 0", n)
+	}
+}
+
+func TestServiceConfigFromBuilder(t *testing.T) {
+	//Si el fichero de endpoints no tiene configuración se usa la del constructor, leída de un fichero YAML
+	dir := t.TempDir()
+	yml := filepath.Join(dir, "service_config.yaml")
+	if err := ioutil.WriteFile(yml, []byte(`
+methodConfig:
+- name:
+  - service: ecommerce.OrderManagement
+    method: getOrder
+  retryPolicy:
+    maxAttempts: 3
+    initialBackoff: 0.01s
+    maxBackoff: 0.1s
+    backoffMultiplier: 2
+    retryableStatusCodes: [UNAVAILABLE]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := ns.LoadServiceConfig(yml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, path := startFlakyServer(t, 2, "")
+	client := dialFile(t, &ns.FileResolverBuilder{ServiceConfig: sc}, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "106"}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("getOrder: %v", err)
+	}
+	if n := srv.count("getOrder"); n != 3 {
+		t.Fatalf("getOrder llega %d veces al servidor, esperado 3", n)
+	}
+}
@@ -0,0 +1,97 @@
+package nameservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"gopkg.in/yaml.v2"
+)
+
+//LoadServiceConfig lee la configuración del servicio de un fichero y la devuelve en JSON, que es lo que esperan los resolvers.
+//Si la extensión es .yaml o .yml se lee como YAML, y si no como JSON. gRPC la valida cuando el resolver la envía
+func LoadServiceConfig(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var sc interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &sc)
+	default:
+		err = json.Unmarshal(b, &sc)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	js, err := serviceConfigJSON(sc)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	return js, nil
+}
+
+//serviceConfigJSON convierte a JSON la configuración leída de un fichero. Vacío si no hay configuración
+func serviceConfigJSON(sc interface{}) (string, error) {
+	if sc == nil {
+		return "", nil
+	}
+	sc, err := jsonValue(sc)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := sc.(map[string]interface{}); !ok {
+		return "", fmt.Errorf("la configuración del servicio tiene que ser un objeto")
+	}
+	b, err := json.Marshal(sc)
+	return string(b), err
+}
+
+//jsonValue cambia los mapas de YAML, que tienen claves de cualquier tipo, por mapas con claves de texto
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("clave no válida: %v", k)
+			}
+			var err error
+			if m[s], err = jsonValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case map[string]interface{}:
+		for k, e := range v {
+			var err error
+			if v[k], err = jsonValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case []interface{}:
+		for i, e := range v {
+			var err error
+			if v[i], err = jsonValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+//parseServiceConfig pasa la configuración por el parser del ClientConn. nil si no hay configuración, y así el ClientConn usa la de por defecto
+func parseServiceConfig(cc resolver.ClientConn, js string) *serviceconfig.ParseResult {
+	if js == "" {
+		return nil
+	}
+	return cc.ParseServiceConfig(js)
+}
@@ -0,0 +1,26 @@
+package nameservice_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	ns "interceptors/cliente/nameservice"
+)
+
+func TestLoadServiceConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"roto.json":  `{"methodConfig": [`,
+		"lista.json": `[]`,
+		"roto.yaml":  "methodConfig: [",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ns.LoadServiceConfig(path); err == nil {
+			t.Errorf("%s: se esperaba un error", name)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package leases
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pb "registry/servidor/registry"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Instancias vivas de cada servicio, publicadas en /debug/vars
+var instancesMetrics = expvar.NewMap("registry_instances")
+
+//lease registro de una instancia. Vence si no se renueva antes de expires
+type lease struct {
+	id       string
+	instance *pb.Instance
+	ttl      time.Duration
+	expires  time.Time
+}
+
+//Registry implementa el servicio de registro. Las instancias se guardan en memoria, así que al reiniciar el registro
+//los servidores vuelven a registrarse en su siguiente Heartbeat
+type Registry struct {
+	pb.UnimplementedRegistryServer
+	//TTL de los leases que no lo indican
+	DefaultTTL time.Duration
+	//TTL máximo. Un TTL muy largo deja en el registro durante mucho tiempo las instancias que se caen sin darse de baja
+	MaxTTL time.Duration
+
+	mu     sync.Mutex
+	leases map[string]*lease
+	//Canales de los Watch abiertos de cada servicio. Se avisa en ellos cuando cambian las instancias
+	watchers map[string]map[chan struct{}]bool
+	done     chan struct{}
+}
+
+//New crea el registro y arranca la go-rutina que da de baja los leases vencidos
+func New(defaultTTL, maxTTL time.Duration) *Registry {
+	r := &Registry{
+		DefaultTTL: defaultTTL,
+		MaxTTL:     maxTTL,
+		leases:     make(map[string]*lease),
+		watchers:   make(map[string]map[chan struct{}]bool),
+		done:       make(chan struct{}),
+	}
+	go r.expire()
+	return r
+}
+
+//Close para la go-rutina de vencimientos
+func (r *Registry) Close() {
+	close(r.done)
+}
+
+//Register da de alta la instancia. Si ya estaba registrada, por ejemplo porque el servidor se ha reiniciado, se sustituye su lease
+func (r *Registry) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.Lease, error) {
+	in := req.GetInstance()
+	if in.GetService() == "" || in.GetAddr() == "" {
+		return nil, status.Error(codes.InvalidArgument, "la instancia necesita servicio y dirección")
+	}
+	ttl := time.Duration(req.TtlSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = r.DefaultTTL
+	}
+	if ttl > r.MaxTTL {
+		ttl = r.MaxTTL
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "no se puede generar el lease: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for old, l := range r.leases {
+		if l.instance.Service == in.Service && l.instance.Addr == in.Addr {
+			delete(r.leases, old)
+		}
+	}
+	r.leases[id] = &lease{id: id, instance: in, ttl: ttl, expires: time.Now().Add(ttl)}
+	log.Printf("====== [Registry] %s: alta de %s, lease %s de %v", in.Service, in.Addr, id, ttl)
+	r.changed(in.Service)
+	return &pb.Lease{Id: id, TtlSeconds: int64(ttl / time.Second)}, nil
+}
+
+//Deregister da de baja la instancia del lease
+func (r *Registry) Deregister(ctx context.Context, req *pb.Lease) (*pb.DeregisterResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.leases[req.Id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "lease %s no existe", req.Id)
+	}
+	delete(r.leases, req.Id)
+	log.Printf("====== [Registry] %s: baja de %s", l.instance.Service, l.instance.Addr)
+	r.changed(l.instance.Service)
+	return &pb.DeregisterResponse{}, nil
+}
+
+//Heartbeat renueva el lease. Si ya ha vencido devuelve NotFound, y el servidor tiene que volver a registrarse
+func (r *Registry) Heartbeat(ctx context.Context, req *pb.Lease) (*pb.Lease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.leases[req.Id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "lease %s no existe", req.Id)
+	}
+	l.expires = time.Now().Add(l.ttl)
+	return &pb.Lease{Id: l.id, TtlSeconds: int64(l.ttl / time.Second)}, nil
+}
+
+//Watch envía las instancias vivas del servicio al abrir el stream y cada vez que cambian
+func (r *Registry) Watch(req *pb.WatchRequest, stream pb.Registry_WatchServer) error {
+	if req.Service == "" {
+		return status.Error(codes.InvalidArgument, "falta el servicio")
+	}
+	ch := make(chan struct{}, 1)
+	ch <- struct{}{}
+	r.mu.Lock()
+	if r.watchers[req.Service] == nil {
+		r.watchers[req.Service] = make(map[chan struct{}]bool)
+	}
+	r.watchers[req.Service][ch] = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.watchers[req.Service], ch)
+		r.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ch:
+			if err := stream.Send(r.instances(req.Service)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+//instances instancias vivas del servicio, ordenadas por dirección
+func (r *Registry) instances(service string) *pb.Instances {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := &pb.Instances{}
+	for _, l := range r.leases {
+		if l.instance.Service == service {
+			res.Instances = append(res.Instances, l.instance)
+		}
+	}
+	sort.Slice(res.Instances, func(i, j int) bool { return res.Instances[i].Addr < res.Instances[j].Addr })
+	return res
+}
+
+//changed avisa a los Watch del servicio. Se llama con el mutex cogido
+func (r *Registry) changed(service string) {
+	n := 0
+	for _, l := range r.leases {
+		if l.instance.Service == service {
+			n++
+		}
+	}
+	v := new(expvar.Int)
+	v.Set(int64(n))
+	instancesMetrics.Set(service, v)
+	for ch := range r.watchers[service] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//expire da de baja cada segundo los leases vencidos
+func (r *Registry) expire() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.done:
+			return
+		}
+		r.expireAt(time.Now())
+	}
+}
+
+//expireAt da de baja los leases vencidos en now
+func (r *Registry) expireAt(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, l := range r.leases {
+		if now.After(l.expires) {
+			delete(r.leases, id)
+			log.Printf("====== [Registry] %s: %s no ha renovado su lease, se da de baja", l.instance.Service, l.instance.Addr)
+			r.changed(l.instance.Service)
+		}
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
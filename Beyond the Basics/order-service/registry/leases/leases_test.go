@@ -0,0 +1,172 @@
+package leases
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "registry/servidor/registry"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func register(t *testing.T, r *Registry, service, addr string, ttlSeconds int64) *pb.Lease {
+	t.Helper()
+	l, err := r.Register(context.Background(), &pb.RegisterRequest{Instance: &pb.Instance{Service: service, Addr: addr}, TtlSeconds: ttlSeconds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func addrs(in *pb.Instances) []string {
+	var res []string
+	for _, i := range in.Instances {
+		res = append(res, i.Addr)
+	}
+	return res
+}
+
+func TestLeaseTTL(t *testing.T) {
+	r := New(10*time.Second, time.Minute)
+	defer r.Close()
+
+	if l := register(t, r, "orders", "a:1", 0); l.TtlSeconds != 10 {
+		t.Errorf("sin TTL: lease de %ds, quería el TTL por defecto, 10s", l.TtlSeconds)
+	}
+	if l := register(t, r, "orders", "b:1", 3600); l.TtlSeconds != 60 {
+		t.Errorf("TTL de una hora: lease de %ds, quería el máximo, 60s", l.TtlSeconds)
+	}
+	if _, err := r.Register(context.Background(), &pb.RegisterRequest{Instance: &pb.Instance{Service: "orders"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("instancia sin dirección: %v, quería InvalidArgument", err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	r := New(10*time.Second, time.Minute)
+	defer r.Close()
+	ctx := context.Background()
+
+	l := register(t, r, "orders", "a:1", 10)
+	register(t, r, "orders", "b:1", 30)
+
+	//Antes de vencer, Heartbeat renueva el lease
+	r.expireAt(time.Now().Add(5 * time.Second))
+	if _, err := r.Heartbeat(ctx, l); err != nil {
+		t.Fatalf("Heartbeat antes de vencer: %v", err)
+	}
+	r.expireAt(time.Now().Add(9 * time.Second))
+	if got := addrs(r.instances("orders")); len(got) != 2 {
+		t.Fatalf("instancias %v después de renovar, quería las dos", got)
+	}
+
+	//Pasado el TTL sin renovar se da de baja, y Heartbeat devuelve NotFound para que el servidor vuelva a registrarse
+	r.expireAt(time.Now().Add(11 * time.Second))
+	if got := addrs(r.instances("orders")); len(got) != 1 || got[0] != "b:1" {
+		t.Fatalf("instancias %v después de vencer a:1, quería [b:1]", got)
+	}
+	if _, err := r.Heartbeat(ctx, l); status.Code(err) != codes.NotFound {
+		t.Errorf("Heartbeat de un lease vencido: %v, quería NotFound", err)
+	}
+	if _, err := r.Deregister(ctx, l); status.Code(err) != codes.NotFound {
+		t.Errorf("Deregister de un lease vencido: %v, quería NotFound", err)
+	}
+}
+
+func TestReregisterReplacesLease(t *testing.T) {
+	r := New(10*time.Second, time.Minute)
+	defer r.Close()
+	ctx := context.Background()
+
+	old := register(t, r, "orders", "a:1", 10)
+	l := register(t, r, "orders", "a:1", 10)
+	if l.Id == old.Id {
+		t.Fatal("el nuevo registro tiene el mismo lease")
+	}
+	if got := addrs(r.instances("orders")); len(got) != 1 {
+		t.Fatalf("instancias %v, quería a:1 una sola vez", got)
+	}
+	if _, err := r.Heartbeat(ctx, old); status.Code(err) != codes.NotFound {
+		t.Errorf("Heartbeat del lease sustituido: %v, quería NotFound", err)
+	}
+	if _, err := r.Heartbeat(ctx, l); err != nil {
+		t.Errorf("Heartbeat del lease nuevo: %v", err)
+	}
+}
+
+//watchStream stream de Watch que entrega en un canal lo que envía el registro
+type watchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *pb.Instances
+}
+
+func (s *watchStream) Context() context.Context { return s.ctx }
+
+func (s *watchStream) Send(in *pb.Instances) error {
+	s.sent <- in
+	return nil
+}
+
+func TestWatch(t *testing.T) {
+	r := New(10*time.Second, time.Minute)
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &watchStream{ctx: ctx, sent: make(chan *pb.Instances, 10)}
+	done := make(chan error, 1)
+	go func() { done <- r.Watch(&pb.WatchRequest{Service: "orders"}, stream) }()
+
+	next := func(want ...string) {
+		t.Helper()
+		select {
+		case in := <-stream.sent:
+			if got := addrs(in); len(got) != len(want) || (len(want) > 0 && got[0] != want[0]) {
+				t.Fatalf("Watch envía %v, quería %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Watch no envía nada, quería %v", want)
+		}
+	}
+
+	//Al abrir el stream se envían las instancias actuales
+	next()
+	l := register(t, r, "orders", "a:1", 10)
+	next("a:1")
+	//Los cambios de otros servicios no se notifican
+	register(t, r, "products", "p:1", 10)
+	if _, err := r.Deregister(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	next()
+	register(t, r, "orders", "b:1", 10)
+	next("b:1")
+	r.expireAt(time.Now().Add(time.Minute))
+	next()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Watch termina con %v, quería context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watch no termina al cancelar el stream")
+	}
+	select {
+	case in := <-stream.sent:
+		t.Errorf("envío de más: %v", addrs(in))
+	default:
+	}
+}
+
+func TestWatchWithoutService(t *testing.T) {
+	r := New(10*time.Second, time.Minute)
+	defer r.Close()
+	stream := &watchStream{ctx: context.Background(), sent: make(chan *pb.Instances, 1)}
+	if err := r.Watch(&pb.WatchRequest{}, stream); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Watch sin servicio: %v, quería InvalidArgument", err)
+	}
+}
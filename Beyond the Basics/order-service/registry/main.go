@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"time"
+
+	"registry/servidor/leases"
+	pb "registry/servidor/registry"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+var (
+	port   = flag.String("port", ":50100", "puerto en el que escucha el registro")
+	ttl    = flag.Duration("ttl", 10*time.Second, "TTL de los leases que no lo indican")
+	maxTTL = flag.Duration("max-ttl", time.Minute, "TTL máximo de los leases")
+)
+
+func main() {
+	flag.Parse()
+
+	lis, err := net.Listen("tcp", *port)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	registry := leases.New(*ttl, *maxTTL)
+	defer registry.Close()
+
+	s := grpc.NewServer()
+	pb.RegisterRegistryServer(s, registry)
+
+	// Register reflection service on gRPC server.
+	reflection.Register(s)
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Agent registra el servidor en el registro de servicios al arrancar y renueva su lease mientras está en marcha.
+//Si el registro no está disponible lo sigue intentando, y si el lease vence vuelve a registrarse
+type Agent struct {
+	conn     *grpc.ClientConn
+	client   RegistryClient
+	instance *Instance
+	ttl      time.Duration
+
+	mu     sync.Mutex
+	lease  *Lease
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+//Start conecta con el registro y registra la instancia en segundo plano. Renueva el lease cada tercio del TTL
+func Start(target string, instance *Instance, ttl time.Duration) (*Agent, error) {
+	conn, err := grpc.Dial(target, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Agent{
+		conn:     conn,
+		client:   NewRegistryClient(conn),
+		instance: instance,
+		ttl:      ttl,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go a.run(ctx)
+	return a, nil
+}
+
+func (a *Agent) run(ctx context.Context) {
+	defer close(a.done)
+	wait := time.Duration(0)
+	for {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		wait = a.ttl / 3
+
+		a.mu.Lock()
+		lease := a.lease
+		a.mu.Unlock()
+
+		callCtx, cancel := context.WithTimeout(ctx, wait)
+		if lease == nil {
+			l, err := a.client.Register(callCtx, &RegisterRequest{Instance: a.instance, TtlSeconds: int64(a.ttl / time.Second)})
+			if err != nil {
+				log.Printf("====== [Registry] no se puede registrar %s en %s: %v", a.instance.Addr, a.instance.Service, status.Code(err))
+				//Mientras no esté registrado lo intenta cada segundo
+				wait = time.Second
+			} else {
+				log.Printf("====== [Registry] %s registrado en %s, lease %s", a.instance.Addr, a.instance.Service, l.Id)
+				a.mu.Lock()
+				a.lease = l
+				a.mu.Unlock()
+			}
+		} else if _, err := a.client.Heartbeat(callCtx, lease); status.Code(err) == codes.NotFound {
+			//El lease ha vencido, o el registro se ha reiniciado
+			log.Printf("====== [Registry] lease %s vencido, se vuelve a registrar %s", lease.Id, a.instance.Addr)
+			a.mu.Lock()
+			a.lease = nil
+			a.mu.Unlock()
+			wait = 0
+		} else if err != nil {
+			//El lease puede seguir vivo, así que se reintenta antes de que venza
+			log.Printf("====== [Registry] no se puede renovar el lease %s: %v", lease.Id, status.Code(err))
+			wait = time.Second
+		}
+		cancel()
+	}
+}
+
+//Stop da de baja la instancia y cierra la conexión con el registro
+func (a *Agent) Stop() {
+	a.cancel()
+	<-a.done
+	a.mu.Lock()
+	lease := a.lease
+	a.mu.Unlock()
+	if lease != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := a.client.Deregister(ctx, lease); err != nil {
+			log.Printf("====== [Registry] no se puede dar de baja %s: %v", a.instance.Addr, status.Code(err))
+		}
+	}
+	a.conn.Close()
+}
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//fakeRegistry registro que da un lease nuevo en cada Register y contesta NotFound a los Heartbeat de los leases vencidos
+type fakeRegistry struct {
+	RegistryClient
+
+	mu         sync.Mutex
+	registers  int
+	heartbeats map[string]int
+	expired    map[string]bool
+}
+
+func (f *fakeRegistry) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.registers++
+	return &Lease{Id: fmt.Sprint("lease-", f.registers), TtlSeconds: in.TtlSeconds}, nil
+}
+
+func (f *fakeRegistry) Heartbeat(ctx context.Context, in *Lease, opts ...grpc.CallOption) (*Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.expired[in.Id] {
+		return nil, status.Errorf(codes.NotFound, "lease %s no existe", in.Id)
+	}
+	f.heartbeats[in.Id]++
+	return in, nil
+}
+
+func (f *fakeRegistry) state() (int, map[string]int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hb := map[string]int{}
+	for k, v := range f.heartbeats {
+		hb[k] = v
+	}
+	return f.registers, hb
+}
+
+func (f *fakeRegistry) expire(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expired[id] = true
+}
+
+func (a *Agent) currentLease() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lease == nil {
+		return ""
+	}
+	return a.lease.Id
+}
+
+//waitFor espera hasta un segundo a que se cumpla cond
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("no se cumple: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAgentReregistersAfterNotFound(t *testing.T) {
+	f := &fakeRegistry{heartbeats: map[string]int{}, expired: map[string]bool{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Agent{
+		client:   f,
+		instance: &Instance{Service: "orders", Addr: "localhost:50051"},
+		//Renueva cada 10ms
+		ttl:  30 * time.Millisecond,
+		done: make(chan struct{}),
+	}
+	go a.run(ctx)
+	defer func() {
+		cancel()
+		<-a.done
+	}()
+
+	waitFor(t, "registro y renovación de lease-1", func() bool {
+		_, hb := f.state()
+		return hb["lease-1"] > 0
+	})
+
+	//El registro se reinicia o el lease vence: el siguiente Heartbeat recibe NotFound y el agente se registra de nuevo
+	f.expire("lease-1")
+	waitFor(t, "renovación de lease-2", func() bool {
+		_, hb := f.state()
+		return hb["lease-2"] > 0
+	})
+	if got := a.currentLease(); got != "lease-2" {
+		t.Errorf("lease actual %q, quería lease-2", got)
+	}
+	if registers, _ := f.state(); registers != 2 {
+		t.Errorf("%d registros, quería 2", registers)
+	}
+}
@@ -3,8 +3,26 @@ module interceptors/servidor
 go 1.15
 
 require (
+	comun/cache v0.0.0
+	comun/orca v0.0.0
+	comun/tenant v0.0.0
+	comun/tracing v0.0.0
 	github.com/golang/protobuf v1.4.3
-	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.33.1
-	google.golang.org/protobuf v1.23.0
+	google.golang.org/protobuf v1.25.0
+	registry/servidor v0.0.0
+	seguridad/auth v0.0.0
 )
+
+replace seguridad/auth => ../../../Seguridad/auth
+
+replace comun/tracing => ../../../comun/tracing
+
+replace registry/servidor => ../registry
+
+replace comun/cache => ../../../comun/cache
+
+replace comun/tenant => ../../../comun/tenant
+
+replace comun/orca => ../../../comun/orca
@@ -0,0 +1,136 @@
+package interceptors
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//DeadlineBudget controla el tiempo que le queda a las llamadas que recibe el servidor
+type DeadlineBudget struct {
+	//Tiempo mínimo que tiene que quedar por método, con el nombre completo. Por ejemplo /ecommerce.OrderManagement/addOrder
+	MinPerMethod map[string]time.Duration
+	//Tiempo mínimo de los métodos que no están en MinPerMethod
+	MinDefault time.Duration
+	//Tiempo máximo que se concede a una llamada. Si la llamada no trae deadline, o trae uno mayor, se recorta. 0 no recorta
+	Max time.Duration
+}
+
+func (b DeadlineBudget) min(method string) time.Duration {
+	if t, ok := b.MinPerMethod[method]; ok {
+		return t
+	}
+	return b.MinDefault
+}
+
+//check rechaza la llamada si le queda menos tiempo del mínimo, y recorta el deadline al máximo
+func (b DeadlineBudget) check(ctx context.Context, method string) (context.Context, context.CancelFunc, error) {
+	deadline, ok := ctx.Deadline()
+	if ok {
+		if remaining := time.Until(deadline); remaining < b.min(method) {
+			log.Printf("====== [Deadline] %s rechazada, quedan %v", method, remaining)
+			return ctx, func() {}, status.Errorf(codes.DeadlineExceeded, "no queda tiempo suficiente para %s: %v", method, remaining)
+		}
+	}
+	if b.Max > 0 && (!ok || time.Until(deadline) > b.Max) {
+		ctx, cancel := context.WithTimeout(ctx, b.Max)
+		return ctx, cancel, nil
+	}
+	return ctx, func() {}, nil
+}
+
+//UnaryServerInterceptor controla el deadline de las llamadas unitarias
+func (b DeadlineBudget) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method, _ := grpc.Method(ctx)
+		ctx, cancel, err := b.check(ctx, method)
+		defer cancel()
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+//StreamServerInterceptor controla el deadline de los streams
+func (b DeadlineBudget) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel, err := b.check(ss.Context(), info.FullMethod)
+		defer cancel()
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+//contextStream sustituye el contexto del stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+//PropagateDeadline interceptor para las conexiones que el servidor abre hacia otros servicios, por ejemplo hacia ProductInfo.
+//Las llamadas que se hagan con el contexto del handler heredan el tiempo que le queda a la llamada original, menos margin,
+//que se reserva para procesar la respuesta. Si no queda tiempo la llamada falla sin salir
+func PropagateDeadline(margin time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		remaining := time.Until(deadline) - margin
+		if remaining <= 0 {
+			return status.Errorf(codes.DeadlineExceeded, "no queda tiempo para llamar a %s", method)
+		}
+		ctx, cancel := context.WithTimeout(ctx, remaining)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+//PropagateStreamDeadline igual que PropagateDeadline, para streams
+func PropagateStreamDeadline(margin time.Duration) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		remaining := time.Until(deadline) - margin
+		if remaining <= 0 {
+			return nil, status.Errorf(codes.DeadlineExceeded, "no queda tiempo para llamar a %s", method)
+		}
+		ctx, cancel := context.WithTimeout(ctx, remaining)
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &cancelStream{ClientStream: s, cancel: cancel, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+//cancelStream libera el timer del deadline cuando termina el stream
+type cancelStream struct {
+	grpc.ClientStream
+	cancel        context.CancelFunc
+	serverStreams bool
+	once          sync.Once
+}
+
+func (s *cancelStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.once.Do(s.cancel)
+	}
+	return err
+}
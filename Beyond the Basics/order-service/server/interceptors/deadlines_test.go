@@ -0,0 +1,223 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "interceptors/servidor/ecommerce"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const addOrderMethod = "/ecommerce.OrderManagement/addOrder"
+
+//remaining devuelve el tiempo que le queda al contexto, o 0 si no tiene deadline
+func remaining(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	return time.Until(deadline)
+}
+
+//budgetCall hace una llamada unitaria a method con timeout (0 sin deadline). Devuelve el tiempo que ve el handler, o -1 si
+//no se le llama, y el error de la llamada
+func budgetCall(b DeadlineBudget, method string, timeout time.Duration) (time.Duration, error) {
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), transportStream{method})
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	seen := time.Duration(-1)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = remaining(ctx)
+		return "ok", nil
+	}
+	_, err := b.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	return seen, err
+}
+
+func TestDeadlineBudgetRejectsShortBudget(t *testing.T) {
+	b := DeadlineBudget{
+		MinPerMethod: map[string]time.Duration{addOrderMethod: 200 * time.Millisecond},
+		MinDefault:   50 * time.Millisecond,
+	}
+	cases := []struct {
+		name    string
+		method  string
+		timeout time.Duration
+		ok      bool
+	}{
+		{"por encima del mínimo por defecto", faultMethod, 100 * time.Millisecond, true},
+		{"por debajo del mínimo por defecto", faultMethod, 10 * time.Millisecond, false},
+		{"por debajo del mínimo del método", addOrderMethod, 100 * time.Millisecond, false},
+		{"por encima del mínimo del método", addOrderMethod, time.Second, true},
+		//Sin deadline no hay nada que comprobar
+		{"sin deadline", addOrderMethod, 0, true},
+	}
+	for _, c := range cases {
+		seen, err := budgetCall(b, c.method, c.timeout)
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.ok {
+			if status.Code(err) != codes.DeadlineExceeded {
+				t.Errorf("%s: %v, esperado DeadlineExceeded", c.name, err)
+			}
+			if seen >= 0 {
+				t.Errorf("%s: la llamada rechazada llega al handler", c.name)
+			}
+		}
+	}
+}
+
+func TestDeadlineBudgetMax(t *testing.T) {
+	b := DeadlineBudget{Max: time.Second}
+	cases := []struct {
+		name    string
+		timeout time.Duration
+		min     time.Duration
+		max     time.Duration
+	}{
+		//Las llamadas sin deadline o con uno mayor se recortan a Max
+		{"sin deadline", 0, 900 * time.Millisecond, time.Second},
+		{"deadline mayor", time.Minute, 900 * time.Millisecond, time.Second},
+		//Un deadline menor se respeta
+		{"deadline menor", 300 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond},
+	}
+	for _, c := range cases {
+		seen, err := budgetCall(b, faultMethod, c.timeout)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if seen < c.min || seen > c.max {
+			t.Errorf("%s: el handler tiene %v, esperado entre %v y %v", c.name, seen, c.min, c.max)
+		}
+	}
+}
+
+func TestDeadlineBudgetStream(t *testing.T) {
+	b := DeadlineBudget{MinDefault: 50 * time.Millisecond, Max: time.Second}
+	call := func(timeout time.Duration) (time.Duration, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		seen := time.Duration(-1)
+		handler := func(srv interface{}, ss grpc.ServerStream) error {
+			seen = remaining(ss.Context())
+			return nil
+		}
+		err := b.StreamServerInterceptor()(nil, &contextStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/ecommerce.OrderManagement/searchOrders"}, handler)
+		return seen, err
+	}
+
+	if seen, err := call(10 * time.Millisecond); status.Code(err) != codes.DeadlineExceeded || seen >= 0 {
+		t.Errorf("stream sin tiempo: %v, handler con %v", err, seen)
+	}
+	//El handler ve el contexto recortado en el stream
+	if seen, err := call(time.Minute); err != nil || seen > time.Second {
+		t.Errorf("stream con deadline mayor: %v, handler con %v", err, seen)
+	}
+}
+
+func TestPropagateDeadline(t *testing.T) {
+	const margin = 20 * time.Millisecond
+	call := func(timeout time.Duration) (time.Duration, bool, error) {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		seen, called := time.Duration(0), false
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			seen, called = remaining(ctx), true
+			return nil
+		}
+		err := PropagateDeadline(margin)(ctx, faultMethod, nil, nil, nil, invoker)
+		return seen, called, err
+	}
+
+	//La llamada saliente tiene el tiempo de la original menos el margen
+	if seen, _, err := call(time.Second); err != nil || seen > time.Second-margin || seen < 900*time.Millisecond {
+		t.Errorf("con deadline: %v, la llamada saliente tiene %v", err, seen)
+	}
+	//Sin tiempo para el margen la llamada no sale
+	if _, called, err := call(10 * time.Millisecond); called || status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("sin tiempo: llamada enviada=%v, %v", called, err)
+	}
+	//Sin deadline no se pone ninguno
+	if seen, called, err := call(0); !called || err != nil || seen != 0 {
+		t.Errorf("sin deadline: llamada enviada=%v, %v, deadline %v", called, err, seen)
+	}
+}
+
+//downstreamServer servicio al que llama el servidor. Anota el tiempo que le llega
+type downstreamServer struct {
+	pb.UnimplementedOrderManagementServer
+	seen chan time.Duration
+}
+
+func (s *downstreamServer) GetOrder(ctx context.Context, id *wrappers.StringValue) (*pb.Order, error) {
+	s.seen <- remaining(ctx)
+	return &pb.Order{Id: id.Value}, nil
+}
+
+//upstreamServer servidor que atiende getOrder llamando a otro servicio con el contexto del handler
+type upstreamServer struct {
+	pb.UnimplementedOrderManagementServer
+	downstream pb.OrderManagementClient
+}
+
+func (s *upstreamServer) GetOrder(ctx context.Context, id *wrappers.StringValue) (*pb.Order, error) {
+	return s.downstream.GetOrder(ctx, id)
+}
+
+func serve(t *testing.T, srv pb.OrderManagementServer, opts ...grpc.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(opts...)
+	pb.RegisterOrderManagementServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func dialOrders(t *testing.T, addr string, opts ...grpc.DialOption) pb.OrderManagementClient {
+	t.Helper()
+	conn, err := grpc.Dial(addr, append(opts, grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewOrderManagementClient(conn)
+}
+
+func TestPropagateDeadlineToDownstream(t *testing.T) {
+	const margin = 100 * time.Millisecond
+	down := &downstreamServer{seen: make(chan time.Duration, 1)}
+	downAddr := serve(t, down)
+	up := &upstreamServer{downstream: dialOrders(t, downAddr, grpc.WithUnaryInterceptor(PropagateDeadline(margin)))}
+	budget := DeadlineBudget{MinDefault: 5 * time.Millisecond, Max: 30 * time.Second}
+	upAddr := serve(t, up, grpc.UnaryInterceptor(budget.UnaryServerInterceptor()))
+	client := dialOrders(t, upAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "106"}); err != nil {
+		t.Fatal(err)
+	}
+	//El servicio de abajo recibe el deadline del cliente original menos el margen, no los 30s de Max
+	seen := <-down.seen
+	if seen > time.Second-margin || seen < 500*time.Millisecond {
+		t.Errorf("el servicio de abajo tiene %v, esperado algo menos de %v", seen, time.Second-margin)
+	}
+}
@@ -0,0 +1,348 @@
+package interceptors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//Metadatos con los que el cliente puede pedir un fallo en una llamada concreta
+const (
+	//FaultDelayKey retardo antes de procesar la llamada, con el formato de time.ParseDuration. Por ejemplo 300ms
+	FaultDelayKey = "x-fault-delay"
+	//FaultCodeKey código de error que se devuelve, por nombre o número. Por ejemplo Unavailable o 14
+	FaultCodeKey = "x-fault-code"
+	//FaultDropKey porcentaje de mensajes del stream que se descartan
+	FaultDropKey = "x-fault-drop"
+	//FaultAbortAfterKey número de mensajes del stream tras los que se aborta la llamada
+	FaultAbortAfterKey = "x-fault-abort-after"
+	//FaultPercentKey porcentaje de llamadas, de 0 a 100, en las que se inyecta el fallo pedido con los otros metadatos. Por defecto 100
+	FaultPercentKey = "x-fault-percent"
+)
+
+//FaultRule fallo que se inyecta en un porcentaje de las llamadas
+type FaultRule struct {
+	//Métodos, con el nombre completo, a los que se aplica. Vacío se aplica a todos
+	Methods []string `json:"methods"`
+	//Porcentaje de llamadas, de 0 a 100, en las que se inyecta el fallo
+	Percent float64 `json:"percent"`
+	//Retardo antes de procesar la llamada
+	Delay Duration `json:"delay"`
+	//Código de error que se devuelve sin llegar al handler. OK no inyecta error
+	Code Code `json:"code"`
+	//Porcentaje de mensajes del stream que se descartan, tanto recibidos como enviados
+	DropPercent float64 `json:"drop_percent"`
+	//Número de mensajes del stream tras los que se aborta la llamada con AbortCode. 0 no aborta
+	AbortAfter int `json:"abort_after"`
+	//Código con el que se aborta el stream. Por defecto Aborted
+	AbortCode Code `json:"abort_code"`
+}
+
+func (r *FaultRule) applies(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+//Duration time.Duration que en JSON se escribe como "300ms"
+type Duration time.Duration
+
+//UnmarshalJSON lee la duración en el formato de time.ParseDuration
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+//Code codes.Code que en JSON se escribe por nombre, por ejemplo "Unavailable"
+type Code codes.Code
+
+//UnmarshalJSON lee el código por nombre o por número
+func (c *Code) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n uint32
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		*c = Code(n)
+		return nil
+	}
+	code, err := parseCode(s)
+	if err != nil {
+		return err
+	}
+	*c = Code(code)
+	return nil
+}
+
+func parseCode(s string) (codes.Code, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return codes.Code(n), nil
+	}
+	for i := codes.OK; i <= codes.Unauthenticated; i++ {
+		if strings.EqualFold(i.String(), s) {
+			return i, nil
+		}
+	}
+	return codes.OK, fmt.Errorf("código desconocido: %q", s)
+}
+
+//FaultInjector interceptor que inyecta fallos para probar la resiliencia de los clientes
+type FaultInjector struct {
+	mu    sync.RWMutex
+	rules []FaultRule
+	//Permite que el cliente pida fallos con los metadatos x-fault-*. Solo debe activarse en pruebas o en preproducción
+	allowMetadata bool
+	rnd           *rand.Rand
+	rndMu         sync.Mutex
+}
+
+//NewFaultInjector crea el inyector de fallos
+func NewFaultInjector(rules []FaultRule, allowMetadata bool) *FaultInjector {
+	return &FaultInjector{rules: rules, allowMetadata: allowMetadata, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+//LoadFaultRules lee las reglas de un fichero JSON con una lista de FaultRule
+func LoadFaultRules(path string) ([]FaultRule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []FaultRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+//SetRules cambia las reglas en caliente
+func (f *FaultInjector) SetRules(rules []FaultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+}
+
+func (f *FaultInjector) chance(percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	f.rndMu.Lock()
+	defer f.rndMu.Unlock()
+	return f.rnd.Float64()*100 < percent
+}
+
+//fault decide el fallo a inyectar en la llamada. Los metadatos tienen prioridad sobre las reglas, y como ellas solo se
+//aplican en el porcentaje de llamadas que indican
+func (f *FaultInjector) fault(ctx context.Context, method string) *FaultRule {
+	if f.allowMetadata {
+		if r := faultFromMetadata(ctx); r != nil {
+			if !f.chance(r.Percent) {
+				return nil
+			}
+			return r
+		}
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := range f.rules {
+		r := &f.rules[i]
+		if r.applies(method) && f.chance(r.Percent) {
+			return r
+		}
+	}
+	return nil
+}
+
+func faultFromMetadata(ctx context.Context) *FaultRule {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	r := &FaultRule{Percent: 100}
+	found := false
+	if v := md.Get(FaultDelayKey); len(v) > 0 {
+		if d, err := time.ParseDuration(v[0]); err == nil {
+			r.Delay, found = Duration(d), true
+		}
+	}
+	if v := md.Get(FaultCodeKey); len(v) > 0 {
+		if c, err := parseCode(v[0]); err == nil {
+			r.Code, found = Code(c), true
+		}
+	}
+	if v := md.Get(FaultDropKey); len(v) > 0 {
+		if p, err := strconv.ParseFloat(v[0], 64); err == nil {
+			r.DropPercent, found = p, true
+		}
+	}
+	if v := md.Get(FaultAbortAfterKey); len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil {
+			r.AbortAfter, found = n, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	if v := md.Get(FaultPercentKey); len(v) > 0 {
+		if p, err := strconv.ParseFloat(v[0], 64); err == nil {
+			r.Percent = p
+		}
+	}
+	return r
+}
+
+//before aplica el retardo y el código de error
+func (f *FaultInjector) before(ctx context.Context, method string, r *FaultRule) error {
+	if r.Delay > 0 {
+		log.Printf("====== [Fault] %s: retardo de %v", method, time.Duration(r.Delay))
+		select {
+		case <-time.After(time.Duration(r.Delay)):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if codes.Code(r.Code) != codes.OK {
+		log.Printf("====== [Fault] %s: error %s", method, codes.Code(r.Code))
+		return status.Errorf(codes.Code(r.Code), "fallo inyectado en %s", method)
+	}
+	return nil
+}
+
+//UnaryServerInterceptor inyecta retardos y errores en las llamadas unitarias
+func (f *FaultInjector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method, _ := grpc.Method(ctx)
+		if r := f.fault(ctx, method); r != nil {
+			if err := f.before(ctx, method, r); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+//StreamServerInterceptor inyecta retardos, errores, mensajes perdidos y streams abortados
+func (f *FaultInjector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		r := f.fault(ss.Context(), info.FullMethod)
+		if r == nil {
+			return handler(srv, ss)
+		}
+		if err := f.before(ss.Context(), info.FullMethod, r); err != nil {
+			return err
+		}
+		if r.DropPercent <= 0 && r.AbortAfter <= 0 {
+			return handler(srv, ss)
+		}
+		abortCode := codes.Code(r.AbortCode)
+		if abortCode == codes.OK {
+			abortCode = codes.Aborted
+		}
+		fs := &faultyStream{ServerStream: ss, injector: f, method: info.FullMethod, rule: r, abortCode: abortCode,
+			clientStreams: info.IsClientStream, serverStreams: info.IsServerStream}
+		err := handler(srv, fs)
+		if aborted := fs.abortError(); aborted != nil {
+			return aborted
+		}
+		return err
+	}
+}
+
+//faultyStream pierde mensajes y aborta el stream según la regla. Solo se cuentan los mensajes de los streams;
+//la petición de un stream del servidor o la respuesta de un stream del cliente no se pierden nunca. En los streams
+//bidireccionales el handler puede recibir y enviar desde goroutines distintas, así que el contador y el error están protegidos
+type faultyStream struct {
+	grpc.ServerStream
+	injector      *FaultInjector
+	method        string
+	rule          *FaultRule
+	abortCode     codes.Code
+	clientStreams bool
+	serverStreams bool
+	mu            sync.Mutex
+	messages      int
+	aborted       error
+}
+
+//abortError devuelve el error con el que se ha abortado el stream, o nil
+func (s *faultyStream) abortError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aborted
+}
+
+//abort cuenta un mensaje y aborta el stream si se ha llegado a AbortAfter
+func (s *faultyStream) abort() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages++
+	if s.rule.AbortAfter > 0 && s.messages > s.rule.AbortAfter {
+		if s.aborted == nil {
+			log.Printf("====== [Fault] %s: stream abortado tras %d mensajes", s.method, s.rule.AbortAfter)
+			s.aborted = status.Errorf(s.abortCode, "stream abortado por un fallo inyectado en %s", s.method)
+		}
+		return s.aborted
+	}
+	return nil
+}
+
+func (s *faultyStream) RecvMsg(m interface{}) error {
+	for {
+		if err := s.abortError(); err != nil {
+			return err
+		}
+		if err := s.ServerStream.RecvMsg(m); err != nil || !s.clientStreams {
+			return err
+		}
+		if err := s.abort(); err != nil {
+			return err
+		}
+		if !s.injector.chance(s.rule.DropPercent) {
+			return nil
+		}
+		log.Printf("====== [Fault] %s: se descarta un mensaje recibido", s.method)
+	}
+}
+
+func (s *faultyStream) SendMsg(m interface{}) error {
+	if err := s.abortError(); err != nil {
+		return err
+	}
+	if !s.serverStreams {
+		return s.ServerStream.SendMsg(m)
+	}
+	if err := s.abort(); err != nil {
+		return err
+	}
+	if s.injector.chance(s.rule.DropPercent) {
+		log.Printf("====== [Fault] %s: se descarta un mensaje enviado", s.method)
+		return nil
+	}
+	return s.ServerStream.SendMsg(m)
+}
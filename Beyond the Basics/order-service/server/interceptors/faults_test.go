@@ -0,0 +1,181 @@
+package interceptors
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const faultMethod = "/ecommerce.OrderManagement/getOrder"
+
+//transportStream da el nombre del método a grpc.Method, como hace el servidor
+type transportStream struct{ method string }
+
+func (s transportStream) Method() string                  { return s.method }
+func (s transportStream) SetHeader(md metadata.MD) error  { return nil }
+func (s transportStream) SendHeader(md metadata.MD) error { return nil }
+func (s transportStream) SetTrailer(md metadata.MD) error { return nil }
+
+func callContext(kv ...string) context.Context {
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), transportStream{faultMethod})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+}
+
+func testInjector(rules []FaultRule, allowMetadata bool) *FaultInjector {
+	f := NewFaultInjector(rules, allowMetadata)
+	f.rnd = rand.New(rand.NewSource(1))
+	return f
+}
+
+//failures cuenta las llamadas unitarias que terminan con un fallo inyectado
+func failures(f *FaultInjector, ctx context.Context, n int) int {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	count := 0
+	for i := 0; i < n; i++ {
+		if _, err := f.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: faultMethod}, handler); err != nil {
+			if status.Code(err) != codes.Unavailable {
+				panic(err)
+			}
+			count++
+		}
+	}
+	return count
+}
+
+func TestFaultRules(t *testing.T) {
+	f := testInjector([]FaultRule{
+		{Methods: []string{"/ecommerce.OrderManagement/addOrder"}, Percent: 100, Code: Code(codes.Internal)},
+		{Methods: []string{faultMethod}, Percent: 30, Code: Code(codes.Unavailable)},
+	}, false)
+	if n := failures(f, callContext(), 1000); n < 250 || n > 350 {
+		t.Errorf("%d fallos de 1000 con percent 30", n)
+	}
+	//Sin -fault-metadata los metadatos no se tienen en cuenta
+	f.SetRules(nil)
+	if n := failures(f, callContext(FaultCodeKey, "Unavailable"), 100); n != 0 {
+		t.Errorf("%d fallos pedidos con metadatos sin permitirlo", n)
+	}
+}
+
+func TestFaultMetadataPercent(t *testing.T) {
+	f := testInjector(nil, true)
+	cases := []struct {
+		md       []string
+		min, max int
+	}{
+		{[]string{FaultCodeKey, "Unavailable"}, 1000, 1000},
+		{[]string{FaultCodeKey, "14", FaultPercentKey, "100"}, 1000, 1000},
+		{[]string{FaultCodeKey, "Unavailable", FaultPercentKey, "0"}, 0, 0},
+		{[]string{FaultCodeKey, "Unavailable", FaultPercentKey, "25"}, 200, 300},
+		//El porcentaje solo no pide ningún fallo
+		{[]string{FaultPercentKey, "100"}, 0, 0},
+	}
+	for _, c := range cases {
+		if n := failures(f, callContext(c.md...), 1000); n < c.min || n > c.max {
+			t.Errorf("%v: %d fallos de 1000, quería entre %d y %d", c.md, n, c.min, c.max)
+		}
+	}
+}
+
+func TestFaultDelayHonorsCancellation(t *testing.T) {
+	f := testInjector([]FaultRule{{Percent: 100, Delay: Duration(time.Hour)}}, false)
+	ctx, cancel := context.WithTimeout(callContext(), 20*time.Millisecond)
+	defer cancel()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Error("el handler se llama después de cancelarse la llamada")
+		return nil, nil
+	}
+	_, err := f.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: faultMethod}, handler)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("error %v, quería DeadlineExceeded", err)
+	}
+}
+
+//bidiStream stream del servidor que recibe n mensajes y guarda los que se envían
+type bidiStream struct {
+	grpc.ServerStream
+	mu       sync.Mutex
+	received int
+	n        int
+	sent     int
+}
+
+func (s *bidiStream) Context() context.Context { return context.Background() }
+
+func (s *bidiStream) RecvMsg(m interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.received == s.n {
+		return io.EOF
+	}
+	s.received++
+	return nil
+}
+
+func (s *bidiStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent++
+	return nil
+}
+
+var bidiInfo = &grpc.StreamServerInfo{FullMethod: "/ecommerce.OrderManagement/processOrders", IsClientStream: true, IsServerStream: true}
+
+func TestFaultAbortAfterConcurrent(t *testing.T) {
+	f := testInjector([]FaultRule{{Percent: 100, AbortAfter: 50}}, false)
+	ss := &bidiStream{n: 1000}
+	//Como processOrders, el handler recibe y envía a la vez desde dos goroutines
+	var sent, received int
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for stream.SendMsg("envío") == nil {
+				sent++
+			}
+		}()
+		for stream.RecvMsg(nil) == nil {
+			received++
+		}
+		wg.Wait()
+		return nil
+	}
+	err := f.StreamServerInterceptor()(nil, ss, bidiInfo, handler)
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("error %v, quería Aborted", err)
+	}
+	if total := received + sent; total != 50 {
+		t.Errorf("%d mensajes antes de abortar, quería 50", total)
+	}
+}
+
+func TestFaultDrop(t *testing.T) {
+	f := testInjector([]FaultRule{{Percent: 100, DropPercent: 100}}, false)
+	ss := &bidiStream{n: 10}
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		if err := stream.RecvMsg(nil); err != io.EOF {
+			t.Errorf("RecvMsg = %v, quería io.EOF después de perder todos los mensajes", err)
+		}
+		for i := 0; i < 10; i++ {
+			if err := stream.SendMsg("envío"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := f.StreamServerInterceptor()(nil, ss, bidiInfo, handler); err != nil {
+		t.Fatal(err)
+	}
+	if ss.received != 10 || ss.sent != 0 {
+		t.Errorf("recibidos %d y enviados %d, quería 10 y 0", ss.received, ss.sent)
+	}
+}
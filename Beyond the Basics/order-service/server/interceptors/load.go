@@ -0,0 +1,90 @@
+package interceptors
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"comun/orca"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//LoadReporter informa a los clientes de la carga del servidor en el trailer de cada llamada, para que el balanceo
+//weighted_round_robin envíe más llamadas a los servidores menos cargados
+type LoadReporter struct {
+	//Llamadas simultáneas que el servidor puede atender. La utilización es la media de llamadas en curso entre la capacidad
+	Capacity int
+	//Calcula la utilización, de 0 a 1. Si se indica no se usa Capacity
+	Utilization func() float64
+
+	mu       sync.Mutex
+	inFlight int
+	//Media móvil de las llamadas en curso
+	concurrency float64
+	//Llamadas y errores del último segundo completo y del actual
+	window      time.Time
+	calls, errs int
+	rps, eps    float64
+}
+
+//begin registra el inicio de una llamada
+func (l *LoadReporter) begin() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight++
+	l.concurrency = 0.9*l.concurrency + 0.1*float64(l.inFlight)
+}
+
+//end registra el final de una llamada y devuelve el informe de carga
+func (l *LoadReporter) end(err error) metadata.MD {
+	l.mu.Lock()
+	l.inFlight--
+	now := time.Now()
+	if elapsed := now.Sub(l.window); elapsed >= time.Second {
+		if elapsed < 2*time.Second {
+			l.rps, l.eps = float64(l.calls), float64(l.errs)
+		} else {
+			l.rps, l.eps = 0, 0
+		}
+		l.window, l.calls, l.errs = now.Truncate(time.Second), 0, 0
+	}
+	l.calls++
+	if err != nil {
+		l.errs++
+	}
+	//Hasta que haya un segundo completo se usa el ritmo del segundo actual
+	rps, eps := math.Max(l.rps, float64(l.calls)), math.Max(l.eps, float64(l.errs))
+	utilization := 0.0
+	if l.Capacity > 0 {
+		utilization = math.Min(1, l.concurrency/float64(l.Capacity))
+	}
+	l.mu.Unlock()
+
+	if l.Utilization != nil {
+		utilization = l.Utilization()
+	}
+	return orca.LoadReportTrailer(orca.LoadReport{Utilization: utilization, RPS: rps, EPS: eps})
+}
+
+//UnaryServerInterceptor añade el informe de carga al trailer de las llamadas unitarias
+func (l *LoadReporter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		l.begin()
+		m, err := handler(ctx, req)
+		grpc.SetTrailer(ctx, l.end(err))
+		return m, err
+	}
+}
+
+//StreamServerInterceptor añade el informe de carga al trailer de los streams
+func (l *LoadReporter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		l.begin()
+		err := handler(srv, ss)
+		ss.SetTrailer(l.end(err))
+		return err
+	}
+}
@@ -0,0 +1,126 @@
+package interceptors
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"interceptors/servidor/traffic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//Recorder graba las llamadas que recibe el servidor para poder reproducirlas después
+type Recorder struct {
+	w *traffic.Writer
+	//Metadatos que no se graban
+	redact map[string]bool
+}
+
+//NewRecorder crea el grabador. Los metadatos indicados en redact, además de authorization, no se guardan
+func NewRecorder(w *traffic.Writer, redact ...string) *Recorder {
+	r := &Recorder{w: w, redact: map[string]bool{"authorization": true}}
+	for _, k := range redact {
+		r.redact[strings.ToLower(k)] = true
+	}
+	return r
+}
+
+//UnaryServerInterceptor graba las llamadas unitarias
+func (r *Recorder) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	c := r.begin(ctx, false, false)
+	c.add(traffic.Received, req)
+
+	m, err := handler(ctx, req)
+
+	if err == nil {
+		c.add(traffic.Sent, m)
+	}
+	r.end(c, err)
+	return m, err
+}
+
+//StreamServerInterceptor graba las llamadas con streams, mensaje a mensaje
+func (r *Recorder) StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	c := r.begin(ss.Context(), info.IsClientStream, info.IsServerStream)
+
+	err := handler(srv, &recordedStream{ServerStream: ss, call: c})
+
+	r.end(c, err)
+	return err
+}
+
+//recordingCall llamada que se está grabando
+type recordingCall struct {
+	mu    sync.Mutex
+	start time.Time
+	call  *traffic.Call
+}
+
+func (r *Recorder) begin(ctx context.Context, clientStreams, serverStreams bool) *recordingCall {
+	method, _ := grpc.Method(ctx)
+	c := &traffic.Call{
+		Method:        method,
+		ClientStreams: clientStreams,
+		ServerStreams: serverStreams,
+		Start:         time.Now(),
+		Metadata:      map[string][]string{},
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range md {
+			if !r.redact[k] {
+				c.Metadata[k] = v
+			}
+		}
+	}
+	return &recordingCall{start: c.Start, call: c}
+}
+
+func (c *recordingCall) add(direction string, m interface{}) {
+	msg, err := traffic.NewMessage(direction, time.Since(c.start), m)
+	if err != nil {
+		log.Printf("====== [Recorder] no se puede grabar el mensaje: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.call.Messages = append(c.call.Messages, msg)
+	c.mu.Unlock()
+}
+
+func (r *Recorder) end(c *recordingCall, err error) {
+	st := status.Convert(err)
+	c.mu.Lock()
+	c.call.Duration = time.Since(c.start)
+	c.call.Code = st.Code().String()
+	c.call.Status = st.Message()
+	c.mu.Unlock()
+	if werr := r.w.Write(c.call); werr != nil {
+		log.Printf("====== [Recorder] no se puede grabar la llamada %s: %v", c.call.Method, werr)
+	}
+}
+
+//recordedStream graba los mensajes que pasan por el stream
+type recordedStream struct {
+	grpc.ServerStream
+	call *recordingCall
+}
+
+func (s *recordedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.call.add(traffic.Received, m)
+	}
+	return err
+}
+
+func (s *recordedStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.call.add(traffic.Sent, m)
+	}
+	return err
+}
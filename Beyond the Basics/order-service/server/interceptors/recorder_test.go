@@ -0,0 +1,174 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	pb "interceptors/servidor/ecommerce"
+	"interceptors/servidor/traffic"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//recordedServer responde getOrder, salvo la orden 404, y processOrders con un envío por cada orden
+type recordedServer struct {
+	pb.UnimplementedOrderManagementServer
+}
+
+func (s *recordedServer) GetOrder(ctx context.Context, id *wrappers.StringValue) (*pb.Order, error) {
+	if id.Value == "404" {
+		return nil, status.Error(codes.NotFound, "la orden no existe")
+	}
+	return &pb.Order{Id: id.Value, Items: []string{"Amazon Echo"}, Price: 30}, nil
+}
+
+func (s *recordedServer) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
+	for {
+		id, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&pb.CombinedShipment{Id: "cmb-" + id.Value, Status: "Processed!"}); err != nil {
+			return err
+		}
+	}
+}
+
+//recordCalls arranca un servidor con el grabador, hace las llamadas de call y devuelve lo grabado
+func recordCalls(t *testing.T, call func(ctx context.Context, client pb.OrderManagementClient)) []*traffic.Call {
+	t.Helper()
+	var buf bytes.Buffer
+	r := NewRecorder(traffic.NewWriter(&buf), "x-api-key")
+	addr := serve(t, &recordedServer{},
+		grpc.UnaryInterceptor(r.UnaryServerInterceptor), grpc.StreamInterceptor(r.StreamServerInterceptor))
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"authorization", "Bearer secreto", "x-api-key", "secreto", "x-request-id", "42")
+	call(ctx, dialOrders(t, addr))
+
+	calls, err := traffic.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range calls {
+		if _, ok := c.Metadata["authorization"]; ok {
+			t.Errorf("%s: se graba authorization", c.Method)
+		}
+		if _, ok := c.Metadata["x-api-key"]; ok {
+			t.Errorf("%s: se graba un metadato excluido en NewRecorder", c.Method)
+		}
+		if v := c.Metadata["x-request-id"]; len(v) != 1 || v[0] != "42" {
+			t.Errorf("%s: x-request-id grabado %v", c.Method, v)
+		}
+	}
+	return calls
+}
+
+//decode decodifica los mensajes grabados en una dirección
+func decode(t *testing.T, c *traffic.Call, direction string) []proto.Message {
+	t.Helper()
+	var msgs []proto.Message
+	for _, m := range c.Messages {
+		if m.Direction != direction {
+			continue
+		}
+		msg, err := m.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestRecorderUnary(t *testing.T) {
+	calls := recordCalls(t, func(ctx context.Context, client pb.OrderManagementClient) {
+		if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "106"}); err != nil {
+			t.Fatal(err)
+		}
+		client.GetOrder(ctx, &wrappers.StringValue{Value: "404"})
+	})
+	if len(calls) != 2 {
+		t.Fatalf("%d llamadas grabadas, esperadas 2", len(calls))
+	}
+
+	c := calls[0]
+	if c.Method != faultMethod || c.ClientStreams || c.ServerStreams || c.Code != "OK" {
+		t.Errorf("llamada grabada %s streams=%v/%v código %s", c.Method, c.ClientStreams, c.ServerStreams, c.Code)
+	}
+	req, reply := decode(t, c, traffic.Received), decode(t, c, traffic.Sent)
+	if len(req) != 1 || !proto.Equal(req[0], &wrappers.StringValue{Value: "106"}) {
+		t.Errorf("petición grabada %v", req)
+	}
+	want := &pb.Order{Id: "106", Items: []string{"Amazon Echo"}, Price: 30}
+	if len(reply) != 1 || !proto.Equal(reply[0], want) {
+		t.Errorf("respuesta grabada %v, esperada %v", reply, want)
+	}
+
+	//Las llamadas que fallan se graban con su estado y sin respuesta
+	c = calls[1]
+	if c.Code != "NotFound" || c.Status != "la orden no existe" || len(decode(t, c, traffic.Sent)) != 0 {
+		t.Errorf("llamada con error grabada con código %s, estado %q y %d mensajes", c.Code, c.Status, len(c.Messages))
+	}
+}
+
+func TestRecorderStream(t *testing.T) {
+	ids := []string{"102", "103", "104"}
+	calls := recordCalls(t, func(ctx context.Context, client pb.OrderManagementClient) {
+		stream, err := client.ProcessOrders(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range ids {
+			if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Recv(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stream.CloseSend()
+		if _, err := stream.Recv(); err != io.EOF {
+			t.Fatalf("fin del stream: %v", err)
+		}
+	})
+	if len(calls) != 1 {
+		t.Fatalf("%d llamadas grabadas, esperada 1", len(calls))
+	}
+
+	c := calls[0]
+	if c.Method != "/ecommerce.OrderManagement/processOrders" || !c.ClientStreams || !c.ServerStreams || c.Code != "OK" {
+		t.Errorf("llamada grabada %s streams=%v/%v código %s", c.Method, c.ClientStreams, c.ServerStreams, c.Code)
+	}
+	//Los mensajes se graban en el orden en el que pasan, con su instante dentro de la llamada
+	if len(c.Messages) != 2*len(ids) {
+		t.Fatalf("%d mensajes grabados, esperados %d", len(c.Messages), 2*len(ids))
+	}
+	for i, m := range c.Messages {
+		want := traffic.Received
+		if i%2 == 1 {
+			want = traffic.Sent
+		}
+		if m.Direction != want {
+			t.Errorf("mensaje %d grabado como %s, esperado %s", i, m.Direction, want)
+		}
+		if i > 0 && m.Offset < c.Messages[i-1].Offset {
+			t.Errorf("mensaje %d grabado en %v, antes que el anterior", i, m.Offset)
+		}
+	}
+	for i, m := range decode(t, c, traffic.Sent) {
+		want := &pb.CombinedShipment{Id: "cmb-" + ids[i], Status: "Processed!"}
+		if !proto.Equal(m, want) {
+			t.Errorf("envío %d grabado %v, esperado %v", i, m, want)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package logica
 
 import (
+	"comun/tenant"
 	"context"
 	"fmt"
 	pb "interceptors/servidor/ecommerce"
+	"interceptors/servidor/store"
 	"io"
 	"log"
 	"strings"
@@ -21,14 +23,35 @@ const (
 	orderBatchSize = 3
 )
 
-//Server implementa la lógica de negocio del servicio RPC
+//Server implementa la lógica de negocio del servicio RPC. Cada tenant solo ve sus propias órdenes
 type Server struct {
-	orderMap map[string]*pb.Order
+	orders *store.Orders
 }
 
 //Construye construye el servicio
-func Construye(ordenes map[string]*pb.Order) *Server {
-	return &Server{orderMap: ordenes}
+func Construye(ordenes *store.Orders) *Server {
+	return &Server{orders: ordenes}
+}
+
+//put guarda la orden en el tenant de la llamada
+func (s *Server) put(ctx context.Context, order *pb.Order) error {
+	t := tenant.FromContext(ctx)
+	if err := s.orders.Put(t, order); err != nil {
+		if err == store.ErrQuotaExceeded {
+			return status.Errorf(codes.ResourceExhausted, "tenant %s: %v", t, err)
+		}
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	return nil
+}
+
+//get busca la orden en el tenant de la llamada. Las órdenes de otros tenants no existen
+func (s *Server) get(ctx context.Context, id string) (*pb.Order, error) {
+	ord, ok := s.orders.Get(tenant.FromContext(ctx), id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Order does not exist: %s", id)
+	}
+	return ord, nil
 }
 
 //AddOrder añade una orden (Simple RPC)
@@ -74,7 +97,9 @@ func (s *Server) AddOrder(ctx context.Context, orderReq *pb.Order) (*wrappers.St
 
 		return nil, ds.Err()
 	} else {
-		s.orderMap[orderReq.Id] = orderReq
+		if err := s.put(ctx, orderReq); err != nil {
+			return nil, err
+		}
 		log.Println("Order : ", orderReq.Id, " -> Added")
 		return &wrappers.StringValue{Value: "Order Added: " + orderReq.Id}, nil
 	}
@@ -82,8 +107,7 @@ func (s *Server) AddOrder(ctx context.Context, orderReq *pb.Order) (*wrappers.St
 
 //GetOrder busca una orden (Simple RPC)
 func (s *Server) GetOrder(ctx context.Context, orderId *wrappers.StringValue) (*pb.Order, error) {
-	ord := s.orderMap[orderId.Value]
-	return ord, nil
+	return s.get(ctx, orderId.Value)
 }
 
 //SearchOrders busca ordenes (Server-side Streaming RPC)
@@ -99,15 +123,18 @@ func (s *Server) SearchOrders(searchQuery *wrappers.StringValue, stream pb.Order
 	header := metadata.New(map[string]string{"location": "MTV", "timestamp": time.Now().Format(time.StampNano)})
 	stream.SendHeader(header)
 
-	for key, order := range s.orderMap {
+	matching := s.orders.Search(tenant.FromContext(stream.Context()), func(order *pb.Order) bool {
 		for _, itemStr := range order.Items {
 			if strings.Contains(itemStr, searchQuery.Value) {
-				// Send the matching orders in a stream
-				log.Print("Matching Order Found : "+key, " -> Writing Order to the stream ... ")
-				stream.Send(order)
-				break
+				return true
 			}
 		}
+		return false
+	})
+	for _, order := range matching {
+		// Send the matching orders in a stream
+		log.Print("Matching Order Found : "+order.Id, " -> Writing Order to the stream ... ")
+		stream.Send(order)
 	}
 	return nil
 }
@@ -122,8 +149,13 @@ func (s *Server) UpdateOrders(stream pb.OrderManagement_UpdateOrdersServer) erro
 			// Finished reading the order stream.
 			return stream.SendAndClose(&wrappers.StringValue{Value: "Orders processed " + ordersStr})
 		}
+		if err != nil {
+			return err
+		}
 		// Update order
-		s.orderMap[order.Id] = order
+		if err := s.put(stream.Context(), order); err != nil {
+			return err
+		}
 
 		log.Printf("Order ID ", order.Id, ": Updated")
 		ordersStr += order.Id + ", "
@@ -154,16 +186,18 @@ func (s *Server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) er
 			return err
 		}
 
-		destination := s.orderMap[orderID.GetValue()].Destination
+		ord, err := s.get(stream.Context(), orderID.GetValue())
+		if err != nil {
+			return err
+		}
+		destination := ord.Destination
 		shipment, found := combinedShipmentMap[destination]
 
 		if found {
-			ord := s.orderMap[orderID.GetValue()]
 			shipment.OrdersList = append(shipment.OrdersList, ord)
 			combinedShipmentMap[destination] = shipment
 		} else {
-			comShip := pb.CombinedShipment{Id: "cmb - " + (s.orderMap[orderID.GetValue()].Destination), Status: "Processed!"}
-			ord := s.orderMap[orderID.GetValue()]
+			comShip := pb.CombinedShipment{Id: "cmb - " + destination, Status: "Processed!"}
 			comShip.OrdersList = append(shipment.OrdersList, ord)
 			combinedShipmentMap[destination] = comShip
 			log.Print(len(comShip.OrdersList), comShip.GetId())
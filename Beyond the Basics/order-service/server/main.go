@@ -1,11 +1,25 @@
 package main
 
 import (
+	"comun/cache"
+	"comun/tenant"
+	"comun/tracing"
+	"encoding/json"
+	"flag"
 	pb "interceptors/servidor/ecommerce"
 	interceptors "interceptors/servidor/interceptors"
 	logica "interceptors/servidor/logica"
+	"interceptors/servidor/store"
+	"interceptors/servidor/traffic"
+	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"registry/servidor/registry"
+	"seguridad/auth"
+	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 	_ "google.golang.org/grpc/encoding/gzip" // Install the gzip compressor
@@ -16,37 +30,186 @@ const (
 	port = ":50051"
 )
 
-var orderMap = make(map[string]*pb.Order)
+var (
+	record = flag.String("record", "", "fichero en el que se graba el tráfico recibido")
+	traces = flag.String("traces", "", "fichero en el que se exportan los spans")
+	faults = flag.String("faults", "", "fichero JSON con las reglas de inyección de fallos")
+	//Solo en pruebas o preproducción: permite que el cliente pida fallos con los metadatos x-fault-*
+	faultMetadata = flag.Bool("fault-metadata", false, "permite inyectar fallos con los metadatos x-fault-*")
+	quota         = flag.Int("quota", 1000, "máximo de órdenes por tenant. 0 sin límite")
+	tenantQuotas  = flag.String("tenant-quotas", "", "fichero JSON con la cuota de órdenes de los tenants que no usan -quota")
+	tenantTokens  = flag.String("tenant-tokens", "", "fichero JSON con el tenant de cada token bearer")
+	requireAuth   = flag.Bool("require-auth", false, "exige en todas las llamadas, también en los streams, uno de los tokens de -tenant-tokens")
+	rbacPolicy    = flag.String("rbac", "", "fichero JSON con la política RBAC: los métodos que permite cada rol. Necesita -require-auth")
+	registryAddr  = flag.String("registry", "", "dirección del registro de servicios en el que se registra el servidor, por ejemplo localhost:50100")
+	advertise     = flag.String("advertise", "localhost"+port, "dirección con la que se registra el servidor")
+	zone          = flag.String("zone", "", "zona con la que se registra el servidor")
+)
 
 func main() {
+	flag.Parse()
+
+	//Cuotas propias de algunos tenants, por ejemplo {"acme": 5000, "pruebas": 10}. El resto usa -quota
+	var quotas map[string]int
+	if *tenantQuotas != "" {
+		b, err := ioutil.ReadFile(*tenantQuotas)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", *tenantQuotas, err)
+		}
+		if err := json.Unmarshal(b, &quotas); err != nil {
+			log.Fatalf("failed to parse %s: %v", *tenantQuotas, err)
+		}
+	}
+	orders := store.NewOrders(*quota, quotas)
+	initSampleData(orders)
 
-	initSampleData()
+	//Sin identidad las llamadas van al tenant por defecto, que es el que tiene los datos de ejemplo. Los servicios de salud y
+	//reflexión no tienen tenant: la autenticación los deja pasar aunque traigan authorization, y el tenant no lo puede verificar
+	resolver := &tenant.Resolver{Anonymous: tenant.Default, Skip: auth.HealthAndReflection}
+	if *tenantTokens != "" {
+		b, err := ioutil.ReadFile(*tenantTokens)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", *tenantTokens, err)
+		}
+		if err := json.Unmarshal(b, &resolver.Tokens); err != nil {
+			log.Fatalf("failed to parse %s: %v", *tenantTokens, err)
+		}
+	}
 
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
+
+	//Informa de la carga del servidor en el trailer, para el balanceo weighted_round_robin de los clientes
+	load := &interceptors.LoadReporter{Capacity: 100}
+
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+
+	//La grabación va la primera, para que guarde el tráfico tal y como llega y tal y como sale: las peticiones antes de que ningún
+	//interceptor las toque, y también las llamadas que rechazan el deadline, la autenticación o la cuota, y los fallos inyectados.
+	//Así replay reproduce lo que vio el cliente
+	if *record != "" {
+		w, err := traffic.Create(*record)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", *record, err)
+		}
+		defer w.Close()
+		recorder := interceptors.NewRecorder(w)
+		unary = append(unary, recorder.UnaryServerInterceptor)
+		stream = append(stream, recorder.StreamServerInterceptor)
+	}
+
+	//Después el control del deadline: rechaza las llamadas a las que casi no les queda tiempo antes de gastar nada en trazas,
+	//autenticación, tenant, carga o logs, y recorta las que no traen deadline, de forma que el resto de interceptores ya lo ven recortado
+	budget := interceptors.DeadlineBudget{MinDefault: 5 * time.Millisecond, Max: 30 * time.Second}
+	unary = append(unary, budget.UnaryServerInterceptor())
+	stream = append(stream, budget.StreamServerInterceptor())
+
+	//Después las trazas, para que el resto de interceptores vean el span de la llamada
+	unary = append(unary, tracing.UnaryServerInterceptor)
+	stream = append(stream, tracing.StreamServerInterceptor)
+
+	//La autenticación va antes que el tenant, que solo usa identidades verificadas. Sin -require-auth las llamadas sin credenciales
+	//van al tenant por defecto, pero las que traen credenciales tienen que ser válidas. Los servicios de salud y reflexión quedan abiertos
+	if len(resolver.Tokens) > 0 {
+		authenticate := auth.Optional(resolver.Authenticate)
+		if *requireAuth {
+			authenticate = resolver.Authenticate
+		}
+		authn := auth.NewInterceptor(authenticate, auth.HealthAndReflection...)
+		unary = append(unary, authn.UnaryServerInterceptor())
+		stream = append(stream, authn.StreamServerInterceptor())
+	} else if *requireAuth {
+		log.Fatalf("-require-auth needs -tenant-tokens")
+	}
+
+	//La autorización va justo después de la autenticación, que deja en el contexto la identidad del llamante
+	if *rbacPolicy != "" {
+		if !*requireAuth {
+			log.Fatalf("-rbac needs -require-auth")
+		}
+		policy, err := auth.LoadPolicy(*rbacPolicy)
+		if err != nil {
+			log.Fatalf("failed to load RBAC policy: %v", err)
+		}
+		authz := auth.NewInterceptor(policy.Authorize, auth.HealthAndReflection...)
+		unary = append(unary, authz.UnaryServerInterceptor())
+		stream = append(stream, authz.StreamServerInterceptor())
+	}
+
+	unary = append(unary, resolver.UnaryServerInterceptor(), load.UnaryServerInterceptor(), interceptors.OrderUnaryServerInterceptor)
+	stream = append(stream, resolver.StreamServerInterceptor(), load.StreamServerInterceptor(), interceptors.OrderServerStreamInterceptor)
+
+	if *traces != "" {
+		exporter, err := tracing.NewFileExporter(*traces)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", *traces, err)
+		}
+		defer exporter.Close()
+		tracing.SetExporter(exporter)
+	}
+
+	//Inyección de fallos para probar la resiliencia de los clientes
+	if *faults != "" || *faultMetadata {
+		var rules []interceptors.FaultRule
+		if *faults != "" {
+			rules, err = interceptors.LoadFaultRules(*faults)
+			if err != nil {
+				log.Fatalf("failed to load fault rules: %v", err)
+			}
+		}
+		injector := interceptors.NewFaultInjector(rules, *faultMetadata)
+		unary = append(unary, injector.UnaryServerInterceptor())
+		stream = append(stream, injector.StreamServerInterceptor())
+	}
+
+	//Caché de lecturas. addOrder y updateOrders la invalidan
+	readCache := cache.NewReadCache(cache.New("orders", 1000, 30*time.Second),
+		[]string{"/ecommerce.OrderManagement/getOrder"},
+		[]string{"/ecommerce.OrderManagement/addOrder", "/ecommerce.OrderManagement/updateOrders"},
+		tenant.FromContext)
+	unary = append(unary, readCache.UnaryServerInterceptor())
+	stream = append(stream, readCache.StreamServerInterceptor())
+
 	s := grpc.NewServer(
-		grpc.UnaryInterceptor(interceptors.OrderUnaryServerInterceptor),
-		grpc.StreamInterceptor(interceptors.OrderServerStreamInterceptor))
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...))
 
-	pb.RegisterOrderManagementServer(s, logica.Construye(orderMap))
+	pb.RegisterOrderManagementServer(s, logica.Construye(orders))
 
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
+
+	//Se registra como servicio orders, y se da de baja al parar
+	if *registryAddr != "" {
+		agent, err := registry.Start(*registryAddr, &registry.Instance{Service: "orders", Addr: *advertise, Weight: 1, Zone: *zone}, 10*time.Second)
+		if err != nil {
+			log.Fatalf("failed to connect to registry: %v", err)
+		}
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			agent.Stop()
+			s.GracefulStop()
+		}()
+	}
+
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
 
-func initSampleData() {
-	orderMap["102"] = &pb.Order{Id: "102", Items: []string{"Google Pixel 3A", "Mac Book Pro"}, Destination: "Mountain View, CA", Price: 1800.00}
+func initSampleData(orders *store.Orders) {
+	orders.Put(tenant.Default, &pb.Order{Id: "102", Items: []string{"Google Pixel 3A", "Mac Book Pro"}, Destination: "Mountain View, CA", Price: 1800.00})
 
-	orderMap["103"] = &pb.Order{Id: "103", Items: []string{"Apple Watch S4"}, Destination: "San Jose, CA", Price: 400.00}
+	orders.Put(tenant.Default, &pb.Order{Id: "103", Items: []string{"Apple Watch S4"}, Destination: "San Jose, CA", Price: 400.00})
 
-	orderMap["104"] = &pb.Order{Id: "104", Items: []string{"Google Home Mini", "Google Nest Hub"}, Destination: "Mountain View, CA", Price: 400.00}
+	orders.Put(tenant.Default, &pb.Order{Id: "104", Items: []string{"Google Home Mini", "Google Nest Hub"}, Destination: "Mountain View, CA", Price: 400.00})
 
-	orderMap["105"] = &pb.Order{Id: "105", Items: []string{"Amazon Echo"}, Destination: "San Jose, CA", Price: 30.00}
+	orders.Put(tenant.Default, &pb.Order{Id: "105", Items: []string{"Amazon Echo"}, Destination: "San Jose, CA", Price: 30.00})
 
-	orderMap["106"] = &pb.Order{Id: "106", Items: []string{"Amazon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 30.00}
+	orders.Put(tenant.Default, &pb.Order{Id: "106", Items: []string{"Amazon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 30.00})
 }
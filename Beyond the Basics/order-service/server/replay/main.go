@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "interceptors/servidor/ecommerce" // Registra los tipos del servicio para poder decodificar la grabación
+	"interceptors/servidor/traffic"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	file      = flag.String("file", "traffic.jsonl", "fichero con el tráfico grabado")
+	target    = flag.String("target", "localhost:50051", "servidor al que se reenvía el tráfico")
+	speed     = flag.Float64("speed", 1, "factor de aceleración. 1 reproduce al ritmo original, 0 reproduce las llamadas una detrás de otra sin esperas")
+	unordered = flag.Bool("unordered", false, "compara las respuestas de los streams sin tener en cuenta el orden")
+)
+
+func main() {
+	flag.Parse()
+
+	calls, err := traffic.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("no se puede leer la grabación: %v", err)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Start.Before(calls[j].Start) })
+
+	conn, err := grpc.Dial(*target, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	diffs := 0
+	begin := time.Now()
+	for i, c := range calls {
+		//Respeta el instante de inicio de cada llamada
+		wait(begin, calls[i].Start.Sub(calls[0].Start))
+		wg.Add(1)
+		run := func(n int, c *traffic.Call) {
+			defer wg.Done()
+			report := replay(conn, c)
+			mu.Lock()
+			defer mu.Unlock()
+			if len(report) == 0 {
+				log.Printf("[%d] %s OK", n, c.Method)
+				return
+			}
+			diffs++
+			log.Printf("[%d] %s DIFERENCIAS:\n  %s", n, c.Method, strings.Join(report, "\n  "))
+		}
+		//Sin esperas las llamadas se reproducen en orden, una detrás de otra
+		if *speed <= 0 {
+			run(i, c)
+		} else {
+			go run(i, c)
+		}
+	}
+	wg.Wait()
+	log.Printf("Reproducidas %d llamadas, %d con diferencias", len(calls), diffs)
+}
+
+//wait espera hasta el instante offset, ajustado por la velocidad, medido desde begin
+func wait(begin time.Time, offset time.Duration) {
+	if *speed <= 0 {
+		return
+	}
+	time.Sleep(time.Until(begin.Add(time.Duration(float64(offset) / *speed))))
+}
+
+//replay reenvía una llamada y devuelve las diferencias con la respuesta grabada
+func replay(conn *grpc.ClientConn, c *traffic.Call) []string {
+	md := metadata.MD{}
+	for k, v := range c.Metadata {
+		//Las cabeceras reservadas las pone el propio transporte
+		if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") || k == "content-type" || k == "user-agent" {
+			continue
+		}
+		md[k] = v
+	}
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(context.Background(), md))
+	defer cancel()
+
+	var requests []traffic.Message
+	var replyType string
+	for _, m := range c.Messages {
+		if m.Direction == traffic.Received {
+			requests = append(requests, m)
+		} else {
+			replyType = m.Type
+		}
+	}
+
+	desc := &grpc.StreamDesc{ClientStreams: c.ClientStreams, ServerStreams: c.ServerStreams}
+	stream, err := conn.NewStream(ctx, desc, c.Method)
+	if err != nil {
+		return compare(c, nil, err)
+	}
+
+	//Los mensajes del servidor se reciben en paralelo al envío
+	var got []proto.Message
+	done := make(chan error, 1)
+	go func() {
+		for {
+			reply, err := newReply(replyType)
+			if err != nil {
+				done <- err
+				return
+			}
+			if err := stream.RecvMsg(reply); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				done <- err
+				return
+			}
+			got = append(got, reply)
+		}
+	}()
+
+	begin := time.Now()
+	for _, m := range requests {
+		wait(begin, m.Offset)
+		req, err := m.Decode()
+		if err != nil {
+			return []string{fmt.Sprintf("no se puede decodificar la petición: %v", err)}
+		}
+		if err := stream.SendMsg(req); err != nil {
+			break
+		}
+	}
+	stream.CloseSend()
+	return compare(c, got, <-done)
+}
+
+//newReply crea un mensaje vacío del tipo de respuesta grabado
+func newReply(replyType string) (proto.Message, error) {
+	if replyType == "" {
+		//Sin respuestas grabadas no sabemos el tipo; cualquier mensaje recibido será una diferencia
+		return &empty.Empty{}, nil
+	}
+	return traffic.New(replyType)
+}
+
+//compare compara el estado y las respuestas recibidas con las grabadas
+func compare(c *traffic.Call, got []proto.Message, err error) []string {
+	var report []string
+	code := status.Code(err).String()
+	if code != c.Code {
+		report = append(report, fmt.Sprintf("código: grabado %s, recibido %s (%s)", c.Code, code, status.Convert(err).Message()))
+	}
+
+	var want []proto.Message
+	for _, m := range c.Messages {
+		if m.Direction != traffic.Sent {
+			continue
+		}
+		msg, derr := m.Decode()
+		if derr != nil {
+			return append(report, fmt.Sprintf("no se puede decodificar la respuesta grabada: %v", derr))
+		}
+		want = append(want, msg)
+	}
+
+	if len(want) != len(got) {
+		report = append(report, fmt.Sprintf("mensajes: grabados %d, recibidos %d", len(want), len(got)))
+	}
+	if *unordered {
+		return append(report, compareUnordered(want, got)...)
+	}
+	for i := 0; i < len(want) && i < len(got); i++ {
+		if !proto.Equal(want[i], got[i]) {
+			report = append(report, fmt.Sprintf("mensaje %d:\n    grabado  %v\n    recibido %v", i, want[i], got[i]))
+		}
+	}
+	return report
+}
+
+func compareUnordered(want, got []proto.Message) []string {
+	var report []string
+	used := make([]bool, len(got))
+	for _, w := range want {
+		found := false
+		for j, g := range got {
+			if !used[j] && proto.Equal(w, g) {
+				used[j], found = true, true
+				break
+			}
+		}
+		if !found {
+			report = append(report, fmt.Sprintf("no recibido: %v", w))
+		}
+	}
+	for j, g := range got {
+		if !used[j] {
+			report = append(report, fmt.Sprintf("no grabado: %v", g))
+		}
+	}
+	return report
+}
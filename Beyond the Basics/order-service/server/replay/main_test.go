@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	pb "interceptors/servidor/ecommerce"
+	"interceptors/servidor/traffic"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func shipment(id string) *pb.CombinedShipment {
+	return &pb.CombinedShipment{Id: id, Status: "Processed!"}
+}
+
+//recorded devuelve una llamada grabada que terminó con code y envió replies
+func recorded(t *testing.T, code string, replies ...proto.Message) *traffic.Call {
+	t.Helper()
+	c := &traffic.Call{Method: "/ecommerce.OrderManagement/processOrders", Code: code}
+	for _, r := range replies {
+		m, err := traffic.NewMessage(traffic.Sent, 0, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Messages = append(c.Messages, m)
+	}
+	return c
+}
+
+func TestCompare(t *testing.T) {
+	c := recorded(t, "OK", shipment("cmb-1"), shipment("cmb-2"))
+	cases := []struct {
+		name  string
+		got   []proto.Message
+		err   error
+		diffs int
+	}{
+		{"iguales", []proto.Message{shipment("cmb-1"), shipment("cmb-2")}, nil, 0},
+		{"otro orden", []proto.Message{shipment("cmb-2"), shipment("cmb-1")}, nil, 2},
+		{"distinto contenido", []proto.Message{shipment("cmb-1"), shipment("cmb-3")}, nil, 1},
+		{"falta un mensaje", []proto.Message{shipment("cmb-1")}, nil, 1},
+		{"otro código", []proto.Message{shipment("cmb-1"), shipment("cmb-2")}, status.Error(codes.Unavailable, "caído"), 1},
+	}
+	for _, c2 := range cases {
+		if report := compare(c, c2.got, c2.err); len(report) != c2.diffs {
+			t.Errorf("%s: %d diferencias, esperadas %d: %v", c2.name, len(report), c2.diffs, report)
+		}
+	}
+
+	//Una llamada grabada con error se compara con el código recibido
+	if report := compare(recorded(t, "NotFound"), nil, status.Error(codes.NotFound, "no existe")); len(report) != 0 {
+		t.Errorf("mismo error: %v", report)
+	}
+	if report := compare(recorded(t, "NotFound"), nil, nil); len(report) != 1 {
+		t.Errorf("error grabado y respuesta OK: %v", report)
+	}
+}
+
+func TestCompareUnordered(t *testing.T) {
+	defer func(u bool) { *unordered = u }(*unordered)
+	*unordered = true
+
+	c := recorded(t, "OK", shipment("cmb-1"), shipment("cmb-2"), shipment("cmb-2"))
+	if report := compare(c, []proto.Message{shipment("cmb-2"), shipment("cmb-1"), shipment("cmb-2")}, nil); len(report) != 0 {
+		t.Errorf("mismos mensajes en otro orden: %v", report)
+	}
+
+	//Cada mensaje recibido solo cuenta una vez, aunque se repita en la grabación
+	want := []proto.Message{shipment("cmb-1"), shipment("cmb-2"), shipment("cmb-2")}
+	got := []proto.Message{shipment("cmb-2"), shipment("cmb-1"), shipment("cmb-1")}
+	report := compareUnordered(want, got)
+	if len(report) != 2 {
+		t.Fatalf("%d diferencias, esperadas 2: %v", len(report), report)
+	}
+	if report[0] != "no recibido: "+shipment("cmb-2").String() || report[1] != "no grabado: "+shipment("cmb-1").String() {
+		t.Errorf("diferencias %v", report)
+	}
+}
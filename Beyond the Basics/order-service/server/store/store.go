@@ -0,0 +1,88 @@
+package store
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+
+	tn "comun/tenant"
+	pb "interceptors/servidor/ecommerce"
+)
+
+//ErrQuotaExceeded el tenant ha alcanzado su cuota de órdenes
+var ErrQuotaExceeded = errors.New("cuota de órdenes agotada")
+
+//Métricas por tenant, publicadas en /debug/vars. tn.AddMetric limita el número de tenants de cada una
+var (
+	orderMetrics = expvar.NewMap("tenant_orders")
+	quotaMetrics = expvar.NewMap("tenant_quota_rejections")
+)
+
+//Orders almacén de órdenes, separado por tenant. Un tenant no ve las órdenes de otro
+type Orders struct {
+	mu      sync.RWMutex
+	tenants map[string]map[string]*pb.Order
+	//Máximo de órdenes por tenant. 0 sin límite
+	quota  int
+	quotas map[string]int
+}
+
+//NewOrders crea el almacén. quota es el máximo de órdenes de cada tenant, salvo los que tengan una cuota propia en quotas
+func NewOrders(quota int, quotas map[string]int) *Orders {
+	return &Orders{tenants: make(map[string]map[string]*pb.Order), quota: quota, quotas: quotas}
+}
+
+func (o *Orders) quotaOf(tenant string) int {
+	if q, ok := o.quotas[tenant]; ok {
+		return q
+	}
+	return o.quota
+}
+
+//Get busca una orden del tenant
+func (o *Orders) Get(tenant, id string) (*pb.Order, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	ord, ok := o.tenants[tenant][id]
+	return ord, ok
+}
+
+//Put guarda o actualiza una orden del tenant. Las órdenes nuevas cuentan para la cuota
+func (o *Orders) Put(tenant string, order *pb.Order) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	orders, ok := o.tenants[tenant]
+	if !ok {
+		orders = make(map[string]*pb.Order)
+		o.tenants[tenant] = orders
+	}
+	if _, exists := orders[order.Id]; !exists {
+		if q := o.quotaOf(tenant); q > 0 && len(orders) >= q {
+			tn.AddMetric(quotaMetrics, tenant, 1)
+			return ErrQuotaExceeded
+		}
+		tn.AddMetric(orderMetrics, tenant, 1)
+	}
+	orders[order.Id] = order
+	return nil
+}
+
+//Search devuelve las órdenes del tenant que cumplen match
+func (o *Orders) Search(tenant string, match func(*pb.Order) bool) []*pb.Order {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	var found []*pb.Order
+	for _, ord := range o.tenants[tenant] {
+		if match(ord) {
+			found = append(found, ord)
+		}
+	}
+	return found
+}
+
+//Count número de órdenes del tenant
+func (o *Orders) Count(tenant string) int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return len(o.tenants[tenant])
+}
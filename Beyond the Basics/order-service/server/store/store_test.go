@@ -0,0 +1,93 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	pb "interceptors/servidor/ecommerce"
+)
+
+func TestOrdersTenantIsolation(t *testing.T) {
+	o := NewOrders(0, nil)
+	o.Put("acme", &pb.Order{Id: "1", Destination: "acme"})
+	o.Put("globex", &pb.Order{Id: "1", Destination: "globex"})
+	o.Put("globex", &pb.Order{Id: "2", Destination: "globex"})
+
+	//El mismo id en dos tenants son dos órdenes distintas
+	if ord, ok := o.Get("acme", "1"); !ok || ord.Destination != "acme" {
+		t.Errorf("acme lee %v", ord)
+	}
+	if ord, ok := o.Get("globex", "1"); !ok || ord.Destination != "globex" {
+		t.Errorf("globex lee %v", ord)
+	}
+	//Una orden de otro tenant no existe
+	if _, ok := o.Get("acme", "2"); ok {
+		t.Error("acme lee una orden de globex")
+	}
+	if _, ok := o.Get("initech", "1"); ok {
+		t.Error("un tenant sin órdenes lee una orden")
+	}
+
+	all := func(*pb.Order) bool { return true }
+	if found := o.Search("acme", all); len(found) != 1 || found[0].Destination != "acme" {
+		t.Errorf("acme encuentra %v", found)
+	}
+	if found := o.Search("globex", all); len(found) != 2 {
+		t.Errorf("globex encuentra %d órdenes, esperadas 2", len(found))
+	}
+	if n := o.Count("acme"); n != 1 {
+		t.Errorf("acme tiene %d órdenes, esperada 1", n)
+	}
+}
+
+func TestOrdersQuota(t *testing.T) {
+	o := NewOrders(2, map[string]int{"acme": 3, "ilimitado": 0})
+	put := func(tenant string, n int) (ok int) {
+		for i := 0; i < n; i++ {
+			if err := o.Put(tenant, &pb.Order{Id: fmt.Sprint(i)}); err == nil {
+				ok++
+			} else if err != ErrQuotaExceeded {
+				t.Fatalf("%s: %v", tenant, err)
+			}
+		}
+		return ok
+	}
+
+	//La cuota por defecto, las cuotas propias y la cuota 0, que es sin límite
+	for tenant, want := range map[string]int{"globex": 2, "acme": 3, "ilimitado": 10} {
+		if got := put(tenant, 10); got != want {
+			t.Errorf("%s guarda %d órdenes, esperadas %d", tenant, got, want)
+		}
+		if n := o.Count(tenant); n != want {
+			t.Errorf("%s tiene %d órdenes, esperadas %d", tenant, n, want)
+		}
+	}
+
+	//Actualizar una orden existente no consume cuota
+	if err := o.Put("globex", &pb.Order{Id: "0", Destination: "San Jose, CA"}); err != nil {
+		t.Errorf("actualizar una orden con la cuota agotada: %v", err)
+	}
+	if ord, _ := o.Get("globex", "0"); ord.Destination != "San Jose, CA" {
+		t.Errorf("orden actualizada %v", ord)
+	}
+}
+
+func TestOrdersQuotaConcurrent(t *testing.T) {
+	o := NewOrders(50, nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				o.Put("acme", &pb.Order{Id: fmt.Sprint(g, "-", i)})
+			}
+		}(g)
+	}
+	wg.Wait()
+	//Las altas concurrentes no pueden pasarse de la cuota
+	if n := o.Count("acme"); n != 50 {
+		t.Errorf("acme tiene %d órdenes con cuota 50", n)
+	}
+}
@@ -0,0 +1,158 @@
+package traffic
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+//Dirección de un mensaje, vista desde el servidor
+const (
+	//Received mensaje recibido del cliente
+	Received = "recv"
+	//Sent mensaje enviado al cliente
+	Sent = "send"
+)
+
+//Message un mensaje de la llamada
+type Message struct {
+	//Received o Sent
+	Direction string `json:"direction"`
+	//Tiempo transcurrido desde el inicio de la llamada
+	Offset time.Duration `json:"offset"`
+	//Nombre completo del tipo protobuf, por ejemplo ecommerce.Order
+	Type string `json:"type"`
+	//Mensaje codificado con protojson
+	Payload json.RawMessage `json:"payload"`
+}
+
+//Call una llamada grabada
+type Call struct {
+	//Método completo, tal y como viaja en la petición. Por ejemplo /ecommerce.OrderManagement/addOrder
+	Method        string              `json:"method"`
+	ClientStreams bool                `json:"client_streams"`
+	ServerStreams bool                `json:"server_streams"`
+	Start         time.Time           `json:"start"`
+	Duration      time.Duration       `json:"duration"`
+	Metadata      map[string][]string `json:"metadata"`
+	Messages      []Message           `json:"messages"`
+	Code          string              `json:"code"`
+	Status        string              `json:"status,omitempty"`
+}
+
+//NewMessage codifica un mensaje protobuf
+func NewMessage(direction string, offset time.Duration, m interface{}) (Message, error) {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return Message{}, fmt.Errorf("el mensaje %T no es un mensaje protobuf", m)
+	}
+	msg := proto.MessageV2(pm)
+	payload, err := protojson.Marshal(msg)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Direction: direction,
+		Offset:    offset,
+		Type:      string(msg.ProtoReflect().Descriptor().FullName()),
+		Payload:   payload,
+	}, nil
+}
+
+//New crea un mensaje vacío del tipo indicado. El tipo tiene que estar registrado, es decir, su paquete tiene que estar importado
+func New(typeName string) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(typeName))
+	if err != nil {
+		return nil, err
+	}
+	return proto.MessageV1(mt.New().Interface()), nil
+}
+
+//Decode reconstruye el mensaje protobuf
+func (m Message) Decode() (proto.Message, error) {
+	msg, err := New(m.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := protojson.Unmarshal(m.Payload, proto.MessageV2(msg)); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+//Writer escribe las llamadas grabadas en formato JSON, una por línea
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+//NewWriter crea un Writer
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, enc: json.NewEncoder(w)}
+}
+
+//Create crea el fichero de grabación, o lo abre para añadir si ya existe
+func Create(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(f), nil
+}
+
+//Write escribe una llamada
+func (w *Writer) Write(c *Call) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(c)
+}
+
+//Close cierra el fichero de grabación
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+//Read lee todas las llamadas de una grabación
+func Read(r io.Reader) ([]*Call, error) {
+	var calls []*Call
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		c := &Call{}
+		if err := json.Unmarshal(sc.Bytes(), c); err != nil {
+			return nil, fmt.Errorf("línea %d: %v", line, err)
+		}
+		calls = append(calls, c)
+	}
+	return calls, sc.Err()
+}
+
+//ReadFile lee todas las llamadas de un fichero de grabación
+func ReadFile(path string) ([]*Call, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
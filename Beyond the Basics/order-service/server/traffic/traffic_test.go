@@ -0,0 +1,114 @@
+package traffic
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	pb "interceptors/servidor/ecommerce"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	order := &pb.Order{Id: "102", Items: []string{"Google Pixel 3A", "Mac Book Pro"}, Destination: "Mountain View, CA", Price: 1800}
+	m, err := NewMessage(Sent, 5*time.Millisecond, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != "ecommerce.Order" || m.Direction != Sent || m.Offset != 5*time.Millisecond {
+		t.Errorf("mensaje %+v", m)
+	}
+	got, err := m.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, order) {
+		t.Errorf("decodificado %v, esperado %v", got, order)
+	}
+
+	//Los tipos bien conocidos también se registran
+	if m, err = NewMessage(Received, 0, &wrappers.StringValue{Value: "106"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.Decode(); err != nil || !proto.Equal(got, &wrappers.StringValue{Value: "106"}) {
+		t.Errorf("decodificado %v: %v", got, err)
+	}
+}
+
+func TestMessageErrors(t *testing.T) {
+	if _, err := NewMessage(Sent, 0, "no es protobuf"); err == nil {
+		t.Error("se codifica un mensaje que no es protobuf")
+	}
+	if _, err := (Message{Type: "ecommerce.NoExiste", Payload: []byte("{}")}).Decode(); err == nil {
+		t.Error("se decodifica un tipo que no está registrado")
+	}
+	if _, err := (Message{Type: "ecommerce.Order", Payload: []byte(`{"price": "caro"}`)}).Decode(); err == nil {
+		t.Error("se decodifica un mensaje inválido")
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	start := time.Date(2020, 11, 1, 10, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	var want []*Call
+	for i, id := range []string{"102", "103"} {
+		req, err := NewMessage(Received, 0, &wrappers.StringValue{Value: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Call{
+			Method:   "/ecommerce.OrderManagement/getOrder",
+			Start:    start.Add(time.Duration(i) * time.Second),
+			Duration: time.Millisecond,
+			Metadata: map[string][]string{"x-request-id": {id}},
+			Messages: []Message{req},
+			Code:     "OK",
+		}
+		if err := w.Write(c); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, c)
+	}
+	//Una línea por llamada
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Fatalf("%d líneas, esperadas 2", n)
+	}
+
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d llamadas leídas, esperadas %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Method != w.Method || !g.Start.Equal(w.Start) || g.Duration != w.Duration || g.Code != w.Code ||
+			g.Metadata["x-request-id"][0] != w.Metadata["x-request-id"][0] || len(g.Messages) != 1 {
+			t.Errorf("llamada %d leída %+v, esperada %+v", i, g, w)
+			continue
+		}
+		m, err := g.Messages[0].Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := m.(*wrappers.StringValue).Value; v != w.Metadata["x-request-id"][0] {
+			t.Errorf("llamada %d: petición %q", i, v)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	//Las líneas vacías se ignoran
+	calls, err := Read(strings.NewReader("{\"method\": \"/a\"}\n\n{\"method\": \"/b\"}\n"))
+	if err != nil || len(calls) != 2 {
+		t.Errorf("%d llamadas: %v", len(calls), err)
+	}
+	if _, err := Read(strings.NewReader("{\"method\": \"/a\"}\nno es json\n")); err == nil || !strings.Contains(err.Error(), "línea 2") {
+		t.Errorf("error %v, esperado en la línea 2", err)
+	}
+}
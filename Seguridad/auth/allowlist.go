@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// Allowlist admits the client certificates that match one of its rules:
+//
+//	{
+//	  "allow": [
+//	    {"spiffeId": "spiffe://productinfo.local/client/*", "methods": ["/ecommerce.ProductInfo/"]},
+//	    {"dnsName": "publisher.productinfo.local"},
+//	    {"commonName": "localhost", "methods": ["/ecommerce.ProductInfo/getProduct"]}
+//	  ]
+//	}
+//
+// A rule matches a peer when all its fields match. A spiffeId ending in "/*"
+// takes in every ID under that path. A rule without methods allows every
+// method; otherwise they are full methods, services ending in "/" or "*", as in
+// a Policy. Everything else is denied.
+type Allowlist struct {
+	Rules []AllowRule `json:"allow"`
+}
+
+// AllowRule is a rule of an Allowlist. It needs at least one of the identity
+// fields.
+type AllowRule struct {
+	SPIFFEID   string   `json:"spiffeId,omitempty"`
+	DNSName    string   `json:"dnsName,omitempty"`
+	CommonName string   `json:"commonName,omitempty"`
+	Methods    []string `json:"methods,omitempty"`
+}
+
+// LoadAllowlist reads an allowlist from a JSON file.
+func LoadAllowlist(path string) (*Allowlist, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a Allowlist
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := a.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &a, nil
+}
+
+// validate catches the rules that would match everyone or no one.
+func (a *Allowlist) validate() error {
+	for i, r := range a.Rules {
+		if r.SPIFFEID == "" && r.DNSName == "" && r.CommonName == "" {
+			return fmt.Errorf("rule %d: needs a spiffeId, a dnsName or a commonName", i)
+		}
+		if r.SPIFFEID != "" && !strings.HasPrefix(r.SPIFFEID, "spiffe://") {
+			return fmt.Errorf("rule %d: %q is not a SPIFFE ID", i, r.SPIFFEID)
+		}
+		for _, m := range r.Methods {
+			if !validMethod(m) {
+				return fmt.Errorf("rule %d: %q is not a method, a service ending in / or *", i, m)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule takes in the peer, whatever the method.
+func (r *AllowRule) matches(p *PeerIdentity) bool {
+	if r.SPIFFEID != "" {
+		if strings.HasSuffix(r.SPIFFEID, "/*") {
+			if !strings.HasPrefix(p.SPIFFEID, strings.TrimSuffix(r.SPIFFEID, "*")) {
+				return false
+			}
+		} else if r.SPIFFEID != p.SPIFFEID {
+			return false
+		}
+	}
+	if r.DNSName != "" && !contains(p.DNSNames, r.DNSName) {
+		return false
+	}
+	if r.CommonName != "" && r.CommonName != p.CommonName {
+		return false
+	}
+	return true
+}
+
+// Allowed returns the index of the first rule that allows the peer to call
+// fullMethod, if any.
+func (a *Allowlist) Allowed(p *PeerIdentity, fullMethod string) (int, bool) {
+	for i := range a.Rules {
+		r := &a.Rules[i]
+		if !r.matches(p) {
+			continue
+		}
+		if len(r.Methods) == 0 {
+			return i, true
+		}
+		for _, m := range r.Methods {
+			if matchMethod(m, fullMethod) {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Authorize is a Func for NewInterceptor that checks the client certificate
+// left in the context by TLSPeer against the allowlist. Every call, allowed or
+// denied, is written to the audit log with the identity of the peer. Denied
+// calls get PermissionDenied with an ErrorInfo.
+func (a *Allowlist) Authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	p, ok := PeerFromContext(ctx)
+	if !ok {
+		log.Printf("audit: deny %s: call without client certificate", fullMethod)
+		return nil, denied(ReasonNoIdentity, "call not authenticated", map[string]string{"method": fullMethod})
+	}
+	i, ok := a.Allowed(p, fullMethod)
+	if !ok {
+		log.Printf("audit: deny %s peer=%q %s", fullMethod, p.Name(), auditFields(p))
+		return nil, denied(ReasonPeerNotAllowed, "peer not allowed", map[string]string{"method": fullMethod})
+	}
+	log.Printf("audit: allow %s peer=%q rule=%d %s", fullMethod, p.Name(), i, auditFields(p))
+	return ctx, nil
+}
+
+// auditFields are the fields that identify the certificate of the peer in the
+// audit log.
+func auditFields(p *PeerIdentity) string {
+	return fmt.Sprintf("subject=%q dns=%v uris=%v issuer=%q serial=%s", p.Subject, p.DNSNames, p.URIs, p.Issuer, p.SerialNumber)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
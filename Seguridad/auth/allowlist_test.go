@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const getProduct = "/ecommerce.ProductInfo/getProduct"
+
+var testAllowlist = &Allowlist{Rules: []AllowRule{
+	{SPIFFEID: "spiffe://productinfo.local/client/*", Methods: []string{"/ecommerce.ProductInfo/"}},
+	{SPIFFEID: "spiffe://productinfo.local/admin", Methods: []string{"*"}},
+	{DNSName: "publisher.productinfo.local"},
+	{CommonName: "localhost", Methods: []string{getProduct}},
+	{CommonName: "reporter", DNSName: "reporter.productinfo.local", Methods: []string{getOrder}},
+}}
+
+func TestAllowlistValidate(t *testing.T) {
+	if err := testAllowlist.validate(); err != nil {
+		t.Fatalf("valid allowlist: %v", err)
+	}
+	cases := []struct {
+		name string
+		rule AllowRule
+		want string
+	}{
+		{"without identity fields", AllowRule{Methods: []string{"*"}}, "needs a spiffeId"},
+		{"not a SPIFFE ID", AllowRule{SPIFFEID: "https://productinfo.local/client"}, "not a SPIFFE ID"},
+		{"bad method", AllowRule{CommonName: "localhost", Methods: []string{"getProduct"}}, "not a method"},
+	}
+	for _, c := range cases {
+		a := &Allowlist{Rules: []AllowRule{{CommonName: "ok"}, c.rule}}
+		err := a.validate()
+		if err == nil || !strings.Contains(err.Error(), c.want) || !strings.Contains(err.Error(), "rule 1") {
+			t.Errorf("%s: %v, want an error with rule 1 and %s", c.name, err, c.want)
+		}
+	}
+}
+
+func TestAllowlistAllowed(t *testing.T) {
+	cases := []struct {
+		name   string
+		peer   *PeerIdentity
+		method string
+		rule   int
+	}{
+		{"SPIFFE prefix", &PeerIdentity{SPIFFEID: "spiffe://productinfo.local/client/publisher"}, getProduct, 0},
+		{"SPIFFE prefix, deeper path", &PeerIdentity{SPIFFEID: "spiffe://productinfo.local/client/eu/publisher"}, getProduct, 0},
+		{"SPIFFE prefix, other service", &PeerIdentity{SPIFFEID: "spiffe://productinfo.local/client/publisher"}, getOrder, -1},
+		// The prefix ends at the slash: client2 is not under client.
+		{"SPIFFE prefix is a path", &PeerIdentity{SPIFFEID: "spiffe://productinfo.local/client2/publisher"}, getProduct, -1},
+		{"SPIFFE prefix, other trust domain", &PeerIdentity{SPIFFEID: "spiffe://other.local/client/publisher"}, getProduct, -1},
+		{"exact SPIFFE ID", &PeerIdentity{SPIFFEID: "spiffe://productinfo.local/admin"}, getOrder, 1},
+		{"exact SPIFFE ID, longer", &PeerIdentity{SPIFFEID: "spiffe://productinfo.local/admin/x"}, getOrder, -1},
+		// A rule without methods allows every method.
+		{"DNS name", &PeerIdentity{DNSNames: []string{"localhost", "publisher.productinfo.local"}}, getOrder, 2},
+		{"common name", &PeerIdentity{CommonName: "localhost"}, getProduct, 3},
+		{"common name, other method", &PeerIdentity{CommonName: "localhost"}, "/ecommerce.ProductInfo/addProduct", -1},
+		// All the fields of a rule have to match.
+		{"all fields", &PeerIdentity{CommonName: "reporter", DNSNames: []string{"reporter.productinfo.local"}}, getOrder, 4},
+		{"one field of two", &PeerIdentity{CommonName: "reporter"}, getOrder, -1},
+		{"no identity", &PeerIdentity{}, getProduct, -1},
+	}
+	for _, c := range cases {
+		i, ok := testAllowlist.Allowed(c.peer, c.method)
+		if c.rule < 0 && ok {
+			t.Errorf("%s: allowed by rule %d", c.name, i)
+		}
+		if c.rule >= 0 && (!ok || i != c.rule) {
+			t.Errorf("%s: Allowed = %d, %v; want rule %d", c.name, i, ok, c.rule)
+		}
+	}
+}
+
+func TestAllowlistAuthorize(t *testing.T) {
+	ctx := context.WithValue(context.Background(), peerKey{}, &PeerIdentity{CommonName: "localhost"})
+	if got, err := testAllowlist.Authorize(ctx, getProduct); err != nil || got != ctx {
+		t.Fatalf("allowed call: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		ctx    context.Context
+		reason string
+	}{
+		{"without certificate", context.Background(), ReasonNoIdentity},
+		{"peer not allowed", ctx, ReasonPeerNotAllowed},
+	}
+	for _, c := range cases {
+		_, err := testAllowlist.Authorize(c.ctx, getOrder)
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%s: %v, want PermissionDenied", c.name, err)
+			continue
+		}
+		if info := errorInfo(t, err); info.Reason != c.reason || info.Metadata["method"] != getOrder {
+			t.Errorf("%s: ErrorInfo %v, want reason %s", c.name, info, c.reason)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const healthCheck = "/grpc.health.v1.Health/Check"
+
+// tokenAuth accepts the bearer token "valid" and returns the identity "acme".
+// Other tokens fail with a plain error, and "expired" with a gRPC status.
+func tokenAuth(ctx context.Context, fullMethod string) (context.Context, error) {
+	token, err := BearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case "valid":
+		return NewContext(ctx, &Identity{Subject: "acme"}), nil
+	case "expired":
+		return nil, status.Error(codes.PermissionDenied, "token expired")
+	}
+	return nil, errors.New("unknown token")
+}
+
+// transportStream gives grpc.Method the method of the call, as the server does.
+type transportStream struct{ method string }
+
+func (s transportStream) Method() string                  { return s.method }
+func (s transportStream) SetHeader(md metadata.MD) error  { return nil }
+func (s transportStream) SendHeader(md metadata.MD) error { return nil }
+func (s transportStream) SetTrailer(md metadata.MD) error { return nil }
+
+// callContext returns the context of a call to method with the given
+// authorization, or without metadata if it is empty.
+func callContext(method, authorization string) context.Context {
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), transportStream{method})
+	if authorization == "" {
+		return ctx
+	}
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", authorization))
+}
+
+// unaryCall runs a unary call through the interceptor and returns the subject
+// the handler sees, "" for none, or the error.
+func unaryCall(i *Interceptor, method, authorization string) (string, error) {
+	subject := ""
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if id, ok := FromContext(ctx); ok {
+			subject = id.Subject
+		}
+		return "ok", nil
+	}
+	_, err := i.UnaryServerInterceptor()(callContext(method, authorization), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	return subject, err
+}
+
+// testStream is a server stream with a fixed context.
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+
+// streamCall does the same as unaryCall for a stream.
+func streamCall(i *Interceptor, method, authorization string) (string, error) {
+	subject := ""
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		if id, ok := FromContext(ss.Context()); ok {
+			subject = id.Subject
+		}
+		return nil
+	}
+	ss := &testStream{ctx: callContext(method, authorization)}
+	err := i.StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{FullMethod: method, IsServerStream: true}, handler)
+	return subject, err
+}
+
+var calls = map[string]func(i *Interceptor, method, authorization string) (string, error){
+	"unary":  unaryCall,
+	"stream": streamCall,
+}
+
+func TestInterceptor(t *testing.T) {
+	i := NewInterceptor(tokenAuth)
+	cases := []struct {
+		name          string
+		authorization string
+		code          codes.Code
+		subject       string
+	}{
+		{"valid token", "Bearer valid", codes.OK, "acme"},
+		// Plain errors become Unauthenticated, without their text.
+		{"unknown token", "Bearer forged", codes.Unauthenticated, ""},
+		{"not a bearer token", "Basic YWNtZTpzZWNyZXQ=", codes.Unauthenticated, ""},
+		{"no authorization", "", codes.InvalidArgument, ""},
+		// Errors with a status keep it.
+		{"status error", "Bearer expired", codes.PermissionDenied, ""},
+	}
+	for kind, call := range calls {
+		for _, c := range cases {
+			subject, err := call(i, getOrder, c.authorization)
+			if status.Code(err) != c.code {
+				t.Errorf("%s, %s: %v, want %v", kind, c.name, err, c.code)
+			}
+			if c.code == codes.Unauthenticated && status.Convert(err).Message() != "invalid credentials" {
+				t.Errorf("%s, %s: message %q leaks the reason", kind, c.name, status.Convert(err).Message())
+			}
+			if subject != c.subject {
+				t.Errorf("%s, %s: handler sees subject %q, want %q", kind, c.name, subject, c.subject)
+			}
+		}
+	}
+}
+
+func TestInterceptorSkip(t *testing.T) {
+	i := NewInterceptor(tokenAuth, addOrder, "/grpc.health.v1.Health/")
+	cases := []struct {
+		method string
+		open   bool
+	}{
+		{addOrder, true},
+		{healthCheck, true},
+		{"/grpc.health.v1.Health/Watch", true},
+		{getOrder, false},
+		// A full method only skips itself, not the methods it prefixes.
+		{addOrder + "s", false},
+		// A service prefix needs the trailing slash.
+		{"/grpc.health.v1.HealthCheck/Check", false},
+	}
+	for kind, call := range calls {
+		for _, c := range cases {
+			_, err := call(i, c.method, "")
+			if open := err == nil; open != c.open {
+				t.Errorf("%s, %s: %v, want open=%v", kind, c.method, err, c.open)
+			}
+		}
+	}
+
+	// Skipped methods do not authenticate, even with credentials.
+	if subject, err := unaryCall(i, healthCheck, "Bearer forged"); err != nil || subject != "" {
+		t.Errorf("skipped method with a bad token: %v, subject %q", err, subject)
+	}
+}
+
+func TestInterceptorUsesWireMethod(t *testing.T) {
+	// Old generated code puts the Go name in info.FullMethod; the interceptor
+	// must use the method the client called.
+	i := NewInterceptor(tokenAuth, getOrder)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	info := &grpc.UnaryServerInfo{FullMethod: "/ecommerce.OrderManagement/GetOrder"}
+	if _, err := i.UnaryServerInterceptor()(callContext(getOrder, ""), nil, info, handler); err != nil {
+		t.Errorf("skipped method by its wire name: %v", err)
+	}
+}
+
+func TestOptional(t *testing.T) {
+	i := NewInterceptor(Optional(tokenAuth))
+	for kind, call := range calls {
+		// Anonymous calls go through without identity.
+		if subject, err := call(i, getOrder, ""); err != nil || subject != "" {
+			t.Errorf("%s, anonymous: %v, subject %q", kind, err, subject)
+		}
+		if subject, err := call(i, getOrder, "Bearer valid"); err != nil || subject != "acme" {
+			t.Errorf("%s, valid token: %v, subject %q", kind, err, subject)
+		}
+		// Bad credentials are rejected, not taken as anonymous.
+		if _, err := call(i, getOrder, "Bearer forged"); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s, unknown token: %v, want Unauthenticated", kind, err)
+		}
+	}
+}
+
+func TestMethodIn(t *testing.T) {
+	methods := []string{addOrder, "/grpc.health.v1.Health/"}
+	cases := map[string]bool{
+		addOrder:                            true,
+		healthCheck:                         true,
+		getOrder:                            false,
+		addOrder + "s":                      false,
+		"/grpc.health.v1.HealthCheck/Check": false,
+		"/grpc.health.v1.Health":            false,
+	}
+	for m, want := range cases {
+		if got := MethodIn(methods, m); got != want {
+			t.Errorf("MethodIn(%q) = %v, want %v", m, got, want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module seguridad/auth
+
+go 1.15
+
+require (
+	github.com/golang/protobuf v1.4.3
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	google.golang.org/grpc v1.33.1
+	google.golang.org/protobuf v1.25.0
+)
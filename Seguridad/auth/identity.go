@@ -0,0 +1,29 @@
+package auth
+
+import "context"
+
+// Identity is the authenticated caller, whatever the credentials: the claims
+// of a JWT, a basic-auth user or a client certificate. Authentication functions
+// put it in the context and authorization reads it from there.
+type Identity struct {
+	// Subject names the caller: the "sub" claim, the user name or the subject of
+	// the certificate.
+	Subject string
+	// Roles of the caller, from the "roles" claim, the groups of the user or
+	// the organizational units of the certificate.
+	Roles []string
+}
+
+type identityKey struct{}
+
+// NewContext returns a context with the identity of the caller.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity of the caller, if the call was
+// authenticated.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errMissingMetadata      = status.Error(codes.InvalidArgument, "missing metadata")
+	errMissingAuthorization = errors.New("missing authorization")
+)
+
+// authorization returns the authorization header of the call. The keys within
+// metadata.MD are normalized to lowercase.
+// See: https://godoc.org/google.golang.org/grpc/metadata#New
+func authorization(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", errMissingMetadata
+	}
+	values := md.Get("authorization")
+	if len(values) < 1 {
+		return "", errMissingAuthorization
+	}
+	return values[0], nil
+}
+
+// BearerToken returns the bearer token of the call.
+func BearerToken(ctx context.Context) (string, error) {
+	a, err := authorization(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(a, "Bearer ") {
+		return "", errors.New("authorization is not a bearer token")
+	}
+	return strings.TrimPrefix(a, "Bearer "), nil
+}
+
+// BasicCredentials returns the user name and password of the call.
+func BasicCredentials(ctx context.Context) (user, password string, err error) {
+	a, err := authorization(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(a, "Basic ") {
+		return "", "", errors.New("authorization is not basic")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(a, "Basic "))
+	if err != nil {
+		return "", "", errors.New("malformed basic credentials")
+	}
+	user, password = string(b), ""
+	if i := strings.IndexByte(user, ':'); i >= 0 {
+		user, password = user[:i], user[i+1:]
+	}
+	return user, password, nil
+}
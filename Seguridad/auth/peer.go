@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerIdentity is the client certificate of a call, already verified by the
+// TLS handshake against the CAs of the server.
+type PeerIdentity struct {
+	// Subject is the distinguished name of the certificate, like
+	// "CN=localhost,OU=Publisher,O=O'Reilly Media".
+	Subject             string
+	CommonName          string
+	OrganizationalUnits []string
+	// Subject alternative names.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []string
+	URIs           []string
+	// SPIFFEID is the URI SAN of a SPIFFE certificate, like
+	// "spiffe://productinfo.local/client/publisher". Empty if there is none.
+	SPIFFEID string
+	// Issuer, serial number and expiry identify the certificate in the audit
+	// log.
+	Issuer       string
+	SerialNumber string
+	NotAfter     time.Time
+}
+
+// Name is the best name of the peer: the SPIFFE ID, the first URI SAN or the
+// common name, in that order.
+func (p *PeerIdentity) Name() string {
+	switch {
+	case p.SPIFFEID != "":
+		return p.SPIFFEID
+	case len(p.URIs) > 0:
+		return p.URIs[0]
+	}
+	return p.CommonName
+}
+
+// NewPeerIdentity returns the identity of a verified certificate. It fails if
+// the certificate has a malformed SPIFFE ID, or a SPIFFE ID with other URI SANs:
+// a SPIFFE certificate has exactly one.
+func NewPeerIdentity(cert *x509.Certificate) (*PeerIdentity, error) {
+	p := &PeerIdentity{
+		Subject:             cert.Subject.String(),
+		CommonName:          cert.Subject.CommonName,
+		OrganizationalUnits: cert.Subject.OrganizationalUnit,
+		DNSNames:            cert.DNSNames,
+		EmailAddresses:      cert.EmailAddresses,
+		Issuer:              cert.Issuer.String(),
+		SerialNumber:        cert.SerialNumber.Text(16),
+		NotAfter:            cert.NotAfter,
+	}
+	for _, ip := range cert.IPAddresses {
+		p.IPAddresses = append(p.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		p.URIs = append(p.URIs, u.String())
+		if u.Scheme != "spiffe" {
+			continue
+		}
+		if err := checkSPIFFEID(u); err != nil {
+			return nil, err
+		}
+		p.SPIFFEID = u.String()
+	}
+	if p.SPIFFEID != "" && len(p.URIs) != 1 {
+		return nil, errors.New("SPIFFE certificate with more than one URI SAN")
+	}
+	return p, nil
+}
+
+// checkSPIFFEID checks the form of a SPIFFE ID: spiffe://trust-domain/path,
+// without port, user, query or fragment.
+func checkSPIFFEID(u *url.URL) error {
+	switch {
+	case u.Host == "" || u.Port() != "" || u.User != nil:
+		return fmt.Errorf("SPIFFE ID %q: bad trust domain", u)
+	case u.RawQuery != "" || u.Fragment != "":
+		return fmt.Errorf("SPIFFE ID %q: query or fragment not allowed", u)
+	case u.Host != strings.ToLower(u.Host):
+		return fmt.Errorf("SPIFFE ID %q: trust domain must be lowercase", u)
+	}
+	return nil
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the identity of the client certificate of the call,
+// if the call was authenticated with TLSPeer.
+func PeerFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	p, ok := ctx.Value(peerKey{}).(*PeerIdentity)
+	return p, ok
+}
+
+// TLSPeer is a Func for NewInterceptor that authenticates the caller by its
+// client certificate. The server must verify client certificates, with
+// tls.RequireAndVerifyClientCert. It leaves in the context the PeerIdentity,
+// for PeerFromContext and Allowlist, and an Identity with the name of the peer
+// and its organizational units as roles, for Policy.
+func TLSPeer(ctx context.Context, fullMethod string) (context.Context, error) {
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("no peer")
+	}
+	tlsInfo, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, errors.New("connection without TLS")
+	}
+	// The first chain is enough: all of them start with the same leaf, the
+	// client certificate.
+	if len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, errors.New("no verified client certificate")
+	}
+	p, err := NewPeerIdentity(tlsInfo.State.VerifiedChains[0][0])
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, peerKey{}, p)
+	return NewContext(ctx, &Identity{Subject: p.Name(), Roles: p.OrganizationalUnits}), nil
+}
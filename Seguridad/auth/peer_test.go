@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testCert returns a certificate with the given URI SANs.
+func testCert(t *testing.T, uris ...string) *x509.Certificate {
+	t.Helper()
+	cert := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "localhost", OrganizationalUnit: []string{"Publisher"}},
+		Issuer:       pkix.Name{CommonName: "devca"},
+		SerialNumber: big.NewInt(0xabc),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	for _, s := range uris {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert.URIs = append(cert.URIs, u)
+	}
+	return cert
+}
+
+func TestNewPeerIdentity(t *testing.T) {
+	p, err := NewPeerIdentity(testCert(t, "spiffe://productinfo.local/client/publisher"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SPIFFEID != "spiffe://productinfo.local/client/publisher" || p.Name() != p.SPIFFEID {
+		t.Errorf("SPIFFE ID %q, name %q", p.SPIFFEID, p.Name())
+	}
+	if p.CommonName != "localhost" || p.SerialNumber != "abc" || p.Issuer != "CN=devca" ||
+		len(p.OrganizationalUnits) != 1 || p.OrganizationalUnits[0] != "Publisher" ||
+		len(p.IPAddresses) != 1 || p.IPAddresses[0] != "127.0.0.1" {
+		t.Errorf("identity %+v", p)
+	}
+
+	// Without SPIFFE ID the name is the first URI SAN, or the common name.
+	p, err = NewPeerIdentity(testCert(t, "https://publisher.productinfo.local", "urn:publisher"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SPIFFEID != "" || p.Name() != "https://publisher.productinfo.local" {
+		t.Errorf("SPIFFE ID %q, name %q", p.SPIFFEID, p.Name())
+	}
+	p, err = NewPeerIdentity(testCert(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "localhost" {
+		t.Errorf("name %q, want the common name", p.Name())
+	}
+}
+
+func TestNewPeerIdentityRejectsBadSPIFFE(t *testing.T) {
+	cases := []struct {
+		name string
+		uris []string
+		want string
+	}{
+		{"more than one URI SAN", []string{"spiffe://productinfo.local/client", "https://productinfo.local"}, "more than one URI SAN"},
+		{"two SPIFFE IDs", []string{"spiffe://productinfo.local/a", "spiffe://productinfo.local/b"}, "more than one URI SAN"},
+		{"port", []string{"spiffe://productinfo.local:8443/client"}, "bad trust domain"},
+		{"user", []string{"spiffe://admin@productinfo.local/client"}, "bad trust domain"},
+		{"no trust domain", []string{"spiffe:///client"}, "bad trust domain"},
+		{"query", []string{"spiffe://productinfo.local/client?x=1"}, "query or fragment"},
+		{"fragment", []string{"spiffe://productinfo.local/client#x"}, "query or fragment"},
+		{"uppercase trust domain", []string{"spiffe://ProductInfo.local/client"}, "lowercase"},
+	}
+	for _, c := range cases {
+		_, err := NewPeerIdentity(testCert(t, c.uris...))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: %v, want an error with %q", c.name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the domain of the ErrorInfo of the calls denied by a Policy or
+// an Allowlist.
+const ErrorDomain = "seguridad.auth"
+
+// Reasons of the ErrorInfo of the calls denied by a Policy or an Allowlist.
+const (
+	// ReasonNoIdentity: the call was not authenticated.
+	ReasonNoIdentity = "NO_IDENTITY"
+	// ReasonMethodNotAllowed: no role of the caller allows the method.
+	ReasonMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	// ReasonPeerNotAllowed: no rule of the Allowlist takes in the client
+	// certificate for the method.
+	ReasonPeerNotAllowed = "PEER_NOT_ALLOWED"
+)
+
+// Policy is a role-based access control policy:
+//
+//	{
+//	  "roles": {
+//	    "reader": ["/ecommerce.OrderManagement/getOrder", "/ecommerce.OrderManagement/searchOrders"],
+//	    "writer": ["/ecommerce.OrderManagement/"],
+//	    "admin": ["*"]
+//	  },
+//	  "subjects": {
+//	    "acme": ["writer"]
+//	  }
+//	}
+//
+// Each role allows a list of methods. An entry is a full method, a whole
+// service ending in "/", or "*" for everything. Callers get the roles of their
+// Identity, and also the roles the policy binds to their subject, for
+// credentials that carry no roles.
+type Policy struct {
+	Roles    map[string][]string `json:"roles"`
+	Subjects map[string][]string `json:"subjects"`
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &p, nil
+}
+
+// validate catches the typos that would silently deny or allow calls.
+func (p *Policy) validate() error {
+	for role, methods := range p.Roles {
+		for _, m := range methods {
+			if !validMethod(m) {
+				return fmt.Errorf("role %q: %q is not a method, a service ending in / or *", role, m)
+			}
+		}
+	}
+	for subject, roles := range p.Subjects {
+		for _, r := range roles {
+			if _, ok := p.Roles[r]; !ok {
+				return fmt.Errorf("subject %q: unknown role %q", subject, r)
+			}
+		}
+	}
+	return nil
+}
+
+// validMethod reports whether m is a full method, a service ending in "/" or
+// "*".
+func validMethod(m string) bool {
+	return m == "*" || (strings.HasPrefix(m, "/") && strings.Count(m, "/") == 2)
+}
+
+// matchMethod reports whether the entry m of a policy takes in fullMethod.
+func matchMethod(m, fullMethod string) bool {
+	return m == "*" || m == fullMethod || (strings.HasSuffix(m, "/") && strings.HasPrefix(fullMethod, m))
+}
+
+// Allowed returns the role that allows the identity to call fullMethod, if any.
+func (p *Policy) Allowed(id *Identity, fullMethod string) (string, bool) {
+	roles := append(append([]string(nil), id.Roles...), p.Subjects[id.Subject]...)
+	for _, r := range roles {
+		for _, m := range p.Roles[r] {
+			if matchMethod(m, fullMethod) {
+				return r, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Authorize is a Func for NewInterceptor that checks the identity left in the
+// context by authentication against the policy. It goes after the
+// authentication interceptor, with the same methods to skip. Denied calls get
+// PermissionDenied with an ErrorInfo, and every deny is logged.
+func (p *Policy) Authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		log.Printf("rbac: deny %s: call without identity", fullMethod)
+		return nil, denied(ReasonNoIdentity, "call not authenticated", map[string]string{"method": fullMethod})
+	}
+	if _, ok := p.Allowed(id, fullMethod); !ok {
+		log.Printf("rbac: deny %s to %q with roles %v", fullMethod, id.Subject, id.Roles)
+		return nil, denied(ReasonMethodNotAllowed, "method not allowed", map[string]string{"method": fullMethod})
+	}
+	return ctx, nil
+}
+
+func denied(reason, msg string, md map[string]string) error {
+	st := status.New(codes.PermissionDenied, msg)
+	if ds, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain, Metadata: md}); err == nil {
+		st = ds
+	}
+	return st.Err()
+}
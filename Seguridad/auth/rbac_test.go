@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	getOrder = "/ecommerce.OrderManagement/getOrder"
+	addOrder = "/ecommerce.OrderManagement/addOrder"
+)
+
+var testPolicy = &Policy{
+	Roles: map[string][]string{
+		"reader": {getOrder, "/ecommerce.OrderManagement/searchOrders"},
+		"writer": {"/ecommerce.OrderManagement/"},
+		"admin":  {"*"},
+	},
+	Subjects: map[string][]string{"acme": {"writer"}},
+}
+
+func TestPolicyValidate(t *testing.T) {
+	if err := testPolicy.validate(); err != nil {
+		t.Fatalf("valid policy: %v", err)
+	}
+	cases := []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{"method without service", Policy{Roles: map[string][]string{"r": {"getOrder"}}}, `"getOrder"`},
+		{"service without trailing slash", Policy{Roles: map[string][]string{"r": {"/ecommerce.OrderManagement"}}}, "not a method"},
+		{"too many slashes", Policy{Roles: map[string][]string{"r": {"/a/b/c"}}}, "not a method"},
+		{"empty method", Policy{Roles: map[string][]string{"r": {""}}}, "not a method"},
+		{"unknown role", Policy{Roles: map[string][]string{"r": {"*"}}, Subjects: map[string][]string{"acme": {"writer"}}}, `unknown role "writer"`},
+	}
+	for _, c := range cases {
+		err := c.policy.validate()
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: %v, want an error with %s", c.name, err, c.want)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rbac.json")
+	ioutil.WriteFile(path, []byte(`{"roles": {"reader": ["`+getOrder+`"]}, "subjects": {"acme": ["reader"]}}`), 0600)
+	p, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Allowed(&Identity{Subject: "acme"}, getOrder); !ok {
+		t.Error("the loaded policy does not allow its method")
+	}
+
+	ioutil.WriteFile(path, []byte(`{"roles": {"reader": ["getOrder"]}}`), 0600)
+	if _, err := LoadPolicy(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("invalid policy: %v, want an error with the path", err)
+	}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	cases := []struct {
+		name   string
+		id     *Identity
+		method string
+		role   string
+	}{
+		{"role of the identity", &Identity{Subject: "u", Roles: []string{"reader"}}, getOrder, "reader"},
+		{"role of the identity, other method", &Identity{Subject: "u", Roles: []string{"reader"}}, addOrder, ""},
+		{"role bound to the subject", &Identity{Subject: "acme"}, addOrder, "writer"},
+		{"whole service", &Identity{Roles: []string{"writer"}}, "/ecommerce.OrderManagement/processOrders", "writer"},
+		{"other service", &Identity{Roles: []string{"writer"}}, "/ecommerce.ProductInfo/getProduct", ""},
+		{"service prefix is not a method prefix", &Identity{Roles: []string{"writer"}}, "/ecommerce.OrderManagementV2/getOrder", ""},
+		{"everything", &Identity{Roles: []string{"admin"}}, "/grpc.health.v1.Health/Check", "admin"},
+		{"unknown role", &Identity{Roles: []string{"root"}}, getOrder, ""},
+		{"no roles", &Identity{Subject: "nobody"}, getOrder, ""},
+	}
+	for _, c := range cases {
+		role, ok := testPolicy.Allowed(c.id, c.method)
+		if role != c.role || ok != (c.role != "") {
+			t.Errorf("%s: Allowed = %q, %v; want %q", c.name, role, ok, c.role)
+		}
+	}
+}
+
+// errorInfo returns the ErrorInfo of a status error.
+func errorInfo(t *testing.T, err error) *errdetails.ErrorInfo {
+	t.Helper()
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	t.Fatalf("%v has no ErrorInfo", err)
+	return nil
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	ctx := NewContext(context.Background(), &Identity{Subject: "u", Roles: []string{"reader"}})
+	got, err := testPolicy.Authorize(ctx, getOrder)
+	if err != nil || got != ctx {
+		t.Fatalf("allowed call: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		ctx    context.Context
+		reason string
+	}{
+		{"without identity", context.Background(), ReasonNoIdentity},
+		{"method not allowed", ctx, ReasonMethodNotAllowed},
+	}
+	for _, c := range cases {
+		_, err := testPolicy.Authorize(c.ctx, addOrder)
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%s: %v, want PermissionDenied", c.name, err)
+			continue
+		}
+		info := errorInfo(t, err)
+		if info.Reason != c.reason || info.Domain != ErrorDomain || info.Metadata["method"] != addOrder {
+			t.Errorf("%s: ErrorInfo %v, want reason %s, domain %s and method %s", c.name, info, c.reason, ErrorDomain, addOrder)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Package tlsreload keeps the certificate, key and CA bundle of a TLS server or
+// client loaded from files, and reads them again when they change, so
+// certificates can be rotated without a restart. Only new handshakes get the new
+// files: established connections go on with the certificates they started with.
+package tlsreload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults of Watch.
+const (
+	// Interval between checks of the files.
+	DefaultInterval = 10 * time.Second
+	// Certificates that expire before this are logged as a warning.
+	DefaultWarnBefore = 14 * 24 * time.Hour
+	// Warnings about the same certificate are repeated this often.
+	warnEvery = time.Hour
+)
+
+// Reloader holds the current key pair and CA bundle read from the files.
+type Reloader struct {
+	certFile, keyFile, caFile string
+	// WarnBefore is how long before its expiry a certificate is logged as a
+	// warning. Set it before calling Watch.
+	WarnBefore time.Duration
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	caCerts  []*x509.Certificate
+	modified map[string]time.Time
+	warned   map[string]time.Time
+}
+
+// New reads the key pair in certFile and keyFile and the CA bundle in caFile.
+// caFile may be empty when the peer is not verified against a CA of its own,
+// and certFile and keyFile when there is no certificate to present.
+func New(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		caFile:     caFile,
+		WarnBefore: DefaultWarnBefore,
+		modified:   make(map[string]time.Time),
+		warned:     make(map[string]time.Time),
+	}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.checkExpiry(time.Now())
+	return r, nil
+}
+
+// files are the files of the reloader, without the empty ones.
+func (r *Reloader) files() []string {
+	var files []string
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// reload reads the files again if any of them changed. It keeps the current
+// certificates when the new ones can not be loaded, for instance while they are
+// copied one by one: the next check will try again.
+func (r *Reloader) reload() (bool, error) {
+	changed := false
+	modified := make(map[string]time.Time)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		modified[f] = info.ModTime()
+		r.mu.RLock()
+		if !info.ModTime().Equal(r.modified[f]) {
+			changed = true
+		}
+		r.mu.RUnlock()
+	}
+	if !changed {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return false, err
+		}
+		// The leaf is parsed once here, and not on every handshake.
+		if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+			return false, err
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	var caCerts []*x509.Certificate
+	if r.caFile != "" {
+		b, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return false, err
+		}
+		if caCerts, err = parseCertificates(b); err != nil {
+			return false, fmt.Errorf("%s: %v", r.caFile, err)
+		}
+		pool = x509.NewCertPool()
+		for _, c := range caCerts {
+			pool.AddCert(c)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.pool, r.caCerts, r.modified = cert, pool, caCerts, modified
+	r.mu.Unlock()
+	return true, nil
+}
+
+func parseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates")
+	}
+	return certs, nil
+}
+
+// Watch checks the files every interval, reloads them when they change and
+// logs the certificates about to expire. It returns when stop is closed.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			changed, err := r.reload()
+			if err != nil {
+				log.Printf("tlsreload: keeping the current certificates: %v", err)
+				continue
+			}
+			if changed {
+				log.Printf("tlsreload: reloaded %v", r.files())
+			}
+			r.checkExpiry(now)
+		}
+	}
+}
+
+// checkExpiry logs a warning for the certificate and the CAs that expire within
+// WarnBefore, at most once every hour for each of them.
+func (r *Reloader) checkExpiry(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certs := append([]*x509.Certificate(nil), r.caCerts...)
+	if r.cert != nil {
+		certs = append(certs, r.cert.Leaf)
+	}
+	for _, c := range certs {
+		left := c.NotAfter.Sub(now)
+		if left > r.WarnBefore {
+			continue
+		}
+		key := c.Subject.String() + "/" + c.SerialNumber.String()
+		if now.Sub(r.warned[key]) < warnEvery {
+			continue
+		}
+		r.warned[key] = now
+		if left <= 0 {
+			log.Printf("tlsreload: WARNING certificate %q expired on %s", c.Subject, c.NotAfter.Format(time.RFC3339))
+		} else {
+			log.Printf("tlsreload: WARNING certificate %q expires in %s, on %s", c.Subject, left.Round(time.Minute), c.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// GetCertificate returns the current certificate, for tls.Config.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	if cert == nil {
+		return nil, errors.New("tlsreload: no certificate")
+	}
+	return cert, nil
+}
+
+// GetClientCertificate returns the current certificate, for tls.Config.
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	if cert == nil {
+		// No certificate: the server decides whether that is enough.
+		return &tls.Certificate{}, nil
+	}
+	return cert, nil
+}
+
+// ServerConfig returns the configuration of a TLS server with the current
+// certificate. With a CA bundle, client certificates are verified against it as
+// clientAuth says.
+func (r *Reloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: r.GetCertificate,
+		// gRPC needs HTTP/2, and the configs returned below replace this one.
+		NextProtos: []string{"h2"},
+	}
+	if r.caFile == "" {
+		return config
+	}
+	config.ClientAuth = clientAuth
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		_, pool := r.current()
+		return &tls.Config{
+			GetCertificate: r.GetCertificate,
+			NextProtos:     config.NextProtos,
+			ClientAuth:     clientAuth,
+			ClientCAs:      pool,
+		}, nil
+	}
+	return config
+}
+
+// ClientConfig returns the configuration of a TLS client that presents the
+// current certificate and verifies serverName against the current CA bundle.
+// tls.Config has no hook to change RootCAs per handshake, so the default
+// verification is replaced by one that does the same with the current bundle.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:           serverName,
+		GetClientCertificate: r.GetClientCertificate,
+		InsecureSkipVerify:   true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, pool := r.current()
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("tlsreload: server without certificate")
+			}
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       serverName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
@@ -0,0 +1,138 @@
+package tlsreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a CA that signs the certificates of a test.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// leaf returns a server certificate for dnsName signed by the CA.
+func (ca *testCA) leaf(t *testing.T, dnsName string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeCA writes the CA bundle and moves its modification time forward, so
+// the reloader sees the change even within the resolution of the file system.
+func writeCA(t *testing.T, path string, ca *testCA, modified time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientConfigVerifyConnection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsreload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+
+	trusted, other := newTestCA(t, "trusted"), newTestCA(t, "other")
+	writeCA(t, caFile, trusted, time.Now())
+	r, err := New("", "", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.ClientConfig("localhost")
+
+	verify := func(cert *x509.Certificate) error {
+		return config.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	}
+	if err := verify(trusted.leaf(t, "localhost")); err != nil {
+		t.Errorf("certificate of the trusted CA: %v", err)
+	}
+	if err := verify(trusted.leaf(t, "other.example")); err == nil {
+		t.Error("accepts a certificate for another host name")
+	} else if _, ok := err.(x509.HostnameError); !ok {
+		t.Errorf("wrong host name: %v, want an x509.HostnameError", err)
+	}
+	if err := verify(other.leaf(t, "localhost")); err == nil {
+		t.Error("accepts a certificate of an untrusted CA")
+	} else if _, ok := err.(x509.UnknownAuthorityError); !ok {
+		t.Errorf("untrusted CA: %v, want an x509.UnknownAuthorityError", err)
+	}
+	if err := config.VerifyConnection(tls.ConnectionState{}); err == nil {
+		t.Error("accepts a server without certificate")
+	}
+
+	// The same config trusts the new bundle after a reload.
+	writeCA(t, caFile, other, time.Now().Add(time.Second))
+	if changed, err := r.reload(); !changed || err != nil {
+		t.Fatalf("reload = %v, %v", changed, err)
+	}
+	if err := verify(other.leaf(t, "localhost")); err != nil {
+		t.Errorf("certificate of the new CA after the reload: %v", err)
+	}
+	if err := verify(trusted.leaf(t, "localhost")); err == nil {
+		t.Error("accepts a certificate of the CA removed from the bundle")
+	}
+}
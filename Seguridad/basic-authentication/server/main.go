@@ -8,20 +8,20 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"encoding/base64"
 	"errors"
+	"fmt"
 	wrapper "github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/google/uuid"
 	pb "github.com/grpc-up-and-running/samples/ch02/productinfo/go/product_info"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 	"log"
 	"net"
+	"os"
 	"path/filepath"
-	"strings"
+	"seguridad/auth"
+	"seguridad/auth/tlsreload"
+	"time"
 )
 
 // server is used to implement ecommerce/product_info.
@@ -31,8 +31,13 @@ type server struct {
 
 var (
 	port = ":50051"
-	errMissingMetadata = status.Errorf(codes.InvalidArgument, "missing metadata")
-	errInvalidToken    = status.Errorf(codes.Unauthenticated, "invalid credentials")
+	// Users and their password hashes. Manage them with "server users".
+	usersPath = filepath.Join("ch06", "basic-authentication", "server", "users.json")
+	// Failed logins in a row that lock a user, and for how long.
+	maxFailures = 5
+	lockout = 5 * time.Minute
+	// Methods allowed to each group of users.
+	rbacPath = filepath.Join("ch06", "basic-authentication", "server", "rbac.json")
 )
 
 // AddProduct implements ecommerce.AddProduct
@@ -42,6 +47,9 @@ func (s *server) AddProduct(ctx context.Context, in *pb.Product) (*wrapper.Strin
 		log.Fatal(err)
 	}
 	in.Id = out.String()
+	if user, ok := userFromContext(ctx); ok {
+		log.Printf("Product %s added by %s", in.Id, user)
+	}
 	if s.productMap == nil {
 		s.productMap = make(map[string]*pb.Product)
 	}
@@ -59,16 +67,39 @@ func (s *server) GetProduct(ctx context.Context, in *wrapper.StringValue) (*pb.P
 }
 
 func main() {
-	cert, err := tls.LoadX509KeyPair(filepath.Join("ch06", "secure-channel", "certs", "server.crt"),
-		filepath.Join("ch06", "secure-channel", "certs", "server.key"))
+	if len(os.Args) > 1 && os.Args[1] == "users" {
+		if err := usersCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	users, err := newUserStore(usersPath, maxFailures, lockout)
+	if err != nil {
+		log.Fatalf("failed to load users: %s", err)
+	}
+	certs, err := tlsreload.New(filepath.Join("ch06", "secure-channel", "certs", "server.crt"),
+		filepath.Join("ch06", "secure-channel", "certs", "server.key"), "")
 	if err != nil {
 		log.Fatalf("failed to load key pair: %s", err)
 	}
+	go certs.Watch(tlsreload.DefaultInterval, nil)
+	policy, err := auth.LoadPolicy(rbacPath)
+	if err != nil {
+		log.Fatalf("failed to load RBAC policy: %s", err)
+	}
+	authn := auth.NewInterceptor(users.authenticate, auth.HealthAndReflection...)
+	authz := auth.NewInterceptor(policy.Authorize, auth.HealthAndReflection...)
 	opts := []grpc.ServerOption{
 		// Enable TLS for all incoming connections.
-		grpc.Creds(credentials.NewServerTLSFromCert(&cert)),
+		// The certificate is read again when the files change.
+		grpc.Creds(credentials.NewTLS(certs.ServerConfig(tls.NoClientCert))),
 
-		grpc.UnaryInterceptor(ensureValidBasicCredentials),
+		// Authentication, then authorization with the groups of the user. Both
+		// for unary and streaming methods.
+		grpc.ChainUnaryInterceptor(authn.UnaryServerInterceptor(), authz.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(authn.StreamServerInterceptor(), authz.StreamServerInterceptor()),
 	}
 
 	s := grpc.NewServer(opts...)
@@ -86,30 +117,20 @@ func main() {
 	}
 }
 
-// valid validates the authorization.
-func valid(authorization []string) bool {
-	if len(authorization) < 1 {
-		return false
-	}
-	token := strings.TrimPrefix(authorization[0], "Basic ")
-	return token == base64.StdEncoding.EncodeToString([]byte("admin:admin"))
-}
-
-// ensureValidToken ensures a valid token exists within a request's metadata. If
-// the token is missing or invalid, the interceptor blocks execution of the
-// handler and returns an error. Otherwise, the interceptor invokes the unary
-// handler.
-func ensureValidBasicCredentials(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
-	handler grpc.UnaryHandler) (interface{}, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, errMissingMetadata
+// authenticate checks the basic credentials of a call, unary or streaming, and
+// returns the context for the handler with the name and groups of the user.
+func (s *userStore) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	name, password, err := auth.BasicCredentials(ctx)
+	if err != nil {
+		return nil, err
 	}
-	// The keys within metadata.MD are normalized to lowercase.
-	// See: https://godoc.org/google.golang.org/grpc/metadata#New
-	if !valid(md["authorization"]) {
-		return nil, errInvalidToken
+	// The error only goes to the server log, so callers can not tell a wrong
+	// password from a locked or disabled user.
+	u, err := s.check(name, password)
+	if err != nil {
+		return nil, fmt.Errorf("user %q: %v", name, err)
 	}
-	// Continue execution of handler after ensuring a valid token.
-	return handler(ctx, req)
+	// The groups of the user are its roles for the RBAC policy.
+	ctx = auth.NewContext(ctx, &auth.Identity{Subject: u.Name, Roles: u.Groups})
+	return context.WithValue(ctx, userKey{}, name), nil
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// user is an entry of the users file. Passwords are never stored, only their
+// bcrypt or argon2id hash.
+type user struct {
+	Name     string `json:"name"`
+	Hash     string `json:"hash"`
+	Disabled bool   `json:"disabled,omitempty"`
+	// Groups of the user, the roles of the RBAC policy.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// usersFile is the format of the users file.
+type usersFile struct {
+	Users []*user `json:"users"`
+}
+
+func readUsers(path string) (*usersFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f usersFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &f, nil
+}
+
+// writeUsers replaces the users file in one step, so the server never reads a
+// half written file.
+func writeUsers(path string, f *usersFile) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".users-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *usersFile) find(name string) *user {
+	for _, u := range f.Users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// failures counts the consecutive failed logins of a user.
+type failures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// userStore checks credentials against the users file. The file is read again
+// when it changes, so users added, disabled or rotated with the users command
+// apply without restarting the server.
+type userStore struct {
+	path string
+	// maxFailures consecutive failed logins lock the user for lockout.
+	maxFailures int
+	lockout     time.Duration
+	// now is time.Now and compare is checkPassword, except in tests.
+	now     func() time.Time
+	compare func(hash, password string) bool
+
+	mu       sync.Mutex
+	users    map[string]*user
+	modified time.Time
+	// failed only has entries for users of the file, so names made up by
+	// clients cannot make it grow.
+	failed map[string]*failures
+}
+
+func newUserStore(path string, maxFailures int, lockout time.Duration) (*userStore, error) {
+	s := &userStore{path: path, maxFailures: maxFailures, lockout: lockout, now: time.Now, compare: checkPassword, failed: make(map[string]*failures)}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the users file if it has changed. It is called with mu held,
+// except from newUserStore.
+func (s *userStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modified) {
+		return nil
+	}
+	f, err := readUsers(s.path)
+	if err != nil {
+		return err
+	}
+	users := make(map[string]*user)
+	for _, u := range f.Users {
+		users[u.Name] = u
+	}
+	s.users = users
+	s.modified = info.ModTime()
+	// Forget the failures of the users removed from the file.
+	for name := range s.failed {
+		if users[name] == nil {
+			delete(s.failed, name)
+		}
+	}
+	return nil
+}
+
+var (
+	errBadCredentials = errors.New("unknown user or wrong password")
+	errDisabled       = errors.New("user disabled")
+	errLocked         = errors.New("user locked after too many failed logins")
+)
+
+// check checks the password of the user and returns it. Callers must not tell
+// the client which check failed.
+func (s *userStore) check(name, password string) (*user, error) {
+	s.mu.Lock()
+	if err := s.reload(); err != nil {
+		// Keep the users we have, the file may be being replaced by hand.
+		log.Printf("failed to reload %s: %v", s.path, err)
+	}
+	u := s.users[name]
+	f := s.failed[name]
+	locked := f != nil && s.now().Before(f.lockedUntil)
+	s.mu.Unlock()
+
+	// Hashing takes a while, so it is done without the lock. Unknown users are
+	// checked against a dummy hash, and locked users are checked anyway, so the
+	// response time does not tell which users exist or are locked.
+	hash := dummyHash()
+	if u != nil {
+		hash = u.Hash
+	}
+	ok := s.compare(hash, password) && u != nil
+	if locked {
+		return nil, errLocked
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ok {
+		// Unknown users are not tracked: there is nothing to lock, and the
+		// map would grow with every name a client tries.
+		if u == nil {
+			return nil, errBadCredentials
+		}
+		if s.failed[name] == nil {
+			s.failed[name] = &failures{}
+		}
+		f := s.failed[name]
+		f.count++
+		if f.count >= s.maxFailures {
+			f.count = 0
+			f.lockedUntil = s.now().Add(s.lockout)
+			log.Printf("user %q locked for %v after %d failed logins", name, s.lockout, s.maxFailures)
+		}
+		return nil, errBadCredentials
+	}
+	delete(s.failed, name)
+	if u.Disabled {
+		return nil, errDisabled
+	}
+	return u, nil
+}
+
+type userKey struct{}
+
+// userFromContext returns the name of the authenticated user of the call.
+func userFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(userKey{}).(string)
+	return name, ok
+}
+
+// Parameters of new argon2id hashes, as recommended by RFC 9106 for memory
+// constrained environments.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+)
+
+// Limits of the argon2id parameters read from a stored hash. A hash edited by
+// hand, or planted in the users file, must not make every login take gigabytes
+// of memory or minutes of CPU.
+const (
+	argon2MaxTime    = 10
+	argon2MaxMemory  = 1024 * 1024 // 1 GiB, in KiB
+	argon2MaxThreads = 16
+	argon2MaxKeyLen  = 64
+)
+
+// hashPassword hashes the password with algorithm "bcrypt" or "argon2id".
+func hashPassword(password, algorithm string) (string, error) {
+	switch algorithm {
+	case "bcrypt":
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(h), err
+	case "argon2id":
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", algorithm)
+}
+
+// checkPassword compares the password with a bcrypt hash or an argon2id hash
+// in PHC string format, in constant time.
+func checkPassword(hash, password string) bool {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	// argon2.IDKey panics with these.
+	if iterations < 1 || threads < 1 {
+		return false
+	}
+	if iterations > argon2MaxTime || memory > argon2MaxMemory || threads > argon2MaxThreads {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 || len(key) > argon2MaxKeyLen {
+		return false
+	}
+	other := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+var (
+	dummyOnce sync.Once
+	dummy     string
+)
+
+// dummyHash is a bcrypt hash of a random password, checked for unknown users.
+func dummyHash() string {
+	dummyOnce.Do(func() {
+		b := make([]byte, 16)
+		rand.Read(b)
+		h, _ := bcrypt.GenerateFromPassword(b, bcrypt.DefaultCost)
+		dummy = string(h)
+	})
+	return dummy
+}
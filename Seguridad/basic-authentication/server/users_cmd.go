@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const usersUsage = `usage: server users [-file users.json] [-hash bcrypt|argon2id] <command> [name]
+
+Commands:
+  list           list the users
+  add <name>     add a user
+  rotate <name>  change the password of a user
+  disable <name> disable a user, who can not log in until enabled again
+  enable <name>  enable a disabled user
+  groups <name> [group,...]
+                 set the groups of a user, none if the list is missing
+
+add and rotate read the password from the first line of the standard input.
+If it is empty, they generate a random password and print it.`
+
+// usersCommand manages the users file. The server reloads the file when it
+// changes, so there is no need to restart it.
+func usersCommand(args []string) error {
+	fs := flag.NewFlagSet("users", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(fs.Output(), usersUsage) }
+	file := fs.String("file", usersPath, "users file")
+	hash := fs.String("hash", "bcrypt", "hash algorithm of new passwords: bcrypt or argon2id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+	cmd, name := fs.Arg(0), fs.Arg(1)
+	if cmd != "list" && name == "" {
+		return fmt.Errorf("%s: missing user name", cmd)
+	}
+
+	f, err := readUsers(*file)
+	if os.IsNotExist(err) && cmd == "add" {
+		f, err = &usersFile{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	u := f.find(name)
+
+	switch cmd {
+	case "list":
+		for _, u := range f.Users {
+			state := "enabled"
+			if u.Disabled {
+				state = "disabled"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", u.Name, state, strings.SplitN(strings.TrimPrefix(u.Hash, "$"), "$", 2)[0],
+				strings.Join(u.Groups, ","))
+		}
+		return nil
+	case "add":
+		if u != nil {
+			return fmt.Errorf("user %q already exists", name)
+		}
+		u = &user{Name: name}
+		f.Users = append(f.Users, u)
+		fallthrough
+	case "rotate":
+		if u == nil {
+			return fmt.Errorf("unknown user %q", name)
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if u.Hash, err = hashPassword(password, *hash); err != nil {
+			return err
+		}
+	case "disable", "enable":
+		if u == nil {
+			return fmt.Errorf("unknown user %q", name)
+		}
+		u.Disabled = cmd == "disable"
+	case "groups":
+		if u == nil {
+			return fmt.Errorf("unknown user %q", name)
+		}
+		u.Groups = nil
+		for _, g := range strings.Split(fs.Arg(2), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				u.Groups = append(u.Groups, g)
+			}
+		}
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	if err := writeUsers(*file, f); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s done\n", name, cmd)
+	return nil
+}
+
+// readPassword reads the password from the standard input, or generates one
+// if the line is empty.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "password (empty to generate one): ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if password := strings.TrimRight(line, "\r\n"); password != "" {
+		return password, nil
+	}
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	fmt.Printf("generated password: %s\n", password)
+	return password, nil
+}
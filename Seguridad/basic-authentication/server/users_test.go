@@ -0,0 +1,195 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	for _, alg := range []string{"bcrypt", "argon2id"} {
+		hash, err := hashPassword("s3cret", alg)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if !checkPassword(hash, "s3cret") {
+			t.Errorf("%s: the password does not match its own hash", alg)
+		}
+		if checkPassword(hash, "wrong") {
+			t.Errorf("%s: a wrong password matches", alg)
+		}
+	}
+	if _, err := hashPassword("s3cret", "md5"); err == nil {
+		t.Error("hashPassword accepts an unknown algorithm")
+	}
+}
+
+func TestCheckPasswordMalformed(t *testing.T) {
+	hash, err := hashPassword("s3cret", "argon2id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt, key := "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	for _, h := range []string{
+		"",
+		"not a hash",
+		"$argon2id$",
+		"$argon2id$v=19$m=65536,t=3,p=4$" + salt,
+		hash + "$extra",
+		"$argon2id$v=18$m=65536,t=3,p=4$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=3$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=0,p=4$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=4$!!!$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$!!!",
+		"$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$",
+		// Parameters too costly to compute on every login.
+		"$argon2id$v=19$m=4294967295,t=3,p=4$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1000000,p=4$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=255$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$" + strings.Repeat("a2V5", 30),
+	} {
+		if checkPassword(h, "s3cret") {
+			t.Errorf("checkPassword(%q) matches", h)
+		}
+	}
+}
+
+// testStore writes a users file with the given users, all with password
+// "s3cret", and opens it.
+func testStore(t *testing.T, users ...*user) (*userStore, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	h, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		u.Hash = string(h)
+	}
+	path := filepath.Join(dir, "users.json")
+	if err := writeUsers(path, &usersFile{Users: users}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newUserStore(path, 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func TestCheckLockout(t *testing.T) {
+	s, _ := testStore(t, &user{Name: "alice"})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.check("alice", "wrong"); err != errBadCredentials {
+			t.Fatalf("failed login %d: %v, want errBadCredentials", i, err)
+		}
+	}
+	// Locked, even with the right password. The password is still hashed, so
+	// the response time does not tell that the user is locked.
+	compared := 0
+	s.compare = func(hash, password string) bool {
+		compared++
+		return checkPassword(hash, password)
+	}
+	if _, err := s.check("alice", "s3cret"); err != errLocked {
+		t.Fatalf("after 3 failed logins: %v, want errLocked", err)
+	}
+	if compared != 1 {
+		t.Errorf("%d password comparisons for a locked user, want 1", compared)
+	}
+
+	now = now.Add(time.Minute)
+	u, err := s.check("alice", "s3cret")
+	if err != nil || u.Name != "alice" {
+		t.Fatalf("after the lockout: %v, %v", u, err)
+	}
+	// A successful login resets the count.
+	s.check("alice", "wrong")
+	s.check("alice", "wrong")
+	if _, err := s.check("alice", "s3cret"); err != nil {
+		t.Fatalf("2 failed logins lock the user: %v", err)
+	}
+}
+
+func TestCheckUnknownUsersAreNotTracked(t *testing.T) {
+	s, _ := testStore(t, &user{Name: "alice"})
+	for i := 0; i < 10; i++ {
+		if _, err := s.check("mallory", "x"); err != errBadCredentials {
+			t.Fatalf("unknown user: %v, want errBadCredentials", err)
+		}
+	}
+	if len(s.failed) != 0 {
+		t.Errorf("%d failure entries for unknown users", len(s.failed))
+	}
+}
+
+func TestCheckDisabled(t *testing.T) {
+	s, _ := testStore(t, &user{Name: "bob", Disabled: true})
+	if _, err := s.check("bob", "s3cret"); err != errDisabled {
+		t.Errorf("disabled user: %v, want errDisabled", err)
+	}
+	// The wrong password does not tell the user is disabled.
+	if _, err := s.check("bob", "wrong"); err != errBadCredentials {
+		t.Errorf("disabled user, wrong password: %v, want errBadCredentials", err)
+	}
+}
+
+func TestCheckReload(t *testing.T) {
+	s, path := testStore(t, &user{Name: "alice"})
+
+	f, err := readUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.find("alice").Disabled = true
+	f.Users = append(f.Users, &user{Name: "carol", Hash: f.Users[0].Hash})
+	if err := writeUsers(path, f); err != nil {
+		t.Fatal(err)
+	}
+	// The file system may not tell two writes in the same tick apart.
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.check("carol", "s3cret"); err != nil {
+		t.Errorf("user added to the file: %v", err)
+	}
+	if _, err := s.check("alice", "s3cret"); err != errDisabled {
+		t.Errorf("user disabled in the file: %v, want errDisabled", err)
+	}
+
+	// Removing a user forgets its failures.
+	s.check("alice", "wrong")
+	if _, ok := s.failed["alice"]; !ok {
+		t.Fatal("failed login not counted")
+	}
+	f.Users = f.Users[1:]
+	if err := writeUsers(path, f); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	s.check("carol", "wrong")
+	if _, ok := s.failed["alice"]; ok {
+		t.Error("the failures of a removed user are kept")
+	}
+	if _, err := s.check("alice", "s3cret"); err != errBadCredentials {
+		t.Errorf("user removed from the file: %v, want errBadCredentials", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// newKey generates a key of type "rsa", "ecdsa" or "ed25519".
+func newKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unknown key type %q: use rsa, ecdsa or ed25519", keyType)
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// ca is the development CA: its certificate and its key.
+type ca struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// createCA creates a self-signed CA valid for lifetime.
+func createCA(commonName, keyType string, lifetime time.Duration) (*ca, []byte, error) {
+	key, err := newKey(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(lifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		// Only leaf certificates under it, no intermediate CAs. The subject key
+		// ID is added by x509.CreateCertificate.
+		MaxPathLenZero: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &ca{cert: cert, key: key}, der, nil
+}
+
+// leaf describes a certificate to issue.
+type leaf struct {
+	commonName string
+	units      []string
+	sans       []string
+	server     bool
+	client     bool
+	keyType    string
+	lifetime   time.Duration
+}
+
+// issue signs a new certificate for l with the CA. It returns the DER of the
+// certificate and its key.
+func (c *ca) issue(l *leaf) ([]byte, crypto.Signer, error) {
+	key, err := newKey(l.keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: l.commonName, OrganizationalUnit: l.units},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(l.lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if template.NotAfter.After(c.cert.NotAfter) {
+		return nil, nil, fmt.Errorf("the certificate would outlive the CA, which expires on %s", c.cert.NotAfter.Format(time.RFC3339))
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		// TLS 1.2 key exchange with RSA encrypts with the key of the server.
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if l.server {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if l.client {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	if err := addSANs(template, l.sans); err != nil {
+		return nil, nil, err
+	}
+	if l.server && len(template.DNSNames) == 0 && len(template.IPAddresses) == 0 {
+		// Clients ignore the common name since Go 1.15.
+		return nil, nil, errors.New("a server certificate needs a DNS or IP SAN")
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, key.Public(), c.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return der, key, nil
+}
+
+// addSANs adds the subject alternative names to the template: IPs, URIs like
+// spiffe://productinfo.local/client, emails and DNS names.
+func addSANs(template *x509.Certificate, sans []string) error {
+	for _, san := range sans {
+		switch {
+		case net.ParseIP(san) != nil:
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(san))
+		case strings.Contains(san, "://"):
+			u, err := url.Parse(san)
+			if err != nil {
+				return fmt.Errorf("SAN %q: %v", san, err)
+			}
+			template.URIs = append(template.URIs, u)
+		case strings.Contains(san, "@"):
+			template.EmailAddresses = append(template.EmailAddresses, san)
+		default:
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+	return nil
+}
+
+// loadCA reads the CA from dir.
+func loadCA(dir string) (*ca, error) {
+	certPEM, err := ioutil.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(filepath.Join(dir, "ca.key"))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("ca.crt: no certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ca.crt: %v", err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("ca.key: no key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ca.key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("ca.key: not a signing key")
+	}
+	return &ca{cert: cert, key: signer}, nil
+}
+
+func certPEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// keyPEM encodes the key in PKCS #8, which Go, Java and OpenSSL read whatever
+// the type of key.
+func keyPEM(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeFile replaces the file in one step, so a server that reloads its
+// certificates never reads a half written file.
+func writeFile(path string, b []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeKeyPair writes name.key, name.pem and name.crt in dir. The key goes
+// first: servers that reload their certificates keep the old pair until both
+// files match.
+func writeKeyPair(dir, name string, der []byte, key crypto.Signer) error {
+	k, err := keyPEM(key)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, name+".key"), k, 0600); err != nil {
+		return err
+	}
+	// The same key, for the Java samples that want a .pem.
+	if err := writeFile(filepath.Join(dir, name+".pem"), k, 0600); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, name+".crt"), certPEM(der), 0644)
+}
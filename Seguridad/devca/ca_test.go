@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readCert reads the PEM certificate at path.
+func readCert(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatalf("%s: no certificate", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// verify checks that cert chains to the CA for usage.
+func verify(t *testing.T, name string, cert, caCert *x509.Certificate, usage x509.ExtKeyUsage) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+		t.Errorf("%s does not verify against the CA: %v", name, err)
+	}
+}
+
+func TestBundleMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	caDir := filepath.Join(dir, "ca")
+	if err := initCommand([]string{"-ca", caDir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundleCommand([]string{"-ca", caDir, "-samples", dir, "mutual-tls"}); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "mutual-tls-channel", "certs")
+
+	caCert := readCert(t, filepath.Join(caDir, "ca.crt"))
+	if bundled := readCert(t, filepath.Join(out, "ca.crt")); !bundled.Equal(caCert) {
+		t.Error("the bundled ca.crt is not the CA")
+	}
+	if !caCert.IsCA || !caCert.MaxPathLenZero || caCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Errorf("CA: IsCA %v, MaxPathLenZero %v, key usage %v", caCert.IsCA, caCert.MaxPathLenZero, caCert.KeyUsage)
+	}
+
+	for _, name := range []string{"server", "client"} {
+		// The key of each pair matches its certificate.
+		if _, err := tls.LoadX509KeyPair(filepath.Join(out, name+".crt"), filepath.Join(out, name+".key")); err != nil {
+			t.Errorf("%s key pair: %v", name, err)
+		}
+	}
+
+	server := readCert(t, filepath.Join(out, "server.crt"))
+	verify(t, "server", server, caCert, x509.ExtKeyUsageServerAuth)
+	if err := server.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if err := server.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+	if len(server.ExtKeyUsage) != 1 || server.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("server extended key usages %v, want server auth only", server.ExtKeyUsage)
+	}
+	if server.KeyUsage != x509.KeyUsageDigitalSignature || server.IsCA {
+		t.Errorf("server key usage %v, IsCA %v", server.KeyUsage, server.IsCA)
+	}
+
+	client := readCert(t, filepath.Join(out, "client.crt"))
+	verify(t, "client", client, caCert, x509.ExtKeyUsageClientAuth)
+	if len(client.URIs) != 1 || client.URIs[0].String() != "spiffe://productinfo.local/client/publisher" {
+		t.Errorf("client URIs %v, want the SPIFFE ID", client.URIs)
+	}
+	if len(client.Subject.OrganizationalUnit) != 1 || client.Subject.OrganizationalUnit[0] != "Publisher" {
+		t.Errorf("client units %v, want the Publisher role", client.Subject.OrganizationalUnit)
+	}
+	if len(client.ExtKeyUsage) != 1 || client.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("client extended key usages %v, want client auth only", client.ExtKeyUsage)
+	}
+	// A client certificate cannot authenticate a server.
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err := client.Verify(x509.VerifyOptions{Roots: roots}); err == nil {
+		t.Error("the client certificate verifies as a server certificate")
+	}
+
+	// A second CA does not trust them.
+	other, _, err := createCA("other", "ecdsa", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(other.cert)
+	if _, err := server.Verify(x509.VerifyOptions{Roots: otherRoots}); err == nil {
+		t.Error("the server certificate verifies against another CA")
+	}
+}
+
+func TestIssueKeyTypes(t *testing.T) {
+	for _, keyType := range []string{"rsa", "ecdsa", "ed25519"} {
+		c, _, err := createCA("test CA", keyType, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, _, err := c.issue(&leaf{commonName: "both", sans: []string{"localhost", "spiffe://productinfo.local/both"}, server: true, client: true, keyType: keyType, lifetime: time.Minute})
+		if err != nil {
+			t.Fatalf("%s: %v", keyType, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify(t, keyType+" server", cert, c.cert, x509.ExtKeyUsageServerAuth)
+		verify(t, keyType+" client", cert, c.cert, x509.ExtKeyUsageClientAuth)
+		// Only RSA keys encipher the TLS 1.2 key exchange.
+		if encipherment := cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0; encipherment != (keyType == "rsa") {
+			t.Errorf("%s: key usage %v", keyType, cert.KeyUsage)
+		}
+	}
+}
+
+func TestIssueErrors(t *testing.T) {
+	c, _, err := createCA("test CA", "ecdsa", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]*leaf{
+		"outlives the CA":          {commonName: "long", sans: []string{"localhost"}, server: true, keyType: "ecdsa", lifetime: 2 * time.Hour},
+		"server without a DNS SAN": {commonName: "localhost", sans: []string{"spiffe://productinfo.local/server"}, server: true, keyType: "ecdsa", lifetime: time.Minute},
+		"unknown key type":         {commonName: "dsa", client: true, keyType: "dsa", lifetime: time.Minute},
+	}
+	for name, l := range cases {
+		if _, _, err := c.issue(l); err == nil {
+			t.Errorf("%s: issued", name)
+		}
+	}
+}
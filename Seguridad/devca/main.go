@@ -0,0 +1,213 @@
+// A development CA for the samples of this chapter: it creates a local CA and
+// issues the server and client certificates the samples load, instead of
+// creating them by hand with openssl as in certs/README.md.
+// Execute go run devca/*.go init
+// Execute go run devca/*.go bundle mutual-tls
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// The CA: ca.crt and ca.key. Keep ca.key out of production.
+	caDir = filepath.Join("ch06", "devca", "ca")
+	// Where the samples of the chapter live, for bundle.
+	samplesDir   = "ch06"
+	caLifetime   = 10 * 365 * 24 * time.Hour
+	certLifetime = 365 * 24 * time.Hour
+)
+
+const usage = `usage: devca <command> [flags] [args]
+
+Commands:
+  init                     create the CA in -ca
+  issue <name>             issue name.crt, name.key and name.pem in -out
+  bundle <sample>          issue the certificates of a sample in its certs directory:
+                             secure-channel  ca.crt and a server certificate for localhost
+                             mutual-tls      ca.crt, a server certificate for localhost and a
+                                             client certificate with a SPIFFE ID
+
+Run devca <command> -h for the flags of each command.`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "init":
+		err = initCommand(args)
+	case "issue":
+		err = issueCommand(args)
+	case "bundle":
+		err = bundleCommand(args)
+	case "-h", "-help", "help":
+		fmt.Println(usage)
+	default:
+		err = fmt.Errorf("unknown command %q\n\n%s", cmd, usage)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// list is a flag of comma separated values.
+type list []string
+
+func (l *list) String() string { return strings.Join(*l, ",") }
+
+func (l *list) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func initCommand(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	dir := fs.String("ca", caDir, "directory of the CA")
+	cn := fs.String("cn", "gRPC samples development CA", "common name of the CA")
+	keyType := fs.String("key", "ecdsa", "key type: rsa, ecdsa or ed25519")
+	lifetime := fs.Duration("lifetime", caLifetime, "lifetime of the CA")
+	force := fs.Bool("force", false, "replace an existing CA; the certificates it issued stop being trusted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(*dir, "ca.key")); err == nil && !*force {
+		return fmt.Errorf("%s already has a CA, use -force to replace it", *dir)
+	}
+	c, der, err := createCA(*cn, *keyType, *lifetime)
+	if err != nil {
+		return err
+	}
+	k, err := keyPEM(c.key)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(*dir, "ca.key"), k, 0600); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(*dir, "ca.crt"), certPEM(der), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("CA %q in %s, valid until %s\n", *cn, *dir, c.cert.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func issueCommand(args []string) error {
+	fs := flag.NewFlagSet("issue", flag.ContinueOnError)
+	dir := fs.String("ca", caDir, "directory of the CA")
+	out := fs.String("out", ".", "directory of the new certificate")
+	cn := fs.String("cn", "", "common name, the name of the certificate by default")
+	var units, sans list
+	fs.Var(&units, "ou", "organizational units, comma separated: the roles of client certificates")
+	fs.Var(&sans, "san", "subject alternative names, comma separated: DNS names, IPs, emails and URIs like spiffe://productinfo.local/client")
+	use := fs.String("usage", "server", "usage of the certificate: server, client or both")
+	keyType := fs.String("key", "ecdsa", "key type: rsa, ecdsa or ed25519")
+	lifetime := fs.Duration("lifetime", certLifetime, "lifetime of the certificate")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("issue: missing the name of the certificate")
+	}
+	name := fs.Arg(0)
+	l := &leaf{commonName: *cn, units: units, sans: sans, keyType: *keyType, lifetime: *lifetime}
+	if l.commonName == "" {
+		l.commonName = name
+	}
+	switch *use {
+	case "server":
+		l.server = true
+	case "client":
+		l.client = true
+	case "both":
+		l.server, l.client = true, true
+	default:
+		return fmt.Errorf("unknown usage %q: use server, client or both", *use)
+	}
+	c, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+	return issueTo(c, *out, name, l)
+}
+
+func issueTo(c *ca, dir, name string, l *leaf) error {
+	der, key, err := c.issue(l)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if err := writeKeyPair(dir, name, der, key); err != nil {
+		return err
+	}
+	fmt.Printf("%s in %s, valid until %s\n", name, dir, time.Now().Add(l.lifetime).Format(time.RFC3339))
+	return nil
+}
+
+func bundleCommand(args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	dir := fs.String("ca", caDir, "directory of the CA")
+	samples := fs.String("samples", samplesDir, "directory of the samples")
+	keyType := fs.String("key", "ecdsa", "key type: rsa, ecdsa or ed25519")
+	lifetime := fs.Duration("lifetime", certLifetime, "lifetime of the certificates")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("bundle: missing the sample, secure-channel or mutual-tls")
+	}
+	c, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+	var out string
+	var leaves map[string]*leaf
+	server := &leaf{commonName: "localhost", sans: []string{"localhost", "127.0.0.1", "::1"}, server: true}
+	switch sample := fs.Arg(0); sample {
+	case "secure-channel":
+		out = filepath.Join(*samples, "secure-channel", "certs")
+		leaves = map[string]*leaf{"server": server}
+	case "mutual-tls":
+		out = filepath.Join(*samples, "mutual-tls-channel", "certs")
+		leaves = map[string]*leaf{
+			"server": server,
+			// The organizational unit is the role of the client in server/rbac.json,
+			// the SPIFFE ID what server/allowlist.json admits.
+			"client": {
+				commonName: "productinfo-client",
+				units:      []string{"Publisher"},
+				sans:       []string{"spiffe://productinfo.local/client/publisher"},
+				client:     true,
+			},
+		}
+	default:
+		return fmt.Errorf("unknown sample %q: use secure-channel or mutual-tls", sample)
+	}
+	if err := writeFile(filepath.Join(out, "ca.crt"), certPEM(c.cert.Raw), 0644); err != nil {
+		return err
+	}
+	for _, name := range []string{"server", "client"} {
+		l, ok := leaves[name]
+		if !ok {
+			continue
+		}
+		l.keyType, l.lifetime = *keyType, *lifetime
+		if err := issueTo(c, out, name, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,12 +7,10 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"google.golang.org/grpc/credentials"
-	"io/ioutil"
 	"log"
 	"path/filepath"
+	"seguridad/auth/tlsreload"
 	"time"
 
 	wrapper "github.com/golang/protobuf/ptypes/wrappers"
@@ -29,31 +27,18 @@ var (
 )
 
 func main() {
-	// Load the client certificates from disk
-	certificate, err := tls.LoadX509KeyPair(crtFile, keyFile)
+	// Load the client certificates and the certificates of the CA from disk.
+	// They are read again when the files change, and used from the next
+	// handshake on, when the connection is reestablished.
+	certs, err := tlsreload.New(crtFile, keyFile, caFile)
 	if err != nil {
-		log.Fatalf("could not load client key pair: %s", err)
-	}
-
-	// Create a certificate pool from the certificate authority
-	certPool := x509.NewCertPool()
-	ca, err := ioutil.ReadFile(caFile)
-	if err != nil {
-		log.Fatalf("could not read ca certificate: %s", err)
-	}
-
-	// Append the certificates from the CA
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		log.Fatalf("failed to append ca certs")
+		log.Fatalf("could not load certificates: %s", err)
 	}
+	go certs.Watch(tlsreload.DefaultInterval, nil)
 
 	opts := []grpc.DialOption{
 		// transport credentials.
-		grpc.WithTransportCredentials( credentials.NewTLS(&tls.Config{
-			ServerName:   hostname, // NOTE: this is required!
-			Certificates: []tls.Certificate{certificate},
-			RootCAs:      certPool,
-		})),
+		grpc.WithTransportCredentials( credentials.NewTLS(certs.ClientConfig(hostname))), // NOTE: the server name is required!
 	}
 
 	// Set up a connection to the server.
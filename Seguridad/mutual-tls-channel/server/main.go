@@ -8,17 +8,17 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	wrapper "github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/google/uuid"
 	pb "github.com/grpc-up-and-running/samples/ch02/productinfo/go/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"io/ioutil"
 	"log"
 	"net"
 	"path/filepath"
+	"seguridad/auth"
+	"seguridad/auth/tlsreload"
 )
 
 // server is used to implement ecommerce/product_info.
@@ -37,6 +37,9 @@ func (s *server) AddProduct(ctx context.Context, in *pb.Product) (*wrapper.Strin
 		s.productMap = make(map[string]*pb.Product)
 	}
 	s.productMap[in.Id] = in
+	if p, ok := auth.PeerFromContext(ctx); ok {
+		log.Printf("Product %s added by %s", in.Id, p.Name())
+	}
 	return &wrapper.StringValue{Value: in.Id}, nil
 }
 
@@ -54,35 +57,41 @@ var (
     crtFile = filepath.Join("ch06", "mutual-tls-channel", "certs", "server.crt")
     keyFile = filepath.Join("ch06", "mutual-tls-channel", "certs", "server.key")
     caFile = filepath.Join("ch06", "mutual-tls-channel", "certs", "ca.crt")
+    // Client certificates admitted, by SPIFFE ID, DNS name or common name.
+    allowlistFile = filepath.Join("ch06", "mutual-tls-channel", "server", "allowlist.json")
+    // Methods allowed to each organizational unit of the client certificates.
+    rbacFile = filepath.Join("ch06", "mutual-tls-channel", "server", "rbac.json")
 )
 
 func main() {
-	certificate, err := tls.LoadX509KeyPair(crtFile, keyFile)
+	// The key pair and the certificates of the CA that signs the client
+	// certificates are read again when the files change.
+	certs, err := tlsreload.New(crtFile, keyFile, caFile)
 	if err != nil {
-		log.Fatalf("failed to load key pair: %s", err)
+		log.Fatalf("failed to load certificates: %s", err)
 	}
+	go certs.Watch(tlsreload.DefaultInterval, nil)
 
-	// Create a certificate pool from the certificate authority
-	certPool := x509.NewCertPool()
-	ca, err := ioutil.ReadFile(caFile)
+	policy, err := auth.LoadPolicy(rbacFile)
 	if err != nil {
-		log.Fatalf("could not read ca certificate: %s", err)
+		log.Fatalf("failed to load RBAC policy: %s", err)
 	}
-
-	// Append the client certificates from the CA
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		log.Fatalf("failed to append client certs")
+	allowlist, err := auth.LoadAllowlist(allowlistFile)
+	if err != nil {
+		log.Fatalf("failed to load allowlist: %s", err)
 	}
-
+	authn := auth.NewInterceptor(auth.TLSPeer, auth.HealthAndReflection...)
+	allow := auth.NewInterceptor(allowlist.Authorize, auth.HealthAndReflection...)
+	authz := auth.NewInterceptor(policy.Authorize, auth.HealthAndReflection...)
 	opts := []grpc.ServerOption{
 		// Enable TLS for all incoming connections.
 		grpc.Creds(    // Create the TLS credentials
-			credentials.NewTLS(&tls.Config {
-				ClientAuth:   tls.RequireAndVerifyClientCert,
-				Certificates: []tls.Certificate{certificate},
-				ClientCAs:    certPool,
-				},
-			)),
+			credentials.NewTLS(certs.ServerConfig(tls.RequireAndVerifyClientCert))),
+
+		// The identity of the client certificate, then the allowlist, which logs
+		// every call, then the roles of the organizational units.
+		grpc.ChainUnaryInterceptor(authn.UnaryServerInterceptor(), allow.UnaryServerInterceptor(), authz.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(authn.StreamServerInterceptor(), allow.StreamServerInterceptor(), authz.StreamServerInterceptor()),
 	}
 
 	s := grpc.NewServer(opts...)
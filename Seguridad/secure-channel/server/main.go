@@ -17,9 +17,10 @@ import (
 	"log"
 	"net"
 	"path/filepath"
+	"seguridad/auth/tlsreload"
 )
 
-const (
+var (
 	port = ":50051"
 	crtFile = filepath.Join("ch06", "secure-channel", "certs", "server.crt")
 	keyFile = filepath.Join("ch06", "secure-channel", "certs", "server.key")
@@ -54,13 +55,16 @@ func (s *server) GetProduct(ctx context.Context, in *wrapper.StringValue) (*pb.P
 }
 
 func main() {
-	cert, err := tls.LoadX509KeyPair(crtFile, keyFile)
+	// The key pair is read again when the files change, so the certificate
+	// can be rotated without a restart.
+	certs, err := tlsreload.New(crtFile, keyFile, "")
 	if err != nil {
 		log.Fatalf("failed to load key pair: %s", err)
 	}
+	go certs.Watch(tlsreload.DefaultInterval, nil)
 	opts := []grpc.ServerOption{
 		// Enable TLS for all incoming connections.
-		grpc.Creds(credentials.NewServerTLSFromCert(&cert)),
+		grpc.Creds(credentials.NewTLS(certs.ServerConfig(tls.NoClientCert))),
 	}
 
 	s := grpc.NewServer(opts...)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// signingKey is a key the server signs tokens with. Once retired it only stays
+// published until the last token it signed has expired.
+type signingKey struct {
+	jwk     jose.JSONWebKey
+	signer  jose.Signer
+	retired time.Time
+}
+
+// keyRing holds the current signing key and the retired keys that still verify
+// live tokens. Keys only live in memory: restarting the server invalidates the
+// tokens it has issued, and clients just ask for new ones.
+type keyRing struct {
+	// keep is how long a retired key stays published: the token lifetime plus
+	// the clock skew allowed by the resource servers.
+	keep time.Duration
+	// now is time.Now, except in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	current *signingKey
+	retired []*signingKey
+}
+
+func newKeyRing(keep time.Duration) (*keyRing, error) {
+	r := &keyRing{keep: keep, now: time.Now}
+	if err := r.rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// rotate signs the next tokens with a new key and drops the retired keys
+// nobody needs any more.
+func (r *keyRing) rotate() error {
+	key, err := newSigningKey()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if r.current != nil {
+		r.current.retired = now
+		r.retired = append(r.retired, r.current)
+	}
+	r.current = key
+	kept := r.retired[:0]
+	for _, k := range r.retired {
+		if now.Sub(k.retired) < r.keep {
+			kept = append(kept, k)
+		}
+	}
+	r.retired = kept
+	log.Printf("signing with key %s, %d retired keys published", key.jwk.KeyID, len(r.retired))
+	return nil
+}
+
+// rotateEvery rotates the keys until the process exits.
+func (r *keyRing) rotateEvery(d time.Duration) {
+	for range time.Tick(d) {
+		if err := r.rotate(); err != nil {
+			log.Printf("failed to rotate signing key: %v", err)
+		}
+	}
+}
+
+// signer returns the signer of the current key.
+func (r *keyRing) signer() jose.Signer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current.signer
+}
+
+// jwks returns the public keys of the current key and of the retired keys
+// that may still verify a live token.
+func (r *keyRing) jwks() jose.JSONWebKeySet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{r.current.jwk.Public()}}
+	for _, k := range r.retired {
+		if now.Sub(k.retired) < r.keep {
+			set.Keys = append(set.Keys, k.jwk.Public())
+		}
+	}
+	return set
+}
+
+// newSigningKey generates an ECDSA P-256 key, which is quick to generate and
+// gives short tokens.
+func newSigningKey() (*signingKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	jwk := jose.JSONWebKey{Key: priv, KeyID: hex.EncodeToString(id), Algorithm: string(jose.ES256), Use: "sig"}
+	opts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: priv}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{jwk: jwk, signer: signer}, nil
+}
@@ -0,0 +1,73 @@
+// A local OAuth2 token server, to try the token-based-authentication sample
+// with short-lived tokens and without external services.
+// Execute go run authserver/*.go
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"seguridad/auth/tlsreload"
+	"time"
+)
+
+var (
+	port = ":9443"
+	crtFile = filepath.Join("ch06", "secure-channel", "certs", "server.crt")
+	keyFile = filepath.Join("ch06", "secure-channel", "certs", "server.key")
+	clientsFile = filepath.Join("ch06", "token-based-authentication", "authserver", "clients.json")
+	issuer = "https://auth.productinfo.local"
+	// Access tokens are short-lived; clients refresh them before they expire.
+	accessLifetime = time.Minute
+	refreshLifetime = 24 * time.Hour
+	rotateEvery = 10 * time.Minute
+	// Clock skew allowed by the resource servers, see ../server.
+	clockSkew = 30 * time.Second
+)
+
+func main() {
+	clients, err := loadClients(clientsFile)
+	if err != nil {
+		log.Fatalf("failed to load clients: %v", err)
+	}
+	keys, err := newKeyRing(accessLifetime + clockSkew)
+	if err != nil {
+		log.Fatalf("failed to create signing key: %v", err)
+	}
+	go keys.rotateEvery(rotateEvery)
+
+	mux := http.NewServeMux()
+	mux.Handle("/token", &tokenServer{
+		issuer:          issuer,
+		accessLifetime:  accessLifetime,
+		refreshLifetime: refreshLifetime,
+		keys:            keys,
+		clients:         clients,
+		now:             time.Now,
+		refresh:         make(map[string]*refreshGrant),
+	})
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(keys.jwks()); err != nil {
+			log.Printf("failed to write JWKS: %v", err)
+		}
+	})
+
+	// The certificate is read again when the files change.
+	certs, err := tlsreload.New(crtFile, keyFile, "")
+	if err != nil {
+		log.Fatalf("failed to load key pair: %v", err)
+	}
+	go certs.Watch(tlsreload.DefaultInterval, nil)
+	tlsConfig := certs.ServerConfig(tls.NoClientCert)
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	srv := &http.Server{Addr: port, Handler: mux, TLSConfig: tlsConfig}
+
+	log.Printf("token server listening on %s", port)
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// client is a client registered in the clients file.
+type client struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	// Scopes the client may ask for. A request without scope gets all of them.
+	Scopes []string `json:"scopes"`
+	// Audience of its tokens, the resource servers that accept them.
+	Audience []string `json:"audience"`
+	// Roles in the "roles" claim of its tokens, for the RBAC policy of the
+	// resource servers.
+	Roles []string `json:"roles"`
+}
+
+func loadClients(path string) (map[string]*client, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*client
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	clients := make(map[string]*client)
+	for _, c := range list {
+		if c.ID == "" || c.Secret == "" {
+			return nil, fmt.Errorf("%s: every client needs an id and a secret", path)
+		}
+		clients[c.ID] = c
+	}
+	return clients, nil
+}
+
+// refreshGrant is what a refresh token stands for. Refresh tokens are single
+// use: each refresh returns a new one.
+type refreshGrant struct {
+	clientID string
+	scope    []string
+	expires  time.Time
+}
+
+// tokenServer implements the token endpoint of RFC 6749 for the
+// client_credentials and refresh_token grants.
+type tokenServer struct {
+	issuer          string
+	accessLifetime  time.Duration
+	refreshLifetime time.Duration
+	keys            *keyRing
+	clients         map[string]*client
+	// now is time.Now, except in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	refresh map[string]*refreshGrant
+}
+
+// tokenResponse is the successful response of the token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// tokenError is an error of the token endpoint, as defined in section 5.2 of
+// RFC 6749.
+type tokenError struct {
+	status      int
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, &tokenError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+	resp, terr := s.token(r)
+	if terr != nil {
+		log.Printf("token request rejected: %s: %s", terr.Code, terr.Description)
+		if terr.status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeJSON(w, terr.status, terr)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *tokenServer) token(r *http.Request) (*tokenResponse, *tokenError) {
+	c, terr := s.authenticate(r)
+	if terr != nil {
+		return nil, terr
+	}
+	requested := strings.Fields(r.PostForm.Get("scope"))
+	switch grant := r.PostForm.Get("grant_type"); grant {
+	case "client_credentials":
+		scope := c.Scopes
+		if len(requested) > 0 {
+			if !subset(requested, c.Scopes) {
+				return nil, &tokenError{http.StatusBadRequest, "invalid_scope", "scope not allowed for this client"}
+			}
+			scope = requested
+		}
+		return s.issue(c, scope)
+	case "refresh_token":
+		g, terr := s.redeem(c, r.PostForm.Get("refresh_token"))
+		if terr != nil {
+			return nil, terr
+		}
+		scope := g.scope
+		if len(requested) > 0 {
+			// A refresh may narrow the scope, never widen it.
+			if !subset(requested, g.scope) {
+				return nil, &tokenError{http.StatusBadRequest, "invalid_scope", "scope wider than the original grant"}
+			}
+			scope = requested
+		}
+		return s.issue(c, scope)
+	case "":
+		return nil, &tokenError{http.StatusBadRequest, "invalid_request", "missing grant_type"}
+	default:
+		return nil, &tokenError{http.StatusBadRequest, "unsupported_grant_type", grant}
+	}
+}
+
+// authenticate checks the client credentials, sent with HTTP basic
+// authentication or in the form.
+func (s *tokenServer) authenticate(r *http.Request) (*client, *tokenError) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// The credentials are form encoded before going into the header.
+		var err1, err2 error
+		id, err1 = url.QueryUnescape(id)
+		secret, err2 = url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			return nil, &tokenError{http.StatusBadRequest, "invalid_request", "malformed client credentials"}
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	c, known := s.clients[id]
+	if !known {
+		// Compare anyway, so the response time does not tell which clients exist.
+		c = &client{Secret: "\x00"}
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) != 1 || !known {
+		return nil, &tokenError{http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret"}
+	}
+	return c, nil
+}
+
+// redeem consumes a refresh token of the client.
+func (s *tokenServer) redeem(c *client, token string) (*refreshGrant, *tokenError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.refresh[token]
+	if !ok || g.clientID != c.ID {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_grant", "unknown refresh token"}
+	}
+	delete(s.refresh, token)
+	if s.now().After(g.expires) {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_grant", "refresh token expired"}
+	}
+	return g, nil
+}
+
+// issue signs an access token and creates a refresh token for the client.
+func (s *tokenServer) issue(c *client, scope []string) (*tokenResponse, *tokenError) {
+	now := s.now()
+	claims := struct {
+		jwt.Claims
+		Scope    string   `json:"scope,omitempty"`
+		Roles    []string `json:"roles,omitempty"`
+		ClientID string   `json:"client_id"`
+	}{
+		Claims: jwt.Claims{
+			Issuer:    s.issuer,
+			Subject:   c.ID,
+			Audience:  jwt.Audience(c.Audience),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Expiry:    jwt.NewNumericDate(now.Add(s.accessLifetime)),
+			ID:        randomToken(),
+		},
+		Scope:    strings.Join(scope, " "),
+		Roles:    c.Roles,
+		ClientID: c.ID,
+	}
+	raw, err := jwt.Signed(s.keys.signer()).Claims(claims).CompactSerialize()
+	if err != nil {
+		log.Printf("failed to sign token: %v", err)
+		return nil, &tokenError{http.StatusInternalServerError, "server_error", ""}
+	}
+
+	refresh := randomToken()
+	s.mu.Lock()
+	for t, g := range s.refresh {
+		if now.After(g.expires) {
+			delete(s.refresh, t)
+		}
+	}
+	s.refresh[refresh] = &refreshGrant{clientID: c.ID, scope: scope, expires: now.Add(s.refreshLifetime)}
+	s.mu.Unlock()
+
+	log.Printf("issued token %s to %s, scope %q", claims.ID, c.ID, claims.Scope)
+	return &tokenResponse{
+		AccessToken:  raw,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessLifetime / time.Second),
+		RefreshToken: refresh,
+		Scope:        claims.Scope,
+	}, nil
+}
+
+// subset reports whether every scope in want is in have.
+func subset(want, have []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if w == h {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on the supported platforms.
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	// Tokens must not be cached, see section 5.1 of RFC 6749.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
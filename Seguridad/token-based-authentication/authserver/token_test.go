@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const testIssuer = "https://auth.productinfo.local"
+
+// testTokenServer returns a token server with one client and a clock the test
+// controls.
+func testTokenServer(t *testing.T, now *time.Time) *tokenServer {
+	t.Helper()
+	keys, err := newKeyRing(time.Minute + 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.now = func() time.Time { return *now }
+	return &tokenServer{
+		issuer:          testIssuer,
+		accessLifetime:  time.Minute,
+		refreshLifetime: time.Hour,
+		keys:            keys,
+		clients: map[string]*client{"publisher": {
+			ID:       "publisher",
+			Secret:   "s3cret",
+			Scopes:   []string{"products:read", "products:write"},
+			Audience: []string{"productinfo"},
+			Roles:    []string{"writer"},
+		}},
+		now:     func() time.Time { return *now },
+		refresh: make(map[string]*refreshGrant),
+	}
+}
+
+// post sends a token request with the credentials of the client in the form,
+// and decodes the response or the error.
+func post(t *testing.T, s *tokenServer, form url.Values) (int, *tokenResponse, *tokenError) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		var terr tokenError
+		if err := json.NewDecoder(w.Body).Decode(&terr); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, nil, &terr
+	}
+	var resp tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, &resp, nil
+}
+
+func credentials(kv ...string) url.Values {
+	form := url.Values{"client_id": {"publisher"}, "client_secret": {"s3cret"}}
+	for i := 0; i+1 < len(kv); i += 2 {
+		form.Set(kv[i], kv[i+1])
+	}
+	return form
+}
+
+// tokenClaims are the claims the token server puts in its tokens.
+type tokenClaims struct {
+	jwt.Claims
+	Scope    string   `json:"scope"`
+	Roles    []string `json:"roles"`
+	ClientID string   `json:"client_id"`
+}
+
+// verify checks the access token with the JWKS of the token server and returns
+// its claims.
+func verify(t *testing.T, s *tokenServer, raw string) *tokenClaims {
+	t.Helper()
+	tok, err := jwt.ParseSigned(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := s.keys.jwks()
+	keys := set.Key(tok.Headers[0].KeyID)
+	if len(keys) == 0 {
+		t.Fatalf("key %q of the token is not in the JWKS", tok.Headers[0].KeyID)
+	}
+	var claims tokenClaims
+	if err := tok.Claims(keys[0].Key, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return &claims
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	now := time.Now()
+	s := testTokenServer(t, &now)
+
+	code, resp, terr := post(t, s, credentials("grant_type", "client_credentials"))
+	if code != http.StatusOK {
+		t.Fatalf("%d: %+v", code, terr)
+	}
+	if resp.TokenType != "Bearer" || resp.ExpiresIn != 60 || resp.RefreshToken == "" {
+		t.Errorf("response %+v", resp)
+	}
+	claims := verify(t, s, resp.AccessToken)
+	if claims.Issuer != testIssuer || claims.Subject != "publisher" || claims.ClientID != "publisher" ||
+		!claims.Audience.Contains("productinfo") || len(claims.Roles) != 1 || claims.Roles[0] != "writer" {
+		t.Errorf("claims %+v", claims)
+	}
+	// Without scope the token gets every scope of the client.
+	if claims.Scope != "products:read products:write" || resp.Scope != claims.Scope {
+		t.Errorf("scope %q, response scope %q", claims.Scope, resp.Scope)
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: testIssuer, Time: now.Add(59 * time.Second)}, 0); err != nil {
+		t.Errorf("token before its expiry: %v", err)
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: testIssuer, Time: now.Add(61 * time.Second)}, 0); err == nil {
+		t.Error("token valid after its lifetime")
+	}
+
+	// With HTTP basic authentication, form encoded.
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"products:read"}}
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(url.QueryEscape("publisher"), url.QueryEscape("s3cret"))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("basic authentication: %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestInvalidClient(t *testing.T) {
+	now := time.Now()
+	s := testTokenServer(t, &now)
+	cases := map[string]url.Values{
+		"wrong secret":   {"grant_type": {"client_credentials"}, "client_id": {"publisher"}, "client_secret": {"wrong"}},
+		"unknown client": {"grant_type": {"client_credentials"}, "client_id": {"nobody"}, "client_secret": {"s3cret"}},
+		"no credentials": {"grant_type": {"client_credentials"}},
+	}
+	for name, form := range cases {
+		code, _, terr := post(t, s, form)
+		if code != http.StatusUnauthorized || terr.Code != "invalid_client" {
+			t.Errorf("%s: %d %+v, want 401 invalid_client", name, code, terr)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader("grant_type=client_credentials"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("publisher", "wrong")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("basic authentication with a wrong secret: %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestRefreshTokenSingleUse(t *testing.T) {
+	now := time.Now()
+	s := testTokenServer(t, &now)
+	_, first, _ := post(t, s, credentials("grant_type", "client_credentials"))
+
+	code, second, terr := post(t, s, credentials("grant_type", "refresh_token", "refresh_token", first.RefreshToken))
+	if code != http.StatusOK {
+		t.Fatalf("refresh: %d %+v", code, terr)
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Errorf("refresh returns refresh token %q, want a new one", second.RefreshToken)
+	}
+
+	// The refresh token is spent: using it again fails.
+	if code, _, terr := post(t, s, credentials("grant_type", "refresh_token", "refresh_token", first.RefreshToken)); code != http.StatusBadRequest || terr.Code != "invalid_grant" {
+		t.Errorf("reused refresh token: %d %+v, want invalid_grant", code, terr)
+	}
+
+	// Past its lifetime the refresh token is rejected.
+	now = now.Add(time.Hour + time.Second)
+	if code, _, terr := post(t, s, credentials("grant_type", "refresh_token", "refresh_token", second.RefreshToken)); code != http.StatusBadRequest || terr.Code != "invalid_grant" {
+		t.Errorf("expired refresh token: %d %+v, want invalid_grant", code, terr)
+	}
+}
+
+func TestRefreshTokenOfAnotherClient(t *testing.T) {
+	now := time.Now()
+	s := testTokenServer(t, &now)
+	s.clients["other"] = &client{ID: "other", Secret: "other", Scopes: []string{"products:read"}}
+	_, resp, _ := post(t, s, credentials("grant_type", "client_credentials"))
+
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {resp.RefreshToken}, "client_id": {"other"}, "client_secret": {"other"}}
+	if code, _, terr := post(t, s, form); code != http.StatusBadRequest || terr.Code != "invalid_grant" {
+		t.Errorf("refresh token of another client: %d %+v, want invalid_grant", code, terr)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	now := time.Now()
+	s := testTokenServer(t, &now)
+
+	// client_credentials: only scopes of the client.
+	if code, _, terr := post(t, s, credentials("grant_type", "client_credentials", "scope", "products:read orders:read")); code != http.StatusBadRequest || terr.Code != "invalid_scope" {
+		t.Errorf("scope of another client: %d %+v, want invalid_scope", code, terr)
+	}
+	_, resp, _ := post(t, s, credentials("grant_type", "client_credentials", "scope", "products:read products:write"))
+
+	// A refresh may narrow the scope...
+	code, narrow, terr := post(t, s, credentials("grant_type", "refresh_token", "refresh_token", resp.RefreshToken, "scope", "products:read"))
+	if code != http.StatusOK {
+		t.Fatalf("narrower scope: %d %+v", code, terr)
+	}
+	if c := verify(t, s, narrow.AccessToken); c.Scope != "products:read" {
+		t.Errorf("narrowed token scope %q", c.Scope)
+	}
+
+	// ...but not widen it again, not even to the scopes of the client.
+	if code, _, terr := post(t, s, credentials("grant_type", "refresh_token", "refresh_token", narrow.RefreshToken, "scope", "products:read products:write")); code != http.StatusBadRequest || terr.Code != "invalid_scope" {
+		t.Errorf("wider scope: %d %+v, want invalid_scope", code, terr)
+	}
+}
+
+func TestGrantTypes(t *testing.T) {
+	now := time.Now()
+	s := testTokenServer(t, &now)
+	cases := map[string]string{"": "invalid_request", "password": "unsupported_grant_type"}
+	for grant, want := range cases {
+		if code, _, terr := post(t, s, credentials("grant_type", grant)); code != http.StatusBadRequest || terr.Code != want {
+			t.Errorf("grant_type %q: %d %+v, want %s", grant, code, terr, want)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/token", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: %d, want 405", w.Code)
+	}
+}
+
+func kids(set jose.JSONWebKeySet) []string {
+	var ids []string
+	for _, k := range set.Keys {
+		ids = append(ids, k.KeyID)
+	}
+	return ids
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Now()
+	s := testTokenServer(t, &now)
+	keep := s.keys.keep
+
+	_, resp, _ := post(t, s, credentials("grant_type", "client_credentials"))
+	first := s.keys.jwks().Keys[0].KeyID
+
+	// After a rotation new tokens use the new key, and the old one stays
+	// published for the tokens it signed.
+	now = now.Add(10 * time.Second)
+	if err := s.keys.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	set := s.keys.jwks()
+	if len(set.Keys) != 2 || set.Keys[1].KeyID != first {
+		t.Fatalf("JWKS after a rotation %v, want the new key and %s", kids(set), first)
+	}
+	for _, k := range set.Keys {
+		if !k.IsPublic() {
+			t.Fatalf("JWKS publishes the private key %s", k.KeyID)
+		}
+	}
+	verify(t, s, resp.AccessToken)
+	_, resp2, _ := post(t, s, credentials("grant_type", "client_credentials"))
+	if tok, _ := jwt.ParseSigned(resp2.AccessToken); tok.Headers[0].KeyID == first {
+		t.Error("new token signed with the retired key")
+	}
+
+	// Once every token it signed has expired, the retired key leaves the JWKS,
+	// even before the next rotation.
+	now = now.Add(keep - time.Second)
+	if set := s.keys.jwks(); len(set.Keys) != 2 {
+		t.Errorf("JWKS %v before keep, want the retired key", kids(set))
+	}
+	now = now.Add(2 * time.Second)
+	if set := s.keys.jwks(); len(set.Keys) != 1 || set.Keys[0].KeyID == first {
+		t.Errorf("JWKS %v after keep, want only the current key", kids(set))
+	}
+	if err := s.keys.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.keys.retired); n != 1 {
+		t.Errorf("%d retired keys after the rotation, want 1", n)
+	}
+}
@@ -19,15 +19,24 @@ import (
 	"google.golang.org/grpc"
 )
 
-const (
+var (
 	address = "localhost:50051"
 	hostname = "localhost"
 	crtFile = filepath.Join("ch06", "secure-channel", "certs", "server.crt")
 )
 
+var (
+	// Where the token comes from: a literal token, a token file, a token server or a key to mint tokens.
+	tokenFile = filepath.Join("ch06", "token-based-authentication", "client", "token.json")
+)
+
 func main() {
 	// Set up the credentials for the connection.
-	perRPC := oauth.NewOauthAccess(fetchToken())
+	src, err := fetchToken()
+	if err != nil {
+		log.Fatalf("failed to load token: %v", err)
+	}
+	perRPC := oauth.TokenSource{TokenSource: src}
 
 	creds, err := credentials.NewClientTLSFromFile(crtFile, hostname)
 	if err != nil {
@@ -66,8 +75,6 @@ func main() {
 	log.Printf("Product: ", product.String())
 }
 
-func fetchToken() *oauth2.Token {
-	return &oauth2.Token{
-		AccessToken: "some-secret-token",
-	}
+func fetchToken() (oauth2.TokenSource, error) {
+	return loadTokenSource(tokenFile)
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// tokenConfig tells the client where its token comes from. The first option
+// set wins: a literal token, a file with a token, a token server, or a key to
+// mint tokens with.
+type tokenConfig struct {
+	Token      string            `json:"token"`
+	TokenFile  string            `json:"tokenFile"`
+	AuthServer *authServerConfig `json:"authServer"`
+	Mint       *mintConfig       `json:"mint"`
+}
+
+// authServerConfig are the settings to get tokens from an OAuth2 token server
+// with the client credentials grant.
+type authServerConfig struct {
+	TokenURL     string   `json:"tokenUrl"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+	// CAFile is the certificate of the token server, or of its CA.
+	CAFile string `json:"caFile"`
+}
+
+// mintConfig are the settings to mint self-signed tokens. The server must have
+// the public key of KeyFile in its JWKS.
+type mintConfig struct {
+	KeyFile  string   `json:"keyFile"`
+	KeyID    string   `json:"keyId"`
+	Issuer   string   `json:"issuer"`
+	Subject  string   `json:"subject"`
+	Audience []string `json:"audience"`
+	Scope    string   `json:"scope"`
+	Roles    []string `json:"roles"`
+	// Lifetime of each token, as a Go duration. Defaults to five minutes.
+	Lifetime string `json:"lifetime"`
+}
+
+// loadTokenSource reads the token configuration. Minted tokens are renewed
+// shortly before they expire.
+func loadTokenSource(path string) (oauth2.TokenSource, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg tokenConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	switch {
+	case cfg.Token != "":
+		return staticToken(cfg.Token)
+	case cfg.TokenFile != "":
+		b, err := ioutil.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		return staticToken(strings.TrimSpace(string(b)))
+	case cfg.AuthServer != nil:
+		src, err := newAuthServerSource(*cfg.AuthServer)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return oauth2.ReuseTokenSource(nil, src), nil
+	case cfg.Mint != nil:
+		m, err := newMinter(*cfg.Mint)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return oauth2.ReuseTokenSource(nil, m), nil
+	}
+	return nil, fmt.Errorf("%s: no token, tokenFile, authServer or mint", path)
+}
+
+// staticToken returns a source that always returns raw. The expiry is read
+// from the token without verifying it, only to fail early with an expired token.
+func staticToken(raw string) (oauth2.TokenSource, error) {
+	tok, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %v", err)
+	}
+	var claims jwt.Claims
+	if err := tok.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, fmt.Errorf("malformed token: %v", err)
+	}
+	t := &oauth2.Token{AccessToken: raw, TokenType: "Bearer"}
+	if claims.Expiry != nil {
+		t.Expiry = claims.Expiry.Time()
+		if !t.Valid() {
+			return nil, fmt.Errorf("token expired at %v", t.Expiry)
+		}
+	}
+	return oauth2.StaticTokenSource(t), nil
+}
+
+// authServerSource gets tokens from a token server. It refreshes the token with
+// the refresh token of the last response, and falls back to the client
+// credentials when there is none or the server rejects it, for example because
+// it has been restarted. oauth2.ReuseTokenSource asks for a new token a few
+// seconds before the current one expires.
+type authServerSource struct {
+	ctx          context.Context
+	credentials  *clientcredentials.Config
+	config       *oauth2.Config
+	refreshToken string
+}
+
+func newAuthServerSource(cfg authServerConfig) (*authServerSource, error) {
+	if cfg.TokenURL == "" || cfg.ClientID == "" {
+		return nil, errors.New("authServer needs tokenUrl and clientId")
+	}
+	ctx := context.Background()
+	if cfg.CAFile != "" {
+		client, err := httpsClient(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+	return &authServerSource{
+		ctx: ctx,
+		credentials: &clientcredentials.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			TokenURL:     cfg.TokenURL,
+			Scopes:       cfg.Scopes,
+		},
+		config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     oauth2.Endpoint{TokenURL: cfg.TokenURL},
+		},
+	}, nil
+}
+
+// Token implements oauth2.TokenSource. oauth2.ReuseTokenSource serializes the
+// calls.
+func (s *authServerSource) Token() (*oauth2.Token, error) {
+	if s.refreshToken != "" {
+		t, err := s.config.TokenSource(s.ctx, &oauth2.Token{RefreshToken: s.refreshToken}).Token()
+		if err == nil {
+			s.refreshToken = t.RefreshToken
+			return t, nil
+		}
+		log.Printf("failed to refresh token, using client credentials: %v", err)
+		s.refreshToken = ""
+	}
+	t, err := s.credentials.Token(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.refreshToken = t.RefreshToken
+	return t, nil
+}
+
+// httpsClient returns a client that trusts the certificates in caFile.
+func httpsClient(caFile string) (*http.Client, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: no certificates", caFile)
+	}
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}, nil
+}
+
+// minter signs a new token each time it is asked for one.
+type minter struct {
+	cfg      mintConfig
+	lifetime time.Duration
+	signer   jose.Signer
+}
+
+func newMinter(cfg mintConfig) (*minter, error) {
+	key, alg, err := loadSigningKey(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	lifetime := 5 * time.Minute
+	if cfg.Lifetime != "" {
+		if lifetime, err = time.ParseDuration(cfg.Lifetime); err != nil {
+			return nil, fmt.Errorf("lifetime: %v", err)
+		}
+	}
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if cfg.KeyID != "" {
+		opts = opts.WithHeader("kid", cfg.KeyID)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &minter{cfg: cfg, lifetime: lifetime, signer: signer}, nil
+}
+
+// Token implements oauth2.TokenSource.
+func (m *minter) Token() (*oauth2.Token, error) {
+	now := time.Now()
+	claims := struct {
+		jwt.Claims
+		Scope string   `json:"scope,omitempty"`
+		Roles []string `json:"roles,omitempty"`
+	}{
+		Claims: jwt.Claims{
+			Issuer:    m.cfg.Issuer,
+			Subject:   m.cfg.Subject,
+			Audience:  jwt.Audience(m.cfg.Audience),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Expiry:    jwt.NewNumericDate(now.Add(m.lifetime)),
+		},
+		Scope: m.cfg.Scope,
+		Roles: m.cfg.Roles,
+	}
+	raw, err := jwt.Signed(m.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return nil, err
+	}
+	// Renew a little before the server would reject the token.
+	return &oauth2.Token{AccessToken: raw, TokenType: "Bearer", Expiry: now.Add(m.lifetime - 10*time.Second)}, nil
+}
+
+// loadSigningKey reads a PEM private key and picks the matching algorithm.
+func loadSigningKey(path string) (crypto.Signer, jose.SignatureAlgorithm, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, "", fmt.Errorf("%s: no PEM data", path)
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, "", errors.New(path + ": unsupported key type")
+	}
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		return signer, jose.RS256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return signer, jose.ES256, nil
+		case elliptic.P384():
+			return signer, jose.ES384, nil
+		case elliptic.P521():
+			return signer, jose.ES512, nil
+		}
+	case ed25519.PublicKey:
+		return signer, jose.EdDSA, nil
+	}
+	return nil, "", errors.New(path + ": unsupported key type")
+}
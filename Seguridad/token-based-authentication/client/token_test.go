@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeTokenServer issues opaque tokens and records the grants it is asked for.
+// Each refresh token is accepted once, unless reject is set.
+type fakeTokenServer struct {
+	mu      sync.Mutex
+	grants  []string
+	issued  int
+	refresh map[string]bool
+	reject  bool
+}
+
+func (s *fakeTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if id != "publisher" || secret != "s3cret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	grant := r.PostFormValue("grant_type")
+	s.grants = append(s.grants, grant)
+	if grant == "refresh_token" {
+		rt := r.PostFormValue("refresh_token")
+		if s.reject || !s.refresh[rt] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(s.refresh, rt)
+	}
+	s.issued++
+	rt := fmt.Sprintf("refresh-%d", s.issued)
+	s.refresh[rt] = true
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  fmt.Sprintf("access-%d", s.issued),
+		"token_type":    "Bearer",
+		"expires_in":    60,
+		"refresh_token": rt,
+	})
+}
+
+// last returns the grant of the last request.
+func (s *fakeTokenServer) last() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.grants[len(s.grants)-1]
+}
+
+func TestAuthServerSource(t *testing.T) {
+	fake := &fakeTokenServer{refresh: make(map[string]bool)}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	src, err := newAuthServerSource(authServerConfig{
+		TokenURL:     ts.URL,
+		ClientID:     "publisher",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"products:read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first token comes from the client credentials.
+	tok, err := src.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.last() != "client_credentials" || tok.AccessToken != "access-1" {
+		t.Fatalf("first token %q with grant %s, want client_credentials", tok.AccessToken, fake.last())
+	}
+
+	// The next one refreshes it, and keeps the new refresh token.
+	if tok, err = src.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.last() != "refresh_token" || tok.AccessToken != "access-2" {
+		t.Fatalf("second token %q with grant %s, want refresh_token", tok.AccessToken, fake.last())
+	}
+	if tok, err = src.Token(); err != nil || fake.last() != "refresh_token" {
+		t.Fatalf("third token: %v with grant %s, want refresh_token with the new refresh token", err, fake.last())
+	}
+
+	// When the server rejects the refresh token, for example after a restart,
+	// the source gets a new token with the client credentials.
+	fake.reject = true
+	if tok, err = src.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.last() != "client_credentials" || tok.AccessToken != "access-4" {
+		t.Fatalf("token %q with grant %s after a rejected refresh, want client_credentials", tok.AccessToken, fake.last())
+	}
+}
+
+func TestAuthServerSourceInvalidClient(t *testing.T) {
+	fake := &fakeTokenServer{refresh: make(map[string]bool)}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	src, err := newAuthServerSource(authServerConfig{TokenURL: ts.URL, ClientID: "publisher", ClientSecret: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Token(); err == nil {
+		t.Error("token with a wrong client secret")
+	}
+	if _, err := newAuthServerSource(authServerConfig{ClientID: "publisher"}); err == nil {
+		t.Error("token source without tokenUrl")
+	}
+}
@@ -0,0 +1,94 @@
+// Writes the JSON Web Key Set with the public key of a signing key.
+// Execute go run ch06/token-based-authentication/jwt/jwks -key signing.key -kid productinfo-1 > jwks.json
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+func main() {
+	keyFile := flag.String("key", "signing.key", "PEM private key the client signs tokens with")
+	keyID := flag.String("kid", "productinfo-1", "key id, the keyId of the mint settings of the client")
+	flag.Parse()
+
+	key, err := loadKey(*keyFile)
+	if err != nil {
+		log.Fatalf("failed to load signing key: %v", err)
+	}
+	alg, err := algorithm(key.Public())
+	if err != nil {
+		log.Fatalf("%s: %v", *keyFile, err)
+	}
+	// Only the public key: the server must never get the private one.
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: *keyID, Algorithm: string(alg), Use: "sig"}}}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadKey reads a PEM private key, as generated with openssl genpkey.
+func loadKey(path string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type", path)
+	}
+	return signer, nil
+}
+
+// algorithm is the algorithm the client signs with for the key, see
+// loadSigningKey in the client.
+func algorithm(pub crypto.PublicKey) (jose.SignatureAlgorithm, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return jose.RS256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	case ed25519.PublicKey:
+		return jose.EdDSA, nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", pub)
+}
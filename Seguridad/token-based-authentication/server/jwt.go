@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// allowedAlgorithms are the signature algorithms accepted in the token header.
+// Never trust the "alg" of the token alone: "none" or an HMAC algorithm keyed
+// with the public key would let anyone forge tokens.
+var allowedAlgorithms = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.RS384): true,
+	string(jose.RS512): true,
+	string(jose.PS256): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+// Claims are the claims of a validated token. Handlers get them with
+// claimsFromContext.
+type Claims struct {
+	jwt.Claims
+	Scope string   `json:"scope,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Scopes returns the space separated scopes of the token.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+type claimsKey struct{}
+
+// claimsFromContext returns the claims of the token used in the call.
+func claimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok
+}
+
+// jwksMinRefresh is the minimum time between two downloads of the remote JWKS,
+// so tokens with made up key ids can not flood the token server.
+const jwksMinRefresh = 10 * time.Second
+
+// jwksMaxAge is the age after which the remote JWKS is downloaded again, to
+// drop the keys the token server has retired.
+const jwksMaxAge = 10 * time.Minute
+
+// tokenValidator validates JWT bearer tokens against the keys of a local JWKS
+// file or a token server, the expected issuer and audience, and the token
+// lifetime.
+type tokenValidator struct {
+	keys     jose.JSONWebKeySet
+	issuer   string
+	audience string
+	// clockSkew is the leeway allowed for the clocks of the issuer and the server
+	// when checking exp, nbf and iat.
+	clockSkew time.Duration
+	// now is time.Now, except in tests.
+	now func() time.Time
+
+	// jwksURL is the JWKS of a token server, which rotates its keys. See
+	// fetchKeysFrom.
+	jwksURL string
+	client  *http.Client
+
+	mu      sync.Mutex
+	remote  jose.JSONWebKeySet
+	fetched time.Time
+	// refreshing is closed when the download in progress ends. It is nil when
+	// there is none.
+	refreshing chan struct{}
+}
+
+// newTokenValidator loads the JSON Web Key Set from jwksFile. With an empty
+// jwksFile the validator only trusts the keys of a token server, see
+// fetchKeysFrom.
+func newTokenValidator(jwksFile, issuer, audience string, clockSkew time.Duration) (*tokenValidator, error) {
+	v := &tokenValidator{issuer: issuer, audience: audience, clockSkew: clockSkew, now: time.Now}
+	if jwksFile == "" {
+		return v, nil
+	}
+	b, err := ioutil.ReadFile(jwksFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &v.keys); err != nil {
+		return nil, fmt.Errorf("%s: %v", jwksFile, err)
+	}
+	if len(v.keys.Keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", jwksFile)
+	}
+	if err := checkPublic(v.keys); err != nil {
+		return nil, fmt.Errorf("%s: %v", jwksFile, err)
+	}
+	return v, nil
+}
+
+// fetchKeysFrom also accepts the keys published by a token server at url. The
+// set is downloaded again when a token has an unknown key id, which is how a
+// new key shows up after a rotation, and when it is older than jwksMaxAge. The
+// validator keeps the url even if the first download fails, so the server can
+// start before the token server.
+func (v *tokenValidator) fetchKeysFrom(url string, client *http.Client) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.jwksURL = url
+	v.client = client
+	return v.refresh()
+}
+
+// refresh downloads the remote JWKS. It is called with mu held, and releases it
+// during the download so validations with known keys do not wait for the token
+// server. Validations that need the new keys wait on refreshing.
+func (v *tokenValidator) refresh() error {
+	done := make(chan struct{})
+	v.refreshing = done
+	v.fetched = v.now()
+	url, client := v.jwksURL, v.client
+	v.mu.Unlock()
+	keys, err := fetchJWKS(url, client)
+	v.mu.Lock()
+	if err == nil {
+		v.remote = keys
+	}
+	v.refreshing = nil
+	close(done)
+	return err
+}
+
+// fetchJWKS downloads the JWKS at url.
+func fetchJWKS(url string, client *http.Client) (jose.JSONWebKeySet, error) {
+	var keys jose.JSONWebKeySet
+	resp, err := client.Get(url)
+	if err != nil {
+		return keys, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return keys, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return keys, fmt.Errorf("%s: %v", url, err)
+	}
+	if err := checkPublic(keys); err != nil {
+		return keys, fmt.Errorf("%s: %v", url, err)
+	}
+	return keys, nil
+}
+
+// httpsClient returns a client that trusts the certificates in caFile.
+func httpsClient(caFile string) (*http.Client, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: no certificates", caFile)
+	}
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}, nil
+}
+
+// checkPublic fails if the set has a private or a symmetric key.
+func checkPublic(keys jose.JSONWebKeySet) error {
+	for _, k := range keys.Keys {
+		if !k.IsPublic() {
+			return fmt.Errorf("key %q is not a public key", k.KeyID)
+		}
+	}
+	return nil
+}
+
+// validate checks the signature and the registered claims of the token and
+// returns its claims.
+func (v *tokenValidator) validate(raw string) (*Claims, error) {
+	tok, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %v", err)
+	}
+	if len(tok.Headers) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+	header := tok.Headers[0]
+	if !allowedAlgorithms[header.Algorithm] {
+		return nil, fmt.Errorf("algorithm %q not allowed", header.Algorithm)
+	}
+	key, err := v.key(header)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return nil, fmt.Errorf("key %q can not be used with %s", key.KeyID, header.Algorithm)
+	}
+
+	var claims Claims
+	if err := tok.Claims(key.Key, &claims); err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+	if claims.Expiry == nil {
+		return nil, errors.New("token has no expiry")
+	}
+	expected := jwt.Expected{Issuer: v.issuer, Audience: jwt.Audience{v.audience}, Time: v.now()}
+	if err := claims.ValidateWithLeeway(expected, v.clockSkew); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// key returns the key that signed the token. Tokens without a "kid" are only
+// accepted when the local set has a single key and there is no token server.
+func (v *tokenValidator) key(header jose.Header) (*jose.JSONWebKey, error) {
+	if header.KeyID == "" {
+		if len(v.keys.Keys) == 1 && v.jwksURL == "" {
+			return &v.keys.Keys[0], nil
+		}
+		return nil, errors.New("token has no key id")
+	}
+	if keys := v.keys.Key(header.KeyID); len(keys) > 0 {
+		return &keys[0], nil
+	}
+	if v.jwksURL == "" {
+		return nil, fmt.Errorf("unknown key id %q", header.KeyID)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for {
+		keys := v.remote.Key(header.KeyID)
+		age := v.now().Sub(v.fetched)
+		stale := (len(keys) == 0 && age > jwksMinRefresh) || age > jwksMaxAge
+		if stale && v.refreshing == nil {
+			if err := v.refresh(); err != nil {
+				// Keep the keys we have; the token server may be restarting.
+				log.Printf("failed to refresh JWKS: %v", err)
+			}
+			continue
+		}
+		if len(keys) == 0 && v.refreshing != nil {
+			// Another validation is downloading the set, which may bring the key.
+			done := v.refreshing
+			v.mu.Unlock()
+			<-done
+			v.mu.Lock()
+			continue
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("unknown key id %q", header.KeyID)
+		}
+		k := keys[0]
+		return &k, nil
+	}
+}
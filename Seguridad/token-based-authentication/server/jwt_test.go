@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testIssuer   = "https://auth.productinfo.local"
+	testAudience = "productinfo"
+)
+
+// testKey is a signing key of the tests and its public JWK.
+type testKey struct {
+	private *ecdsa.PrivateKey
+	public  jose.JSONWebKey
+}
+
+func newTestKey(t *testing.T, kid string) *testKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{private: k, public: jose.JSONWebKey{Key: &k.PublicKey, KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"}}
+}
+
+// sign returns a token signed with the key, with kid in the header unless it is
+// empty.
+func (k *testKey) sign(t *testing.T, kid string, claims interface{}) string {
+	t.Helper()
+	opts := &jose.SignerOptions{}
+	if kid != "" {
+		opts = opts.WithHeader("kid", kid)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: k.private}, opts.WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// testClaims returns valid claims issued at now.
+func testClaims(now time.Time) jwt.Claims {
+	return jwt.Claims{
+		Issuer:    testIssuer,
+		Subject:   "publisher",
+		Audience:  jwt.Audience{testAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+}
+
+// localValidator returns a validator that trusts the keys in a local JWKS
+// file, with a clock the test controls.
+func localValidator(t *testing.T, now *time.Time, keys ...*testKey) *tokenValidator {
+	t.Helper()
+	var set jose.JSONWebKeySet
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.public)
+	}
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := newTokenValidator(path, testIssuer, testAudience, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return *now }
+	return v
+}
+
+func TestValidateRejectsAlgorithms(t *testing.T) {
+	now := time.Now()
+	key := newTestKey(t, "k1")
+	v := localValidator(t, &now, key)
+	if _, err := v.validate(key.sign(t, "k1", testClaims(now))); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+
+	// An unsigned token with "alg": "none".
+	enc := base64.RawURLEncoding.EncodeToString
+	payload, _ := json.Marshal(testClaims(now))
+	none := enc([]byte(`{"alg":"none","kid":"k1","typ":"JWT"}`)) + "." + enc(payload) + "."
+
+	// An HS256 token keyed with the bytes of the public key, the classic
+	// algorithm confusion attack.
+	pub, _ := json.Marshal(key.public)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: pub}, (&jose.SignerOptions{}).WithHeader("kid", "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs256, err := jwt.Signed(signer).Claims(testClaims(now)).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, raw := range map[string]string{"none": none, "HS256": hs256} {
+		if _, err := v.validate(raw); err == nil {
+			t.Errorf("%s: token accepted", name)
+		} else if name == "HS256" && !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("%s: %v, want the algorithm rejected", name, err)
+		}
+	}
+}
+
+func TestValidateKeyID(t *testing.T) {
+	now := time.Now()
+	k1, k2 := newTestKey(t, "k1"), newTestKey(t, "k2")
+
+	// With a single local key a token without kid uses it.
+	single := localValidator(t, &now, k1)
+	if _, err := single.validate(k1.sign(t, "", testClaims(now))); err != nil {
+		t.Errorf("no kid, single key: %v", err)
+	}
+
+	v := localValidator(t, &now, k1, k2)
+	cases := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"no kid", k1.sign(t, "", testClaims(now)), "no key id"},
+		{"unknown kid", k1.sign(t, "k3", testClaims(now)), `unknown key id "k3"`},
+		// The kid of another key: the signature does not match.
+		{"kid of another key", k1.sign(t, "k2", testClaims(now)), "invalid signature"},
+	}
+	for _, c := range cases {
+		if _, err := v.validate(c.raw); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: %v, want an error with %q", c.name, err, c.want)
+		}
+	}
+	if _, err := v.validate(k2.sign(t, "k2", testClaims(now))); err != nil {
+		t.Errorf("second key: %v", err)
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	now := time.Now()
+	key := newTestKey(t, "k1")
+	v := localValidator(t, &now, key)
+
+	cases := []struct {
+		name string
+		edit func(c *jwt.Claims)
+		ok   bool
+	}{
+		{"valid", func(c *jwt.Claims) {}, true},
+		{"expired", func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-time.Minute)) }, false},
+		// The clock skew is 30s: a token that expired 10s ago is still valid.
+		{"expired within the leeway", func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-10 * time.Second)) }, true},
+		{"before nbf", func(c *jwt.Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute)) }, false},
+		{"nbf within the leeway", func(c *jwt.Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(10 * time.Second)) }, true},
+		{"no expiry", func(c *jwt.Claims) { c.Expiry = nil }, false},
+		{"wrong issuer", func(c *jwt.Claims) { c.Issuer = "https://evil.example" }, false},
+		{"wrong audience", func(c *jwt.Claims) { c.Audience = jwt.Audience{"orders"} }, false},
+		{"one of several audiences", func(c *jwt.Claims) { c.Audience = jwt.Audience{"orders", testAudience} }, true},
+	}
+	for _, c := range cases {
+		claims := testClaims(now)
+		c.edit(&claims)
+		_, err := v.validate(key.sign(t, "k1", claims))
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: token accepted", c.name)
+		}
+	}
+}
+
+// jwksServer is a token server JWKS endpoint whose keys the test changes.
+type jwksServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	keys  jose.JSONWebKeySet
+	hits  int
+	block chan struct{}
+}
+
+func newJWKSServer(t *testing.T, keys ...*testKey) *jwksServer {
+	t.Helper()
+	s := &jwksServer{}
+	s.publish(keys...)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits++
+		keys, block := s.keys, s.block
+		s.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+		json.NewEncoder(w).Encode(keys)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) publish(keys ...*testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = jose.JSONWebKeySet{}
+	for _, k := range keys {
+		s.keys.Keys = append(s.keys.Keys, k.public)
+	}
+}
+
+func (s *jwksServer) downloads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+// remoteValidator returns a validator that only trusts the keys of s, with a
+// clock the test controls.
+func remoteValidator(t *testing.T, s *jwksServer, now *time.Time) *tokenValidator {
+	t.Helper()
+	v, err := newTokenValidator("", testIssuer, testAudience, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return *now }
+	if err := v.fetchKeysFrom(s.URL, s.Client()); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestValidateRemoteKeyRotation(t *testing.T) {
+	now := time.Now()
+	k1, k2 := newTestKey(t, "k1"), newTestKey(t, "k2")
+	s := newJWKSServer(t, k1)
+	v := remoteValidator(t, s, &now)
+
+	if _, err := v.validate(k1.sign(t, "k1", testClaims(now))); err != nil {
+		t.Fatalf("first key: %v", err)
+	}
+	if n := s.downloads(); n != 1 {
+		t.Fatalf("%d downloads, want 1", n)
+	}
+
+	// The token server rotates to k2. Right after a download the set is not
+	// downloaded again: unknown key ids can not flood the token server.
+	s.publish(k1, k2)
+	if _, err := v.validate(k2.sign(t, "k2", testClaims(now))); err == nil {
+		t.Error("new key accepted before jwksMinRefresh")
+	}
+	if n := s.downloads(); n != 1 {
+		t.Fatalf("%d downloads within jwksMinRefresh, want 1", n)
+	}
+
+	// Past jwksMinRefresh the unknown key id downloads the set again.
+	now = now.Add(jwksMinRefresh + time.Second)
+	if _, err := v.validate(k2.sign(t, "k2", testClaims(now))); err != nil {
+		t.Fatalf("new key after the rotation: %v", err)
+	}
+	if _, err := v.validate(k1.sign(t, "k1", testClaims(now))); err != nil {
+		t.Errorf("old key still published: %v", err)
+	}
+	if n := s.downloads(); n != 2 {
+		t.Fatalf("%d downloads, want 2", n)
+	}
+
+	// The token server retires k1. Known keys do not trigger a download, so
+	// k1 is trusted until the set is older than jwksMaxAge.
+	s.publish(k2)
+	now = now.Add(jwksMinRefresh + time.Second)
+	if _, err := v.validate(k1.sign(t, "k1", testClaims(now))); err != nil {
+		t.Errorf("retired key before jwksMaxAge: %v", err)
+	}
+	now = now.Add(jwksMaxAge)
+	if _, err := v.validate(k1.sign(t, "k1", testClaims(now))); err == nil {
+		t.Error("retired key accepted after jwksMaxAge")
+	}
+	if n := s.downloads(); n != 3 {
+		t.Fatalf("%d downloads, want 3", n)
+	}
+}
+
+func TestValidateDoesNotWaitForDownload(t *testing.T) {
+	now := time.Now()
+	k1, k2 := newTestKey(t, "k1"), newTestKey(t, "k2")
+	s := newJWKSServer(t, k1)
+	v := remoteValidator(t, s, &now)
+
+	// The next download hangs until the test releases it.
+	block := make(chan struct{})
+	s.mu.Lock()
+	s.block = block
+	s.mu.Unlock()
+	s.publish(k1, k2)
+	now = now.Add(jwksMinRefresh + time.Second)
+	newToken, knownToken := k2.sign(t, "k2", testClaims(now)), k1.sign(t, "k1", testClaims(now))
+	done := make(chan error, 1)
+	go func() {
+		_, err := v.validate(newToken)
+		done <- err
+	}()
+	for s.downloads() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A token with a known key is validated during the download.
+	checked := make(chan error, 1)
+	go func() {
+		_, err := v.validate(knownToken)
+		checked <- err
+	}()
+	select {
+	case err := <-checked:
+		if err != nil {
+			t.Errorf("known key during the download: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("a token with a known key waits for the download")
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Errorf("new key after the download: %v", err)
+	}
+	if n := s.downloads(); n != 2 {
+		t.Errorf("%d downloads, want 2", n)
+	}
+}
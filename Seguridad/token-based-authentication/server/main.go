@@ -9,18 +9,18 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"flag"
 	wrapper "github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/google/uuid"
 	pb "github.com/grpc-up-and-running/samples/ch02/productinfo/go/product_info"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 	"log"
 	"net"
 	"path/filepath"
-	"strings"
+	"seguridad/auth"
+	"seguridad/auth/tlsreload"
+	"time"
 )
 
 // server is used to implement ecommerce/product_info.
@@ -32,8 +32,16 @@ var (
 	port = ":50051"
 	crtFile = filepath.Join("ch06", "secure-channel", "certs", "server.crt")
 	keyFile = filepath.Join("ch06", "secure-channel", "certs", "server.key")
-	errMissingMetadata = status.Errorf(codes.InvalidArgument, "missing metadata")
-	errInvalidToken    = status.Errorf(codes.Unauthenticated, "invalid token")
+	// Public keys of the tokens the client mints itself, as a JSON Web Key Set.
+	// Off unless -jwks is set, see ../jwt/README.md.
+	jwksFile = flag.String("jwks", "", "JSON Web Key Set with the public keys of minted tokens, e.g. "+filepath.Join("ch06", "token-based-authentication", "jwt", "jwks.json"))
+	// Public keys of the local token server (see ../authserver), which rotates them.
+	jwksURL = "https://localhost:9443/.well-known/jwks.json"
+	issuer = "https://auth.productinfo.local"
+	audience = "productinfo"
+	clockSkew = 30 * time.Second
+	// Methods allowed to each role of the "roles" claim.
+	rbacFile = filepath.Join("ch06", "token-based-authentication", "server", "rbac.json")
 )
 
 // AddProduct implements ecommerce.AddProduct
@@ -43,6 +51,10 @@ func (s *server) AddProduct(ctx context.Context, in *pb.Product) (*wrapper.Strin
 		log.Fatal(err)
 	}
 	in.Id = out.String()
+	// The interceptor has already validated the token, so the claims are always there.
+	if claims, ok := claimsFromContext(ctx); ok {
+		log.Printf("Product %s added by %s", in.Id, claims.Subject)
+	}
 	if s.productMap == nil {
 		s.productMap = make(map[string]*pb.Product)
 	}
@@ -60,15 +72,39 @@ func (s *server) GetProduct(ctx context.Context, in *wrapper.StringValue) (*pb.P
 }
 
 func main() {
-	cert, err := tls.LoadX509KeyPair(crtFile, keyFile)
+	flag.Parse()
+	certs, err := tlsreload.New(crtFile, keyFile, "")
 	if err != nil {
 		log.Fatalf("failed to load key pair: %s", err)
 	}
+	go certs.Watch(tlsreload.DefaultInterval, nil)
+	validator, err := newTokenValidator(*jwksFile, issuer, audience, clockSkew)
+	if err != nil {
+		log.Fatalf("failed to load JWKS: %s", err)
+	}
+	// The token server uses the same certificate as this server.
+	jwksClient, err := httpsClient(crtFile)
+	if err != nil {
+		log.Fatalf("failed to load credentials: %s", err)
+	}
+	if err := validator.fetchKeysFrom(jwksURL, jwksClient); err != nil {
+		log.Printf("token server keys not available yet: %s", err)
+	}
+	policy, err := auth.LoadPolicy(rbacFile)
+	if err != nil {
+		log.Fatalf("failed to load RBAC policy: %s", err)
+	}
+	authn := auth.NewInterceptor(validator.authenticate, auth.HealthAndReflection...)
+	authz := auth.NewInterceptor(policy.Authorize, auth.HealthAndReflection...)
 	opts := []grpc.ServerOption{
 		// Enable TLS for all incoming connections.
-		grpc.Creds(credentials.NewServerTLSFromCert(&cert)),
+		// The certificate is read again when the files change.
+		grpc.Creds(credentials.NewTLS(certs.ServerConfig(tls.NoClientCert))),
 
-		grpc.UnaryInterceptor(ensureValidToken),
+		// Authentication, then authorization with the roles of the token. Both
+		// for unary and streaming methods.
+		grpc.ChainUnaryInterceptor(authn.UnaryServerInterceptor(), authz.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(authn.StreamServerInterceptor(), authz.StreamServerInterceptor()),
 	}
 
 	s := grpc.NewServer(opts...)
@@ -86,32 +122,18 @@ func main() {
 	}
 }
 
-// valid validates the authorization.
-func valid(authorization []string) bool {
-	if len(authorization) < 1 {
-		return false
-	}
-	token := strings.TrimPrefix(authorization[0], "Bearer ")
-	// Perform the token validation here. For the sake of this example, the code
-	// here forgoes any of the usual OAuth2 token validation and instead checks
-	// for a token matching an arbitrary string.
-	return token == "some-secret-token"
-}
-
-// ensureValidToken ensures a valid token exists within a request's metadata. If
-// the token is missing or invalid, the interceptor blocks execution of the
-// handler and returns an error. Otherwise, the interceptor invokes the unary
-// handler.
-func ensureValidToken(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, errMissingMetadata
+// authenticate validates the bearer token of a call, unary or streaming, and
+// returns the context for the handler with the claims of the token.
+func (v *tokenValidator) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	token, err := auth.BearerToken(ctx)
+	if err != nil {
+		return nil, err
 	}
-	// The keys within metadata.MD are normalized to lowercase.
-	// See: https://godoc.org/google.golang.org/grpc/metadata#New
-	if !valid(md["authorization"]) {
-		return nil, errInvalidToken
+	claims, err := v.validate(token)
+	if err != nil {
+		return nil, err
 	}
-	// Continue execution of handler after ensuring a valid token.
-	return handler(ctx, req)
+	// The identity is for the RBAC policy, the claims for the handlers.
+	ctx = auth.NewContext(ctx, &auth.Identity{Subject: claims.Subject, Roles: claims.Roles})
+	return context.WithValue(ctx, claimsKey{}, claims), nil
 }
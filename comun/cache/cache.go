@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"container/list"
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Cache caché LRU con caducidad. Publica los aciertos, fallos, expulsiones e invalidaciones en /debug/vars
+type Cache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+	metrics    *expvar.Map
+	now        func() time.Time
+	//generation cambia con cada invalidación. Ver SetIfGeneration
+	generation uint64
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+//New crea una caché con un máximo de maxEntries entradas que caducan a los ttl. Las métricas se publican en la variable cache_<name>
+func New(name string, maxEntries int, ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		metrics:    metricsMap("cache_" + name),
+		now:        time.Now,
+	}
+}
+
+//metricsMap devuelve la variable de expvar, creándola si no existe
+func metricsMap(name string) *expvar.Map {
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	return expvar.NewMap(name)
+}
+
+//Get devuelve el valor si está y no ha caducado
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.metrics.Add("misses", 1)
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.now().After(e.expires) {
+		c.remove(el)
+		c.metrics.Add("misses", 1)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.metrics.Add("hits", 1)
+	return e.value, true
+}
+
+//Set guarda un valor. Si se supera el máximo de entradas se expulsa la menos usada
+func (c *Cache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value)
+}
+
+func (c *Cache) set(key string, value interface{}) {
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, expires: expires})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.remove(c.ll.Back())
+		c.metrics.Add("evictions", 1)
+	}
+}
+
+//Generation versión de la caché, que cambia con cada Delete, Purge y PurgePrefix. Se lee antes de calcular un valor para guardarlo después con SetIfGeneration
+func (c *Cache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+//SetIfGeneration guarda el valor solo si la caché no se ha invalidado desde que se leyó gen con Generation. Así una lectura que
+//empezó antes de una escritura no deja en la caché el valor anterior a la escritura después de que esta la haya invalidado
+func (c *Cache) SetIfGeneration(key string, value interface{}, gen uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != gen {
+		c.metrics.Add("stale_sets", 1)
+		return false
+	}
+	c.set(key, value)
+	return true
+}
+
+//Delete borra una entrada
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+		c.metrics.Add("invalidations", 1)
+	}
+}
+
+//Purge borra todas las entradas
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if c.ll.Len() > 0 {
+		c.metrics.Add("invalidations", int64(c.ll.Len()))
+	}
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+//PurgePrefix borra las entradas cuya clave empieza por prefix. Cambia la generación igual que Purge, así que también descarta
+//los SetIfGeneration en curso de otras claves, que simplemente no se guardan
+func (c *Cache) PurgePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	n := 0
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(el)
+			n++
+		}
+	}
+	if n > 0 {
+		c.metrics.Add("invalidations", int64(n))
+	}
+}
+
+//Len número de entradas, incluidas las caducadas que aún no se han borrado
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
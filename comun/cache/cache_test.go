@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLRUAndTTL(t *testing.T) {
+	c := New("test_lru", 2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	//b es la menos usada
+	c.Set("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("b sigue en la caché después de superar el máximo")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("a = %v, %v", v, ok)
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Error("a no caduca")
+	}
+}
+
+func TestSetIfGeneration(t *testing.T) {
+	c := New("test_generation", 10, time.Minute)
+
+	gen := c.Generation()
+	if !c.SetIfGeneration("a", 1, gen) {
+		t.Fatal("SetIfGeneration sin invalidaciones no guarda")
+	}
+	gen = c.Generation()
+	c.Purge()
+	if c.SetIfGeneration("a", 2, gen) {
+		t.Error("SetIfGeneration guarda después de un Purge")
+	}
+	gen = c.Generation()
+	c.Delete("b")
+	if c.SetIfGeneration("a", 3, gen) {
+		t.Error("SetIfGeneration guarda después de un Delete")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("a está en la caché")
+	}
+}
+
+//transportStream lo justo para que grpc.Method devuelva el método en los interceptores
+type transportStream struct {
+	grpc.ServerTransportStream
+	method string
+}
+
+func (s transportStream) Method() string { return s.method }
+
+func call(rc *ReadCache, method string, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), transportStream{method: method})
+	ctx = metadata.NewIncomingContext(ctx, metadata.MD{})
+	return rc.UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+}
+
+const (
+	getOrder = "/ecommerce.OrderManagement/getOrder"
+	addOrder = "/ecommerce.OrderManagement/addOrder"
+)
+
+func TestReadCacheServesAndInvalidates(t *testing.T) {
+	rc := NewReadCache(New("test_read", 10, time.Minute), []string{getOrder}, []string{addOrder}, nil)
+	calls := 0
+	read := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return &wrappers.StringValue{Value: "v1"}, nil
+	}
+	write := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{}, nil
+	}
+	id := &wrappers.StringValue{Value: "1"}
+
+	call(rc, getOrder, id, read)
+	call(rc, getOrder, id, read)
+	if calls != 1 {
+		t.Fatalf("%d llamadas al handler, quería 1: la segunda lectura sale de la caché", calls)
+	}
+	call(rc, addOrder, id, write)
+	call(rc, getOrder, id, read)
+	if calls != 2 {
+		t.Fatalf("%d llamadas al handler, quería 2: la escritura invalida la caché", calls)
+	}
+}
+
+func TestReadCacheConcurrentWrite(t *testing.T) {
+	c := New("test_concurrent", 10, time.Minute)
+	rc := NewReadCache(c, []string{getOrder}, []string{addOrder}, nil)
+	id := &wrappers.StringValue{Value: "1"}
+
+	//La lectura lee el valor antiguo, y mientras termina llega una escritura
+	reading, written := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		call(rc, getOrder, id, func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(reading)
+			<-written
+			return &wrappers.StringValue{Value: "antiguo"}, nil
+		})
+	}()
+	<-reading
+	call(rc, addOrder, id, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{}, nil
+	})
+	close(written)
+	<-done
+
+	if n := c.Len(); n != 0 {
+		t.Errorf("%d entradas en la caché: la lectura concurrente con la escritura ha guardado el valor antiguo", n)
+	}
+}
+
+func TestReadCacheScope(t *testing.T) {
+	rc := NewReadCache(New("test_scope", 10, time.Minute), []string{getOrder}, nil, func(ctx context.Context) string {
+		return ctx.Value(scopeKey{}).(string)
+	})
+	calls := 0
+	read := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return &wrappers.StringValue{Value: "v"}, nil
+	}
+	for _, tenant := range []string{"a", "b", "a"} {
+		ctx := grpc.NewContextWithServerTransportStream(context.WithValue(context.Background(), scopeKey{}, tenant), transportStream{method: getOrder})
+		rc.UnaryServerInterceptor()(ctx, &wrappers.StringValue{Value: "1"}, &grpc.UnaryServerInfo{FullMethod: getOrder}, read)
+	}
+	if calls != 2 {
+		t.Errorf("%d llamadas al handler, quería 2: una por ámbito", calls)
+	}
+}
+
+type scopeKey struct{}
+
+func TestReadCacheInvalidatesWriterScope(t *testing.T) {
+	c := New("test_scope_write", 10, time.Minute)
+	rc := NewReadCache(c, []string{getOrder}, []string{addOrder}, func(ctx context.Context) string {
+		return ctx.Value(scopeKey{}).(string)
+	})
+	calls := map[string]int{}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls[ctx.Value(scopeKey{}).(string)]++
+		return &wrappers.StringValue{Value: "v"}, nil
+	}
+	callAs := func(tenant, method string) {
+		ctx := grpc.NewContextWithServerTransportStream(context.WithValue(context.Background(), scopeKey{}, tenant), transportStream{method: method})
+		rc.UnaryServerInterceptor()(ctx, &wrappers.StringValue{Value: "1"}, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+
+	callAs("a", getOrder)
+	callAs("b", getOrder)
+	//La escritura de a solo invalida sus entradas: b sigue leyendo de la caché
+	callAs("a", addOrder)
+	callAs("a", getOrder)
+	callAs("b", getOrder)
+	if calls["a"] != 3 || calls["b"] != 1 {
+		t.Errorf("llamadas al handler a=%d b=%d, quería a=3 (lectura, escritura, lectura) y b=1", calls["a"], calls["b"])
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("%d entradas, quería 2", n)
+	}
+}
+
+func TestCacheDirectives(t *testing.T) {
+	cases := []struct {
+		values           []string
+		noCache, noStore bool
+	}{
+		{nil, false, false},
+		{[]string{"no-cache"}, true, false},
+		{[]string{"No-Store"}, true, true},
+		{[]string{"max-age=0, no-cache"}, true, false},
+		{[]string{"private", "no-store"}, true, true},
+	}
+	for _, c := range cases {
+		md := metadata.MD{}
+		for _, v := range c.values {
+			md.Append(CacheControlKey, v)
+		}
+		if noCache, noStore := CacheDirectives(md); noCache != c.noCache || noStore != c.noStore {
+			t.Errorf("%q: no-cache=%v no-store=%v, quería %v %v", c.values, noCache, noStore, c.noCache, c.noStore)
+		}
+	}
+}
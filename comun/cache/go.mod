@@ -0,0 +1,9 @@
+module comun/cache
+
+go 1.15
+
+require (
+	github.com/golang/protobuf v1.4.3
+	google.golang.org/grpc v1.33.1
+	google.golang.org/protobuf v1.25.0
+)
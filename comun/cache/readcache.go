@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	protov2 "google.golang.org/protobuf/proto"
+)
+
+//CacheControlKey metadato con el que el cliente controla la caché. no-cache no lee de la caché, no-store además no guarda la respuesta.
+//Lo usan la caché de respuestas del cliente y la de lecturas del servidor: el metadato viaja al servidor, que aplica lo mismo
+const CacheControlKey = "cache-control"
+
+//ReadCache caché de lecturas en el servidor. Las escrituras invalidan la caché: con scope solo las entradas del ámbito del que
+//escribe, y sin scope todas
+type ReadCache struct {
+	cache  *Cache
+	reads  map[string]bool
+	writes map[string]bool
+	scope  func(context.Context) string
+}
+
+//NewReadCache crea la caché. reads son los métodos que se cachean y writes los que la invalidan, con el nombre completo.
+//scope separa las respuestas de cada llamante, por ejemplo tenant.FromContext para que un tenant nunca reciba respuestas
+//cacheadas de otro. Con nil todas las llamadas comparten las respuestas
+func NewReadCache(c *Cache, reads, writes []string, scope func(context.Context) string) *ReadCache {
+	rc := &ReadCache{cache: c, reads: map[string]bool{}, writes: map[string]bool{}, scope: scope}
+	for _, m := range reads {
+		rc.reads[m] = true
+	}
+	for _, m := range writes {
+		rc.writes[m] = true
+	}
+	return rc
+}
+
+//CacheDirectives lee el metadato cache-control, del contexto de entrada en el servidor o del de salida en el cliente
+func CacheDirectives(md metadata.MD) (noCache, noStore bool) {
+	for _, v := range md.Get(CacheControlKey) {
+		for _, d := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(d)) {
+			case "no-cache":
+				noCache = true
+			case "no-store":
+				noCache, noStore = true, true
+			}
+		}
+	}
+	return
+}
+
+//CacheKey clave de la caché: prefix, el método y la petición serializada. prefix separa las respuestas que no se pueden
+//compartir, como las de cada tenant en el servidor o las de cada target en el cliente. ok es false si req no es protobuf
+func CacheKey(prefix, method string, req interface{}) (key string, ok bool) {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return "", false
+	}
+	b, err := protov2.MarshalOptions{Deterministic: true}.Marshal(proto.MessageV2(m))
+	if err != nil {
+		return "", false
+	}
+	return prefix + "|" + method + "|" + string(b), true
+}
+
+//scopeOf ámbito del llamante, vacío sin scope
+func (rc *ReadCache) scopeOf(ctx context.Context) string {
+	if rc.scope == nil {
+		return ""
+	}
+	return rc.scope(ctx)
+}
+
+//invalidate invalida las entradas que puede haber cambiado una escritura: las del ámbito del llamante, o todas sin scope
+func (rc *ReadCache) invalidate(ctx context.Context) {
+	if rc.scope == nil {
+		rc.cache.Purge()
+		return
+	}
+	rc.cache.PurgePrefix(rc.scopeOf(ctx) + "|")
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+//UnaryServerInterceptor sirve las lecturas desde la caché e invalida la caché con las escrituras. La caché se invalida antes y
+//después de la escritura, y las lecturas solo guardan su respuesta si no ha habido ninguna invalidación mientras se atendían,
+//de forma que una lectura concurrente con una escritura nunca deja en la caché el valor anterior
+func (rc *ReadCache) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method, _ := grpc.Method(ctx)
+		if rc.writes[method] {
+			rc.invalidate(ctx)
+			m, err := handler(ctx, req)
+			rc.invalidate(ctx)
+			return m, err
+		}
+		if !rc.reads[method] {
+			return handler(ctx, req)
+		}
+		key, ok := CacheKey(rc.scopeOf(ctx), method, req)
+		if !ok {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		noCache, noStore := CacheDirectives(md)
+		if !noCache {
+			if v, ok := rc.cache.Get(key); ok {
+				return proto.Clone(v.(proto.Message)), nil
+			}
+		}
+		gen := rc.cache.Generation()
+		m, err := handler(ctx, req)
+		if err == nil && !noStore && !isNil(m) {
+			if pm, ok := m.(proto.Message); ok {
+				rc.cache.SetIfGeneration(key, proto.Clone(pm), gen)
+			}
+		}
+		return m, err
+	}
+}
+
+//StreamServerInterceptor invalida la caché con las escrituras que llegan por un stream, como updateOrders
+func (rc *ReadCache) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !rc.writes[info.FullMethod] {
+			return handler(srv, ss)
+		}
+		ctx := ss.Context()
+		rc.invalidate(ctx)
+		err := handler(srv, &invalidatingStream{ServerStream: ss, invalidate: func() { rc.invalidate(ctx) }})
+		rc.invalidate(ctx)
+		return err
+	}
+}
+
+//invalidatingStream invalida la caché con cada mensaje recibido, para que las lecturas concurrentes no vean datos antiguos
+type invalidatingStream struct {
+	grpc.ServerStream
+	invalidate func()
+}
+
+func (s *invalidatingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.invalidate()
+	}
+	return err
+}
@@ -0,0 +1,5 @@
+module comun/orca
+
+go 1.15
+
+require google.golang.org/grpc v1.33.1
@@ -0,0 +1,65 @@
+//Package orca informe de carga de los servidores en el trailer de las llamadas, con el formato de texto de ORCA. El servidor lo escribe
+//con LoadReportTrailer y el balanceo weighted_round_robin del cliente lo lee con ParseLoadReport
+package orca
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+//LoadReportKey trailer en el que el servidor informa de su carga:
+//TEXT application_utilization=0.30, rps_fractional=120.00, eps=0.50
+const LoadReportKey = "endpoint-load-metrics"
+
+//LoadReport carga informada por un servidor
+type LoadReport struct {
+	//Utilización, de 0 a 1. Si el servidor informa de application_utilization se usa esa, y si no cpu_utilization
+	Utilization float64
+	//Peticiones por segundo
+	RPS float64
+	//Errores por segundo
+	EPS float64
+}
+
+//LoadReportTrailer devuelve el trailer con el informe de carga
+func LoadReportTrailer(r LoadReport) metadata.MD {
+	return metadata.Pairs(LoadReportKey, fmt.Sprintf("TEXT application_utilization=%.4f, rps_fractional=%.2f, eps=%.2f", r.Utilization, r.RPS, r.EPS))
+}
+
+//ParseLoadReport lee el informe de carga del trailer. Devuelve false si no hay informe o no es válido
+func ParseLoadReport(trailer metadata.MD) (LoadReport, bool) {
+	v := trailer.Get(LoadReportKey)
+	if len(v) == 0 || !strings.HasPrefix(v[0], "TEXT ") {
+		return LoadReport{}, false
+	}
+	var r LoadReport
+	var cpu, app float64
+	for _, kv := range strings.Split(strings.TrimPrefix(v[0], "TEXT "), ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		f, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return LoadReport{}, false
+		}
+		switch parts[0] {
+		case "cpu_utilization":
+			cpu = f
+		case "application_utilization":
+			app = f
+		case "rps_fractional":
+			r.RPS = f
+		case "eps":
+			r.EPS = f
+		}
+	}
+	r.Utilization = cpu
+	if app > 0 {
+		r.Utilization = app
+	}
+	return r, true
+}
@@ -0,0 +1,40 @@
+package orca
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLoadReportRoundTrip(t *testing.T) {
+	want := LoadReport{Utilization: 0.3, RPS: 120, EPS: 0.5}
+	got, ok := ParseLoadReport(LoadReportTrailer(want))
+	if !ok || got != want {
+		t.Errorf("informe leído %+v, %v; esperado %+v", got, ok, want)
+	}
+}
+
+func TestParseLoadReport(t *testing.T) {
+	cases := []struct {
+		value string
+		ok    bool
+		want  LoadReport
+	}{
+		//Sin application_utilization se usa cpu_utilization
+		{"TEXT cpu_utilization=0.5, rps_fractional=10", true, LoadReport{Utilization: 0.5, RPS: 10}},
+		{"TEXT cpu_utilization=0.5, application_utilization=0.2", true, LoadReport{Utilization: 0.2}},
+		//Las claves desconocidas y los pares mal formados se ignoran
+		{"TEXT mem_utilization=0.9, eps, rps_fractional=3", true, LoadReport{RPS: 3}},
+		{"TEXT rps_fractional=muchas", false, LoadReport{}},
+		{"JSON {\"rps_fractional\": 3}", false, LoadReport{}},
+	}
+	for _, c := range cases {
+		got, ok := ParseLoadReport(metadata.Pairs(LoadReportKey, c.value))
+		if ok != c.ok || got != c.want {
+			t.Errorf("%q: %+v, %v; esperado %+v, %v", c.value, got, ok, c.want, c.ok)
+		}
+	}
+	if _, ok := ParseLoadReport(metadata.MD{}); ok {
+		t.Error("un trailer sin informe se lee como informe")
+	}
+}
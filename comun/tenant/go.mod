@@ -0,0 +1,10 @@
+module comun/tenant
+
+go 1.15
+
+require (
+	google.golang.org/grpc v1.33.1
+	seguridad/auth v0.0.0
+)
+
+replace seguridad/auth => ../../Seguridad/auth
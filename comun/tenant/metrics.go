@@ -0,0 +1,41 @@
+package tenant
+
+import (
+	"expvar"
+	"sync"
+)
+
+//MaxMetricTenants máximo de tenants con entrada propia en cada métrica. Los siguientes se suman en OtherTenants, para que las
+//variables de /debug/vars no crezcan sin límite aunque aparezcan tenants nuevos constantemente
+const MaxMetricTenants = 100
+
+//OtherTenants entrada de las métricas en la que se suman los tenants que no caben
+const OtherTenants = "_otros"
+
+//Métricas por tenant, publicadas en /debug/vars
+var requestMetrics = expvar.NewMap("tenant_requests")
+
+var (
+	metricsMu sync.Mutex
+	//Tenants con entrada propia en cada métrica
+	metricTenants = map[*expvar.Map]map[string]bool{}
+)
+
+//AddMetric suma delta a la entrada del tenant en la métrica m, o a OtherTenants si m ya tiene MaxMetricTenants tenants
+func AddMetric(m *expvar.Map, tenant string, delta int64) {
+	metricsMu.Lock()
+	known := metricTenants[m]
+	if known == nil {
+		known = map[string]bool{}
+		metricTenants[m] = known
+	}
+	if !known[tenant] {
+		if len(known) < MaxMetricTenants {
+			known[tenant] = true
+		} else {
+			tenant = OtherTenants
+		}
+	}
+	metricsMu.Unlock()
+	m.Add(tenant, delta)
+}
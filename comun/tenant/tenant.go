@@ -0,0 +1,142 @@
+package tenant
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"seguridad/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//Default tenant de las llamadas anónimas, y al que pertenecen los datos de ejemplo
+const Default = "default"
+
+type tenantKey struct{}
+
+//NewContext guarda el tenant en el contexto
+func NewContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+//FromContext devuelve el tenant de la llamada. Si no hay ninguno devuelve Default
+func FromContext(ctx context.Context) string {
+	if t, ok := ctx.Value(tenantKey{}).(string); ok {
+		return t
+	}
+	return Default
+}
+
+//Resolver obtiene el tenant a partir de la identidad del llamante
+type Resolver struct {
+	//Tenant de cada token bearer. Los comprueba Authenticate
+	Tokens map[string]string
+	//Tenant de las llamadas sin identidad. Vacío las rechaza con Unauthenticated
+	Anonymous string
+	//Métodos que no tienen tenant, con el formato de auth.NewInterceptor: métodos completos o servicios terminados en "/". Sus
+	//llamadas pasan sin resolver el tenant aunque traigan authorization, que no ha verificado nadie porque la autenticación
+	//también se los salta. Normalmente auth.HealthAndReflection
+	Skip []string
+}
+
+//Resolve obtiene el tenant de una identidad verificada: el certificado de cliente verificado con mTLS o la identidad que deja en
+//el contexto un interceptor de autenticación, que tiene que ir antes. Las credenciales del metadato authorization nunca se leen
+//aquí: una llamada que las trae sin que nadie las haya verificado se rechaza, en lugar de usar el nombre que elige el llamante
+func (r *Resolver) Resolve(ctx context.Context) (string, error) {
+	//mTLS: la organización del certificado, o el common name si no tiene. El handshake ya lo ha verificado contra la CA
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 && len(info.State.VerifiedChains[0]) > 0 {
+			cert := info.State.VerifiedChains[0][0]
+			if len(cert.Subject.Organization) > 0 {
+				return cert.Subject.Organization[0], nil
+			}
+			if cert.Subject.CommonName != "" {
+				return cert.Subject.CommonName, nil
+			}
+		}
+	}
+
+	if id, ok := auth.FromContext(ctx); ok && id.Subject != "" {
+		return id.Subject, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get("authorization")) > 0 {
+		return "", status.Errorf(codes.Unauthenticated, "credenciales sin verificar")
+	}
+
+	if r.Anonymous == "" {
+		return "", status.Errorf(codes.Unauthenticated, "no se puede determinar el tenant")
+	}
+	return r.Anonymous, nil
+}
+
+//Authenticate comprueba que la llamada trae uno de los tokens bearer de Tokens. Es la función de autenticación de auth.Interceptor,
+//que la aplica tanto a las llamadas unitarias como a los streams
+func (r *Resolver) Authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	token, err := auth.BearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	//Se comparan todos los tokens, para que el tiempo de respuesta no dé pistas
+	tenant := ""
+	for t, name := range r.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			tenant = name
+		}
+	}
+	if tenant == "" {
+		return nil, errors.New("token desconocido")
+	}
+	//El sujeto es el tenant del token. La política RBAC le asigna los roles
+	return auth.NewContext(ctx, &auth.Identity{Subject: tenant}), nil
+}
+
+//UnaryServerInterceptor guarda el tenant en el contexto de las llamadas unitarias
+func (r *Resolver) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		//El método tal y como viaja, que es el que usan las listas de métodos. Ver auth.NewInterceptor
+		method, ok := grpc.Method(ctx)
+		if !ok {
+			method = info.FullMethod
+		}
+		if auth.MethodIn(r.Skip, method) {
+			return handler(ctx, req)
+		}
+		t, err := r.Resolve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		AddMetric(requestMetrics, t, 1)
+		return handler(NewContext(ctx, t), req)
+	}
+}
+
+//StreamServerInterceptor guarda el tenant en el contexto de los streams
+func (r *Resolver) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if auth.MethodIn(r.Skip, info.FullMethod) {
+			return handler(srv, ss)
+		}
+		t, err := r.Resolve(ss.Context())
+		if err != nil {
+			return err
+		}
+		AddMetric(requestMetrics, t, 1)
+		return handler(srv, &tenantStream{ServerStream: ss, ctx: NewContext(ss.Context(), t)})
+	}
+}
+
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context {
+	return s.ctx
+}
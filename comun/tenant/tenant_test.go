@@ -0,0 +1,130 @@
+package tenant
+
+import (
+	"context"
+	"encoding/base64"
+	"expvar"
+	"fmt"
+	"seguridad/auth"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func withAuthorization(v string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", v))
+}
+
+func TestResolve(t *testing.T) {
+	r := &Resolver{Tokens: map[string]string{"secreto-acme": "acme"}, Anonymous: Default}
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("acme:cualquiera"))
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		want string
+		code codes.Code
+	}{
+		{"anónima", context.Background(), Default, codes.OK},
+		{"identidad autenticada", auth.NewContext(context.Background(), &auth.Identity{Subject: "acme"}), "acme", codes.OK},
+		//El nombre de usuario lo elige el llamante: sin verificar no sirve como tenant
+		{"basic sin verificar", withAuthorization(basic), "", codes.Unauthenticated},
+		{"bearer sin verificar", withAuthorization("Bearer secreto-acme"), "", codes.Unauthenticated},
+	}
+	for _, c := range cases {
+		got, err := r.Resolve(c.ctx)
+		if status.Code(err) != c.code || got != c.want {
+			t.Errorf("%s: Resolve = %q, %v; quería %q, %v", c.name, got, err, c.want, c.code)
+		}
+	}
+
+	strict := &Resolver{}
+	if _, err := strict.Resolve(context.Background()); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("sin Anonymous: %v, quería Unauthenticated", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	r := &Resolver{Tokens: map[string]string{"secreto-acme": "acme"}, Anonymous: Default}
+
+	ctx, err := r.Authenticate(withAuthorization("Bearer secreto-acme"), "/ecommerce.OrderManagement/getOrder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Resolve(ctx); got != "acme" || err != nil {
+		t.Errorf("Resolve después de Authenticate = %q, %v; quería acme", got, err)
+	}
+
+	if _, err := r.Authenticate(withAuthorization("Bearer otro"), ""); err == nil {
+		t.Error("Authenticate acepta un token desconocido")
+	}
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("acme:x"))
+	if _, err := auth.Optional(r.Authenticate)(withAuthorization(basic), ""); err == nil {
+		t.Error("la autenticación opcional acepta basic auth, que el servidor no puede verificar")
+	}
+	if _, err := auth.Optional(r.Authenticate)(context.Background(), ""); err != nil {
+		t.Errorf("la autenticación opcional rechaza una llamada sin credenciales: %v", err)
+	}
+}
+
+func TestAddMetricIsBounded(t *testing.T) {
+	m := new(expvar.Map).Init()
+	for i := 0; i < MaxMetricTenants+50; i++ {
+		AddMetric(m, fmt.Sprint("tenant-", i), 1)
+	}
+	AddMetric(m, "tenant-0", 1)
+
+	n := 0
+	m.Do(func(expvar.KeyValue) { n++ })
+	if n != MaxMetricTenants+1 {
+		t.Errorf("%d entradas, quería %d más %s", n, MaxMetricTenants, OtherTenants)
+	}
+	if v := m.Get(OtherTenants).String(); v != "50" {
+		t.Errorf("%s = %s, quería 50", OtherTenants, v)
+	}
+	if v := m.Get("tenant-0").String(); v != "2" {
+		t.Errorf("tenant-0 = %s, quería 2", v)
+	}
+}
+
+//testStream stream del servidor con un contexto fijo
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+
+func TestResolverSkip(t *testing.T) {
+	r := &Resolver{Anonymous: Default, Skip: auth.HealthAndReflection}
+	//Una llamada con authorization que no ha verificado nadie, como las que deja pasar la autenticación en los servicios abiertos
+	ctx := withAuthorization("Bearer secreto-acme")
+	unary := func(method string) error {
+		_, err := r.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		return err
+	}
+	stream := func(method string) error {
+		return r.StreamServerInterceptor()(nil, &testStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: method}, func(srv interface{}, ss grpc.ServerStream) error {
+			return nil
+		})
+	}
+
+	if err := unary("/grpc.health.v1.Health/Check"); err != nil {
+		t.Errorf("health check con authorization: %v", err)
+	}
+	if err := stream("/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"); err != nil {
+		t.Errorf("reflexión con authorization: %v", err)
+	}
+	//El resto de métodos siguen necesitando una identidad verificada
+	if err := unary("/ecommerce.OrderManagement/getOrder"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("getOrder con authorization sin verificar: %v, quería Unauthenticated", err)
+	}
+	if err := stream("/ecommerce.OrderManagement/searchOrders"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("searchOrders con authorization sin verificar: %v, quería Unauthenticated", err)
+	}
+}
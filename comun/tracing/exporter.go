@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+//Exporter recibe los spans terminados
+type Exporter interface {
+	Export(*SpanData)
+}
+
+type noopExporter struct{}
+
+func (noopExporter) Export(*SpanData) {}
+
+//InMemoryExporter guarda los spans en memoria. Útil en pruebas
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+//NewInMemoryExporter crea un exporter en memoria
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+//Export guarda el span
+func (e *InMemoryExporter) Export(d *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, *d)
+}
+
+//Spans devuelve una copia de los spans exportados
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+//Reset borra los spans exportados
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+//FileExporter escribe los spans en formato JSON, uno por línea
+type FileExporter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+//NewFileExporter crea el fichero, o lo abre para añadir si ya existe
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{w: f, enc: json.NewEncoder(f)}, nil
+}
+
+//Export escribe el span
+func (e *FileExporter) Export(d *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(d); err != nil {
+		log.Printf("====== [Tracing] no se puede exportar el span %s: %v", d.Name, err)
+	}
+}
+
+//Close cierra el fichero
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//TraceparentKey metadato en el que viaja el contexto de la traza
+const TraceparentKey = "traceparent"
+
+//Inject añade el contexto de la traza a los metadatos de salida
+func Inject(ctx context.Context) context.Context {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(TraceparentKey, sc.Traceparent())
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+//Extract recupera el contexto de la traza de los metadatos de entrada
+func Extract(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	v := md.Get(TraceparentKey)
+	if len(v) == 0 {
+		return ctx
+	}
+	sc, err := ParseTraceparent(v[0])
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+//spanName nombre del span a partir del método: /ecommerce.OrderManagement/addOrder -> ecommerce.OrderManagement/addOrder
+func spanName(method string) string {
+	return strings.TrimPrefix(method, "/")
+}
+
+func setPeer(s *Span, p *peer.Peer) {
+	if p != nil && p.Addr != nil {
+		s.SetAttribute("net.peer", p.Addr.String())
+	}
+}
+
+func finish(s *Span, err error) {
+	s.SetStatus(status.Code(err).String(), err)
+	s.End()
+}
+
+//UnaryClientInterceptor crea un span por llamada y propaga el contexto en los metadatos
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, span := Start(ctx, spanName(method), KindClient)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.target", cc.Target())
+
+	var p peer.Peer
+	err := invoker(Inject(ctx), method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+
+	setPeer(span, &p)
+	finish(span, err)
+	return err
+}
+
+//StreamClientInterceptor crea un span por stream, y un span hijo por cada mensaje enviado o recibido
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, span := Start(ctx, spanName(method), KindClient)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.target", cc.Target())
+
+	p := &peer.Peer{}
+	s, err := streamer(Inject(ctx), desc, cc, method, append(opts, grpc.Peer(p))...)
+	if err != nil {
+		finish(span, err)
+		return nil, err
+	}
+	cs := &tracedClientStream{ClientStream: s, ctx: ctx, span: span, peer: p, serverStreams: desc.ServerStreams, done: make(chan struct{})}
+	//Si el cliente cancela el stream o vence el plazo sin leerlo hasta el final, RecvMsg no vuelve a llamarse y el span quedaría abierto
+	go func() {
+		select {
+		case <-ctx.Done():
+			cs.end(status.Error(contextCode(ctx.Err()), ctx.Err().Error()))
+		case <-cs.done:
+		}
+	}()
+	return cs, nil
+}
+
+//contextCode código gRPC con el que termina una llamada cuyo contexto se ha cancelado o ha vencido
+func contextCode(err error) codes.Code {
+	if err == context.DeadlineExceeded {
+		return codes.DeadlineExceeded
+	}
+	return codes.Canceled
+}
+
+type tracedClientStream struct {
+	grpc.ClientStream
+	ctx           context.Context
+	span          *Span
+	peer          *peer.Peer
+	serverStreams bool
+	sent, recv    int64
+	once          sync.Once
+	done          chan struct{}
+}
+
+func (s *tracedClientStream) SendMsg(m interface{}) error {
+	_, ms := Start(s.ctx, s.span.data.Name+"/send", KindInternal)
+	ms.SetAttribute("message.id", strconv.FormatInt(atomic.AddInt64(&s.sent, 1), 10))
+	err := s.ClientStream.SendMsg(m)
+	finish(ms, err)
+	return err
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	_, ms := Start(s.ctx, s.span.data.Name+"/recv", KindInternal)
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		//El fin del stream no es un mensaje
+		s.end(nil)
+		return err
+	}
+	ms.SetAttribute("message.id", strconv.FormatInt(atomic.AddInt64(&s.recv, 1), 10))
+	finish(ms, err)
+	if err != nil || !s.serverStreams {
+		s.end(err)
+	}
+	return err
+}
+
+//end termina el span del stream una sola vez: al leer el final, con un error o al cancelarse el contexto
+func (s *tracedClientStream) end(err error) {
+	s.once.Do(func() {
+		close(s.done)
+		setPeer(s.span, s.peer)
+		finish(s.span, err)
+	})
+}
+
+//UnaryServerInterceptor crea un span por llamada, hijo del contexto recibido en los metadatos
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method, _ := grpc.Method(ctx)
+	ctx, span := Start(Extract(ctx), spanName(method), KindServer)
+	span.SetAttribute("rpc.system", "grpc")
+	if p, ok := peer.FromContext(ctx); ok {
+		setPeer(span, p)
+	}
+
+	m, err := handler(ctx, req)
+
+	finish(span, err)
+	return m, err
+}
+
+//StreamServerInterceptor crea un span por stream, y un span hijo por cada mensaje enviado o recibido
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := Start(Extract(ss.Context()), spanName(info.FullMethod), KindServer)
+	span.SetAttribute("rpc.system", "grpc")
+	if p, ok := peer.FromContext(ctx); ok {
+		setPeer(span, p)
+	}
+
+	err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx, span: span})
+
+	finish(span, err)
+	return err
+}
+
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	span       *Span
+	sent, recv int64
+}
+
+//Context el contexto del stream lleva el span, para que los handlers puedan crear spans hijos
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *tracedServerStream) SendMsg(m interface{}) error {
+	_, ms := Start(s.ctx, s.span.data.Name+"/send", KindInternal)
+	ms.SetAttribute("message.id", strconv.FormatInt(atomic.AddInt64(&s.sent, 1), 10))
+	err := s.ServerStream.SendMsg(m)
+	finish(ms, err)
+	return err
+}
+
+func (s *tracedServerStream) RecvMsg(m interface{}) error {
+	_, ms := Start(s.ctx, s.span.data.Name+"/recv", KindInternal)
+	err := s.ServerStream.RecvMsg(m)
+	if err == io.EOF {
+		return err
+	}
+	ms.SetAttribute("message.id", strconv.FormatInt(atomic.AddInt64(&s.recv, 1), 10))
+	finish(ms, err)
+	return err
+}
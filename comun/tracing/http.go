@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+)
+
+//statusRecorder guarda el código de estado de la respuesta HTTP
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+//HTTPHandler crea un span por cada petición HTTP, hijo de la cabecera traceparent si viene informada. Las llamadas gRPC que se hagan con el contexto de la petición serán hijas de este span
+func HTTPHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := ParseTraceparent(r.Header.Get(TraceparentKey)); err == nil {
+			ctx = ContextWithRemote(ctx, sc)
+		}
+		ctx, span := Start(ctx, r.Method+" "+r.URL.Path, KindServer)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.url", r.URL.String())
+
+		//Devolvemos el traceparent para poder localizar la traza desde el cliente HTTP
+		w.Header().Set(TraceparentKey, span.SpanContext().Traceparent())
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", strconv.Itoa(rec.code))
+		span.SetStatus(http.StatusText(rec.code), nil)
+		span.End()
+	})
+}
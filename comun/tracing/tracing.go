@@ -0,0 +1,268 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//TraceID identificador de una traza
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+//SpanID identificador de un span
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+//SpanContext lo que se propaga entre procesos, con el formato traceparent de W3C Trace Context
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+//IsValid indica si el contexto tiene identificadores
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+//Traceparent codifica el contexto como la cabecera traceparent
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+//ParseTraceparent decodifica la cabecera traceparent
+func ParseTraceparent(h string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("traceparent no válido: %q", h)
+	}
+	//Solo las versiones futuras pueden añadir campos detrás de trace-flags
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("traceparent no válido para la versión 00: %q", h)
+	}
+	if b, err := hex.DecodeString(parts[1]); err != nil || len(b) != len(sc.TraceID) {
+		return sc, fmt.Errorf("trace-id no válido: %q", parts[1])
+	} else {
+		copy(sc.TraceID[:], b)
+	}
+	if b, err := hex.DecodeString(parts[2]); err != nil || len(b) != len(sc.SpanID) {
+		return sc, fmt.Errorf("parent-id no válido: %q", parts[2])
+	} else {
+		copy(sc.SpanID[:], b)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, fmt.Errorf("trace-flags no válido: %q", parts[3])
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("traceparent sin identificadores: %q", h)
+	}
+	return sc, nil
+}
+
+//SpanKind tipo de span
+type SpanKind string
+
+const (
+	//KindInternal operación dentro del proceso
+	KindInternal SpanKind = "internal"
+	//KindClient llamada saliente
+	KindClient SpanKind = "client"
+	//KindServer llamada entrante
+	KindServer SpanKind = "server"
+)
+
+//Event evento puntual dentro de un span
+type Event struct {
+	Name       string            `json:"name"`
+	Time       time.Time         `json:"time"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+//SpanData datos de un span terminado, tal y como se exportan
+type SpanData struct {
+	Name       string            `json:"name"`
+	Kind       SpanKind          `json:"kind"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Events     []Event           `json:"events,omitempty"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+}
+
+//Span operación en curso
+type Span struct {
+	mu     sync.Mutex
+	sc     SpanContext
+	data   SpanData
+	ended  bool
+	tracer *Tracer
+}
+
+//SpanContext devuelve el contexto del span, el que se propaga a las llamadas hijas
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+//SetAttribute añade un atributo al span
+func (s *Span) SetAttribute(k, v string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]string{}
+	}
+	s.data.Attributes[k] = v
+}
+
+//AddEvent añade un evento al span
+func (s *Span) AddEvent(name string, attrs map[string]string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now(), Attributes: attrs})
+}
+
+//SetStatus fija el estado final del span, por ejemplo el código gRPC
+func (s *Span) SetStatus(code string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = code
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+}
+
+//End termina el span y lo exporta. Solo tiene efecto la primera vez
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.export(&data)
+	}
+}
+
+//Tracer crea spans y los entrega al exporter
+type Tracer struct {
+	mu       sync.RWMutex
+	exporter Exporter
+}
+
+var defaultTracer = &Tracer{exporter: noopExporter{}}
+
+//SetExporter fija el exporter del tracer por defecto
+func SetExporter(e Exporter) {
+	defaultTracer.SetExporter(e)
+}
+
+//Start crea un span con el tracer por defecto
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return defaultTracer.Start(ctx, name, kind)
+}
+
+//SetExporter fija el exporter. Con nil los spans no se exportan
+func (t *Tracer) SetExporter(e Exporter) {
+	if e == nil {
+		e = noopExporter{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.exporter = e
+}
+
+func (t *Tracer) export(d *SpanData) {
+	t.mu.RLock()
+	e := t.exporter
+	t.mu.RUnlock()
+	e.Export(d)
+}
+
+//Start crea un span hijo del span del contexto, o del contexto remoto si lo hay. Si no hay ninguno empieza una traza nueva
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	s := &Span{
+		sc:     sc,
+		tracer: t,
+		data: SpanData{
+			Name:    name,
+			Kind:    kind,
+			TraceID: sc.TraceID.String(),
+			SpanID:  sc.SpanID.String(),
+			Start:   time.Now(),
+			Status:  "OK",
+		},
+	}
+	if parent.IsValid() {
+		s.data.ParentID = parent.SpanID.String()
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+//FromContext devuelve el span activo del contexto, o nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+//ContextWithRemote guarda en el contexto el span de otro proceso, recibido en la petición
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+//SpanContextFromContext devuelve el contexto del span activo o, si no lo hay, el recibido de otro proceso
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := FromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
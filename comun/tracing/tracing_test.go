@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	cases := []struct {
+		h       string
+		valid   bool
+		sampled bool
+	}{
+		{"00-" + traceID + "-" + spanID + "-01", true, true},
+		{"00-" + traceID + "-" + spanID + "-00", true, false},
+		//Las versiones futuras pueden añadir campos, la 00 no
+		{"01-" + traceID + "-" + spanID + "-01-extra", true, true},
+		{"00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"00-" + traceID + "-" + spanID, false, false},
+		{"ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"00-" + traceID + "-0000000000000000-01", false, false},
+		{"00-" + traceID + "-" + spanID + "-zz", false, false},
+	}
+	for _, c := range cases {
+		sc, err := ParseTraceparent(c.h)
+		if (err == nil) != c.valid {
+			t.Errorf("ParseTraceparent(%q): error %v, quería válido=%v", c.h, err, c.valid)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Sampled != c.sampled {
+			t.Errorf("ParseTraceparent(%q) = %+v", c.h, sc)
+		}
+	}
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+}
+
+func TestStreamSpanEndsOnCancel(t *testing.T) {
+	e := NewInMemoryExporter()
+	SetExporter(e)
+	defer SetExporter(nil)
+
+	cc, err := grpc.Dial("passthrough:///test", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return fakeClientStream{}, nil
+	}
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	if _, err := StreamClientInterceptor(ctx, desc, cc, "/ecommerce.OrderManagement/searchOrders", streamer); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(e.Spans()); n != 0 {
+		t.Fatalf("%d spans exportados antes de terminar el stream", n)
+	}
+
+	//El cliente abandona el stream sin leerlo hasta el final
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for len(e.Spans()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	spans := e.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans exportados, quería 1", len(spans))
+	}
+	if spans[0].Status != "Canceled" {
+		t.Errorf("estado %q, quería Canceled", spans[0].Status)
+	}
+}
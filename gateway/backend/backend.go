@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"log"
 	"net"
 
+	"comun/tracing"
 	wrapper "github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
@@ -17,6 +19,8 @@ const (
 	port = ":50051"
 )
 
+var traces = flag.String("traces", "", "fichero en el que se exportan los spans")
+
 // server is used to implement ecommerce/product_info.
 type server struct {
 	productMap map[string]*pb.Product
@@ -46,11 +50,24 @@ func (s *server) GetProduct(ctx context.Context, in *wrapper.StringValue) (*pb.P
 }
 
 func main() {
+	flag.Parse()
+
+	if *traces != "" {
+		exporter, err := tracing.NewFileExporter(*traces)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", *traces, err)
+		}
+		defer exporter.Close()
+		tracing.SetExporter(exporter)
+	}
+
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(tracing.UnaryServerInterceptor),
+		grpc.StreamInterceptor(tracing.StreamServerInterceptor))
 	pb.RegisterProductInfoServer(s, &server{})
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
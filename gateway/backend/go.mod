@@ -3,6 +3,7 @@ module gz.com/backend
 go 1.15
 
 require (
+	comun/tracing v0.0.0
 	github.com/golang/protobuf v1.4.1
 	github.com/google/uuid v1.1.2
 	github.com/grpc-ecosystem/grpc-gateway v1.15.2
@@ -10,3 +11,5 @@ require (
 	google.golang.org/grpc v1.33.1
 	google.golang.org/protobuf v1.25.0
 )
+
+replace comun/tracing => ../../comun/tracing
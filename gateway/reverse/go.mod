@@ -3,9 +3,12 @@ module gz.com/gateway
 go 1.15
 
 require (
+	comun/tracing v0.0.0
 	github.com/golang/protobuf v1.4.1
 	github.com/grpc-ecosystem/grpc-gateway v1.15.2
 	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.33.1
 	google.golang.org/protobuf v1.25.0
 )
+
+replace comun/tracing => ../../comun/tracing
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 
+	"comun/tracing"
 	gw "gz.com/gateway/ecommerce"
 
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
@@ -13,9 +15,21 @@ import (
 
 var (
 	grpcServerEndpoint = "localhost:50051"
+	traces             = flag.String("traces", "", "fichero en el que se exportan los spans")
 )
 
 func main() {
+	flag.Parse()
+
+	if *traces != "" {
+		exporter, err := tracing.NewFileExporter(*traces)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", *traces, err)
+		}
+		defer exporter.Close()
+		tracing.SetExporter(exporter)
+	}
+
 	//Creamos el contexto, sin timeouts, etc., con los valores por defecto
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
@@ -24,7 +38,10 @@ func main() {
 	// Register gRPC server endpoint
 	// Note: Make sure the gRPC server is running properly and accessible
 	mux := runtime.NewServeMux()
-	opts := []grpc.DialOption{grpc.WithInsecure()}
+	//Los interceptores de trazas propagan el span de la petición HTTP al backend
+	opts := []grpc.DialOption{grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(tracing.StreamClientInterceptor)}
 
 	//Registra el servidor RPC y nos crea un mux
 	err := gw.RegisterProductInfoHandlerFromEndpoint(ctx, mux, grpcServerEndpoint, opts)
@@ -35,7 +52,7 @@ func main() {
 	}
 
 	//Arranca el servidor http
-	if err := http.ListenAndServe(":8081", mux); err != nil {
+	if err := http.ListenAndServe(":8081", tracing.HTTPHandler(mux)); err != nil {
 		log.Fatalf("Could not setup HTTP endpoint: %v", err)
 	}
 }
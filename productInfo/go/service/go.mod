@@ -3,10 +3,21 @@ module productinfo/service
 go 1.15
 
 require (
+	comun/cache v0.0.0
+	comun/tenant v0.0.0
 	github.com/gofrs/uuid v3.2.0+incompatible
 	github.com/golang/protobuf v1.4.3
 	github.com/google/uuid v1.1.2
-	google.golang.org/grpc v1.33.0
+	google.golang.org/grpc v1.33.1
 	google.golang.org/protobuf v1.25.0
-
+	registry/servidor v0.0.0
+	seguridad/auth v0.0.0
 )
+
+replace registry/servidor => "../../../Beyond the Basics/order-service/registry"
+
+replace comun/cache => ../../../comun/cache
+
+replace comun/tenant => ../../../comun/tenant
+
+replace seguridad/auth => ../../../Seguridad/auth
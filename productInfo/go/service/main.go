@@ -1,9 +1,20 @@
 package main
 
 import (
+	"comun/cache"
+	"comun/tenant"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	pb "productinfo/service/ecommerce"
+	"registry/servidor/registry"
+	"seguridad/auth"
+	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 )
@@ -12,14 +23,72 @@ const (
 	port = ":50051"
 )
 
+var (
+	quota        = flag.Int("quota", 1000, "máximo de productos por tenant. 0 sin límite")
+	tenantTokens = flag.String("tenant-tokens", "", "fichero JSON con el tenant de cada token bearer")
+	requireAuth  = flag.Bool("require-auth", false, "exige en todas las llamadas uno de los tokens de -tenant-tokens")
+	registryAddr = flag.String("registry", "", "dirección del registro de servicios en el que se registra el servidor, por ejemplo localhost:50100")
+	advertise    = flag.String("advertise", "localhost"+port, "dirección con la que se registra el servidor")
+	zone         = flag.String("zone", "", "zona con la que se registra el servidor")
+)
+
 func main() {
+	flag.Parse()
+
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	pb.RegisterProductInfoServer(s, &server{})
+	//Caché de lecturas. addProduct la invalida
+	readCache := cache.NewReadCache(cache.New("products", 1000, 30*time.Second),
+		[]string{"/ecommerce.ProductInfo/getProduct"},
+		[]string{"/ecommerce.ProductInfo/addProduct"},
+		tenant.FromContext)
+
+	//El tenant se obtiene antes de la caché, que guarda las respuestas de cada tenant por separado
+	resolver := &tenant.Resolver{Anonymous: tenant.Default, Skip: auth.HealthAndReflection}
+	if *tenantTokens != "" {
+		b, err := ioutil.ReadFile(*tenantTokens)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", *tenantTokens, err)
+		}
+		if err := json.Unmarshal(b, &resolver.Tokens); err != nil {
+			log.Fatalf("failed to parse %s: %v", *tenantTokens, err)
+		}
+	}
+
+	//La autenticación va antes que el tenant, que solo usa identidades verificadas. Sin -require-auth las llamadas sin
+	//credenciales van al tenant por defecto, pero las que traen credenciales tienen que ser válidas
+	var unary []grpc.UnaryServerInterceptor
+	if len(resolver.Tokens) > 0 {
+		authenticate := auth.Optional(resolver.Authenticate)
+		if *requireAuth {
+			authenticate = resolver.Authenticate
+		}
+		unary = append(unary, auth.NewInterceptor(authenticate, auth.HealthAndReflection...).UnaryServerInterceptor())
+	} else if *requireAuth {
+		log.Fatalf("-require-auth needs -tenant-tokens")
+	}
+	unary = append(unary, resolver.UnaryServerInterceptor(), readCache.UnaryServerInterceptor())
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(unary...))
+	pb.RegisterProductInfoServer(s, &server{quota: *quota})
+
+	//Se registra como servicio products, y se da de baja al parar
+	if *registryAddr != "" {
+		agent, err := registry.Start(*registryAddr, &registry.Instance{Service: "products", Addr: *advertise, Weight: 1, Zone: *zone}, 10*time.Second)
+		if err != nil {
+			log.Fatalf("failed to connect to registry: %v", err)
+		}
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			agent.Stop()
+			s.GracefulStop()
+		}()
+	}
 
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
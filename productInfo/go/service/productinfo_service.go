@@ -1,17 +1,30 @@
 package main
 
 import (
+	"comun/tenant"
 	"context"
+	"expvar"
 	"log"
 	pb "productinfo/service/ecommerce"
+	"sync"
 
 	"github.com/gofrs/uuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+//Métricas por tenant, publicadas en /debug/vars
+var (
+	productMetrics = expvar.NewMap("tenant_products")
+	quotaMetrics   = expvar.NewMap("tenant_quota_rejections")
+)
+
+//server guarda los productos separados por tenant. Un tenant no ve los productos de otro
 type server struct {
-	productMap map[string]*pb.Product
+	mu         sync.RWMutex
+	productMap map[string]map[string]*pb.Product
+	//Máximo de productos por tenant. 0 sin límite
+	quota int
 }
 
 func (s *server) AddProduct(ctx context.Context, in *pb.Product) (*pb.ProductID, error) {
@@ -21,17 +34,32 @@ func (s *server) AddProduct(ctx context.Context, in *pb.Product) (*pb.ProductID,
 	}
 
 	in.Id = out.String()
+	t := tenant.FromContext(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.productMap == nil {
-		s.productMap = make(map[string]*pb.Product)
+		s.productMap = make(map[string]map[string]*pb.Product)
+	}
+	products, ok := s.productMap[t]
+	if !ok {
+		products = make(map[string]*pb.Product)
+		s.productMap[t] = products
+	}
+	if s.quota > 0 && len(products) >= s.quota {
+		tenant.AddMetric(quotaMetrics, t, 1)
+		return nil, status.Errorf(codes.ResourceExhausted, "tenant %s: cuota de productos agotada", t)
 	}
 
-	s.productMap[in.Id] = in
+	products[in.Id] = in
+	tenant.AddMetric(productMetrics, t, 1)
 	log.Printf("Product %v : %v - Added.", in.Id, in.Name)
 	return &pb.ProductID{Value: in.Id}, status.New(codes.OK, "").Err()
 }
 
 func (s *server) GetProduct(ctx context.Context, in *pb.ProductID) (*pb.Product, error) {
-	product, exists := s.productMap[in.Value]
+	s.mu.RLock()
+	product, exists := s.productMap[tenant.FromContext(ctx)][in.Value]
+	s.mu.RUnlock()
 	if exists {
 		log.Printf("Product %v : %v - Retrieved.", product.Id, product.Name)
 		return product, status.New(codes.OK, "").Err()